@@ -0,0 +1,301 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Batching strategies, defining how train data are grouped in mini-batches: in order of registration,
+// shuffled with a seed, or shuffled with a seed while keeping the proportion of each label in every batch.
+const (
+	sequentialBatching = "sequential"
+	shuffleBatching    = "shuffle"
+	stratifiedBatching = "stratified"
+)
+
+// Batching is the batching strategy of a problem.
+// LabelKey is the key of the extra metadata of data giving their label, for a stratified batching.
+type Batching struct {
+	Strategy string `json:"strategy"`
+	LabelKey string `json:"labelKey"`
+}
+
+// BatchAssignment structure, recording how train data were grouped in mini-batches when creating
+// learnuplets of an algo, stored with key batches_<uuid>, so that anyone can recompute the batches.
+// ObjectType is batchAssignment (necessary when switching to couchDB).
+// Seed is the ID of the transaction creating the learnuplets.
+// Data are the train data in order of registration, and Labels their labels for a stratified batching.
+// Batches are the data of each mini-batch, and Learnuplets the key of the learnuplet trained on each one.
+type BatchAssignment struct {
+	Record
+	ObjectType  string            `json:"docType"`
+	Problem     string            `json:"problem"`
+	Algo        string            `json:"algo"`
+	Strategy    string            `json:"strategy"`
+	Seed        string            `json:"seed"`
+	SizeBatch   int               `json:"sizeBatch"`
+	Data        []string          `json:"data"`
+	Labels      map[string]string `json:"labels"`
+	Batches     [][]string        `json:"batches"`
+	Learnuplets []string          `json:"learnuplets"`
+}
+
+// batchVerification is the result of the verification of a batch assignment
+type batchVerification struct {
+	Key        string   `json:"key"`
+	Valid      bool     `json:"valid"`
+	Mismatches []string `json:"mismatches"`
+}
+
+// validateBatching checks the batching strategy of problem settings, and sets the default one
+func validateBatching(settings *ProblemSettings) error {
+	batching := &settings.Batching
+	switch batching.Strategy {
+	case "":
+		batching.Strategy = sequentialBatching
+	case sequentialBatching, shuffleBatching, stratifiedBatching:
+	default:
+		return fmt.Errorf("strategy must be %s, %s or %s", sequentialBatching, shuffleBatching, stratifiedBatching)
+	}
+	if (batching.Strategy == stratifiedBatching) != (batching.LabelKey != "") {
+		return fmt.Errorf("a label key must be given for a %s batching, and only for it", stratifiedBatching)
+	}
+	return nil
+}
+
+// seededHash returns a pseudo-random number, derived from a seed and a value
+func seededHash(seed string, value string) uint64 {
+	sum := sha256.Sum256([]byte(seed + "/" + value))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// shuffle returns a permutation of keys, from a Fisher-Yates shuffle where the i-th draw is
+// the hash of the seed and i
+func shuffle(seed string, keys []string) []string {
+	shuffled := append([]string(nil), keys...)
+	for i := len(shuffled) - 1; i > 0; i-- {
+		j := int(seededHash(seed, strconv.Itoa(i)) % uint64(i+1))
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+	return shuffled
+}
+
+// stratify returns a permutation of keys in which labels are evenly spread: data of each label are
+// shuffled, then all data are ordered by their relative position in their label.
+func stratify(seed string, keys []string, labels map[string]string) []string {
+	groups := make(map[string][]string)
+	var names []string
+	for _, key := range keys {
+		label := labels[key]
+		if _, ok := groups[label]; !ok {
+			names = append(names, label)
+		}
+		groups[label] = append(groups[label], key)
+	}
+	sort.Strings(names)
+	type position struct {
+		key      string
+		label    string
+		position float64
+	}
+	var positions []position
+	for _, label := range names {
+		group := shuffle(seed+"/"+label, groups[label])
+		for i, key := range group {
+			positions = append(positions, position{key, label, (float64(i) + 0.5) / float64(len(group))})
+		}
+	}
+	sort.SliceStable(positions, func(i, j int) bool {
+		if positions[i].position != positions[j].position {
+			return positions[i].position < positions[j].position
+		}
+		return positions[i].label < positions[j].label
+	})
+	ordered := make([]string, len(positions))
+	for i, p := range positions {
+		ordered[i] = p.key
+	}
+	return ordered
+}
+
+// makeBatches groups data, in order of registration, in mini-batches of a given size according to
+// a batching strategy
+func makeBatches(strategy string, seed string, sizeBatch int, data []string, labels map[string]string) [][]string {
+	ordered := data
+	switch strategy {
+	case shuffleBatching:
+		ordered = shuffle(seed, data)
+	case stratifiedBatching:
+		ordered = stratify(seed, data, labels)
+	}
+	var batches [][]string
+	for i := 0; i < len(ordered); i += sizeBatch {
+		end := i + sizeBatch
+		if end > len(ordered) {
+			end = len(ordered)
+		}
+		batches = append(batches, append([]string(nil), ordered[i:end]...))
+	}
+	return batches
+}
+
+// newBatchAssignment groups the train data of new learnuplets of an algo in mini-batches according to
+// the batching strategy of the problem, with the transaction ID as seed.
+// Data are first ordered by registration time, then by key.
+func newBatchAssignment(APIstub shim.ChaincodeStubInterface, batching Batching, problemKey string,
+	algoKey string, trainData []string, sizeBatch int) (assignment BatchAssignment, err error) {
+
+	if sizeBatch <= 0 && len(trainData) > 0 {
+		return assignment, fmt.Errorf("size of mini-batches must be strictly positive")
+	}
+	strategy := batching.Strategy
+	if strategy == "" {
+		strategy = sequentialBatching
+	}
+	assignment = BatchAssignment{ObjectType: "batchAssignment", Problem: problemKey, Algo: algoKey,
+		Strategy: strategy, Seed: APIstub.GetTxID(), SizeBatch: sizeBatch}
+	created := make(map[string]string)
+	for _, dataKey := range trainData {
+		value, err := APIstub.GetState(dataKey)
+		if err != nil {
+			return assignment, err
+		}
+		data := Item{}
+		err = unmarshalRecord(APIstub, value, &data)
+		if err != nil {
+			return assignment, fmt.Errorf("Problem Unmarshal %s - %s", dataKey, err)
+		}
+		created[dataKey] = data.Metadata.Created
+		if strategy == stratifiedBatching {
+			if assignment.Labels == nil {
+				assignment.Labels = make(map[string]string)
+			}
+			assignment.Labels[dataKey] = data.Metadata.Extra[batching.LabelKey]
+		}
+	}
+	assignment.Data = append([]string(nil), trainData...)
+	// registration times are in UTC, and can be compared as strings
+	sort.Slice(assignment.Data, func(i, j int) bool {
+		a, b := assignment.Data[i], assignment.Data[j]
+		if created[a] != created[b] {
+			return created[a] < created[b]
+		}
+		return a < b
+	})
+	assignment.Batches = makeBatches(strategy, assignment.Seed, sizeBatch, assignment.Data, assignment.Labels)
+	return assignment, nil
+}
+
+// storeBatchAssignment stores a batch assignment
+func storeBatchAssignment(APIstub shim.ChaincodeStubInterface, assignmentKey string, assignment BatchAssignment) error {
+	assignmentAsBytes, err := marshalRecord(&assignment)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(assignmentKey, assignmentAsBytes)
+}
+
+// verifyBatches is the smart contract to check a batch assignment: its batches are recomputed from its
+// data, labels and seed, and compared with its recorded batches and the train data of its learnuplets.
+// Args (1 string): batchAssignmentKey (batchAssignment field of learnuplets)
+func (s *SmartContract) verifyBatches(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1: batchAssignmentKey")
+	}
+	assignmentKey := args[0]
+	fmt.Printf("- start verifying batches %s \n", assignmentKey)
+
+	value, err := APIstub.GetState(assignmentKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if value == nil {
+		return shim.Error("No batch assignment with key " + assignmentKey)
+	}
+	assignment := BatchAssignment{}
+	err = unmarshalRecord(APIstub, value, &assignment)
+	if err != nil {
+		return shim.Error("Problem Unmarshal " + assignmentKey + " - " + err.Error())
+	}
+
+	verification := batchVerification{Key: assignmentKey, Mismatches: []string{}}
+	batches := makeBatches(assignment.Strategy, assignment.Seed, assignment.SizeBatch, assignment.Data, assignment.Labels)
+	if !reflect.DeepEqual(batches, assignment.Batches) {
+		verification.Mismatches = append(verification.Mismatches, "recorded batches differ from the recomputed ones")
+	}
+	if len(assignment.Learnuplets) != len(batches) {
+		verification.Mismatches = append(verification.Mismatches,
+			fmt.Sprintf("%d learnuplets for %d batches", len(assignment.Learnuplets), len(batches)))
+	}
+	for i, learnupletKey := range assignment.Learnuplets {
+		learnuplet := Learnuplet{}
+		value, err := APIstub.GetState(learnupletKey)
+		if err == nil {
+			err = unmarshalRecord(APIstub, value, &learnuplet)
+		}
+		if err != nil {
+			return shim.Error("Problem Unmarshal " + learnupletKey + " - " + err.Error())
+		}
+		if i >= len(batches) || learnuplet.BatchAssignment != assignmentKey || learnuplet.Batch != i ||
+			len(learnuplet.TrainData) != len(batches[i]) {
+			verification.Mismatches = append(verification.Mismatches, learnupletKey+" is not trained on its batch")
+			continue
+		}
+		for _, dataKey := range batches[i] {
+			if _, ok := learnuplet.TrainData[dataKey]; !ok {
+				verification.Mismatches = append(verification.Mismatches, learnupletKey+" is not trained on "+dataKey)
+			}
+		}
+	}
+	verification.Valid = len(verification.Mismatches) == 0
+
+	payload, err := json.Marshal(verification)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end verifying batches %s \n", assignmentKey)
+	return shim.Success(payload)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestValidateBatching(t *testing.T) {
+	tests := []struct {
+		batching Batching
+		valid    bool
+	}{
+		{Batching{}, true},
+		{Batching{Strategy: shuffleBatching}, true},
+		{Batching{Strategy: stratifiedBatching, LabelKey: "label"}, true},
+		{Batching{Strategy: stratifiedBatching}, false},
+		{Batching{Strategy: shuffleBatching, LabelKey: "label"}, false},
+		{Batching{Strategy: "random"}, false},
+	}
+	for _, test := range tests {
+		settings := ProblemSettings{Batching: test.batching}
+		err := validateBatching(&settings)
+		if (err == nil) != test.valid {
+			t.Errorf("Validation of %+v should be %t - %v", test.batching, test.valid, err)
+		}
+		if err == nil && settings.Batching.Strategy == "" {
+			t.Errorf("Default batching strategy not set")
+		}
+	}
+}
+
+func TestMakeBatches(t *testing.T) {
+	var data []string
+	labels := make(map[string]string)
+	for i := 0; i < 12; i++ {
+		key := fmt.Sprintf("data_%02d", i)
+		data = append(data, key)
+		// 8 cats and 4 dogs
+		labels[key] = "cat"
+		if i%3 == 0 {
+			labels[key] = "dog"
+		}
+	}
+
+	sequential := makeBatches(sequentialBatching, "tx1", 5, data, nil)
+	shuffled := makeBatches(shuffleBatching, "tx1", 5, data, nil)
+	again := makeBatches(shuffleBatching, "tx1", 5, data, nil)
+	otherSeed := makeBatches(shuffleBatching, "tx2", 5, data, nil)
+	stratified := makeBatches(stratifiedBatching, "tx1", 3, data, labels)
+
+	if !reflect.DeepEqual(sequential, [][]string{data[:5], data[5:10], data[10:]}) {
+		t.Errorf("Sequential batches should follow the order of data: %v", sequential)
+	}
+	if !reflect.DeepEqual(shuffled, again) || reflect.DeepEqual(shuffled, otherSeed) || reflect.DeepEqual(shuffled, sequential) {
+		t.Errorf("Shuffled batches should only depend on the seed: %v, %v", shuffled, otherSeed)
+	}
+	var all []string
+	for _, batch := range shuffled {
+		all = append(all, batch...)
+	}
+	sort.Strings(all)
+	if len(shuffled) != 3 || len(shuffled[2]) != 2 || !reflect.DeepEqual(all, data) {
+		t.Errorf("Shuffled batches are not a partition of data: %v", shuffled)
+	}
+	for i, batch := range stratified {
+		nbDogs := 0
+		for _, key := range batch {
+			if labels[key] == "dog" {
+				nbDogs++
+			}
+		}
+		if len(batch) != 3 || nbDogs != 1 {
+			t.Errorf("Batch %d is not stratified: %v", i, batch)
+		}
+	}
+}
+
+func TestVerifyBatches(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	mockStub.MockTransactionStart("mockTxID")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "2", "", `{"batching": {"strategy": "stratified", "labelKey": "label"}}`)
+	for i, label := range []string{"cat", "cat", "dog", "cat", "dog", "dog"} {
+		response := smartContract.registerItem(mockStub, []string{"data", fmt.Sprintf("8fa81bfc-b5f4-4ba2-b81a-b464248000%02d", i),
+			problemKey, "", `{"extra": {"label": "` + label + `"}}`})
+		if response.GetStatus() != 200 {
+			t.Fatalf("Registration of data fails - %s", response.Message)
+		}
+	}
+	registerTestItems(t, smartContract, mockStub, problemKey, 0, "algo1")
+	mockStub.MockTransactionEnd("mockTxID")
+	learnupletKeys := getKeys(t, mockStub, "learnuplet")
+	learnuplet := Learnuplet{}
+	json.Unmarshal(mockStub.State[learnupletKeys[0]], &learnuplet)
+	assignmentKey := learnuplet.BatchAssignment
+	verify := func() batchVerification {
+		mockStub.MockTransactionStart("mockTxID_verify")
+		response := smartContract.verifyBatches(mockStub, []string{assignmentKey})
+		mockStub.MockTransactionEnd("mockTxID_verify")
+		if response.GetStatus() != 200 {
+			t.Fatalf("verifyBatches fails - %s", response.Message)
+		}
+		verification := batchVerification{}
+		json.Unmarshal(response.GetPayload(), &verification)
+		return verification
+	}
+
+	// ACT
+	valid := verify()
+	// a learnuplet trained on other data than its batch is detected
+	assignment := BatchAssignment{}
+	json.Unmarshal(mockStub.State[assignmentKey], &assignment)
+	mockStub.MockTransactionStart("mockTxID_tamper")
+	tampered := learnuplet
+	tampered.TrainData = map[string]string{assignment.Data[0]: "", assignment.Data[1]: ""}
+	if reflect.DeepEqual(assignment.Batches[learnuplet.Batch], assignment.Data[:2]) {
+		tampered.TrainData = map[string]string{assignment.Data[2]: "", assignment.Data[3]: ""}
+	}
+	storeLearnuplet(mockStub, learnupletKeys[0], tampered)
+	mockStub.MockTransactionEnd("mockTxID_tamper")
+	invalidLearnuplet := verify()
+	// recorded batches which do not follow from the data and the seed are detected
+	mockStub.MockTransactionStart("mockTxID_tamper")
+	storeLearnuplet(mockStub, learnupletKeys[0], learnuplet)
+	tamperedAssignment := assignment
+	tamperedAssignment.Batches = [][]string{assignment.Batches[1], assignment.Batches[0], assignment.Batches[2]}
+	storeBatchAssignment(mockStub, assignmentKey, tamperedAssignment)
+	mockStub.MockTransactionEnd("mockTxID_tamper")
+	invalidBatches := verify()
+
+	// ASSERT
+	if len(learnupletKeys) != 3 || assignment.Strategy != stratifiedBatching || len(assignment.Learnuplets) != 3 {
+		t.Fatalf("Unexpected batch assignment %+v for %d learnuplets", assignment, len(learnupletKeys))
+	}
+	for _, batch := range assignment.Batches {
+		if len(batch) != 2 || assignment.Labels[batch[0]] == assignment.Labels[batch[1]] {
+			t.Errorf("Batch %v does not have a dog and a cat", batch)
+		}
+	}
+	if !valid.Valid || len(valid.Mismatches) != 0 {
+		t.Errorf("Batch assignment should be valid: %+v", valid)
+	}
+	if invalidLearnuplet.Valid || invalidBatches.Valid {
+		t.Errorf("Tampered batches should not be valid: %+v, %+v", invalidLearnuplet, invalidBatches)
+	}
+}
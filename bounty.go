@@ -0,0 +1,318 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Roles of the organisations paid out from a bounty, and of the owner of the problem, whose
+// credits are held in escrow and refunded if the bounty is released
+const (
+	bountyRole     = "bounty"
+	bountyDataRole = "bountyData"
+	escrowRole     = "escrow"
+	refundRole     = "refund"
+)
+
+// Bounty is the reward posted by the owner of a problem for its best algo.
+// Amount tokens are held in escrow until Deadline (RFC 3339), after which the problem can be closed:
+// the owner of the best algo of the leaderboard is paid out, and DataShare (between 0 and 1) of the
+// amount is shared between the owners of the data its learnuplets trained on.
+type Bounty struct {
+	Amount    int     `json:"amount"`
+	Deadline  string  `json:"deadline"`
+	DataShare float64 `json:"dataShare"`
+}
+
+// Payout is an amount of tokens paid out from an escrow to an organisation
+type Payout struct {
+	Owner  string `json:"owner"`
+	Role   string `json:"role"`
+	Amount int    `json:"amount"`
+}
+
+// Escrow holds the tokens of the bounty of a problem until it is closed, debited from the balance of its owner.
+// Status is held until the problem is closed, then paid, or released if no model was done:
+// the tokens are then refunded to the owner of the problem.
+// Winner is the key of the algo paid out, Payouts the amounts paid to each organisation (the payout trail,
+// each payout being also recorded as a contribution), and ClosedTxID the transaction which closed the problem.
+type Escrow struct {
+	Record
+	ObjectType string   `json:"docType"`
+	Problem    string   `json:"problem"`
+	Owner      string   `json:"owner"`
+	Bounty     Bounty   `json:"bounty"`
+	Status     string   `json:"status"`
+	Winner     string   `json:"winner"`
+	Payouts    []Payout `json:"payouts"`
+	ClosedTxID string   `json:"closedTxID"`
+	ClosedAt   string   `json:"closedAt"`
+}
+
+// validateBounty checks the bounty of problem settings
+func validateBounty(settings *ProblemSettings) error {
+	bounty := settings.Bounty
+	if bounty == (Bounty{}) {
+		return nil
+	}
+	if bounty.Amount <= 0 {
+		return fmt.Errorf("amount must be strictly positive")
+	}
+	if _, err := time.Parse(time.RFC3339, bounty.Deadline); err != nil {
+		return fmt.Errorf("deadline must be a RFC 3339 time - %s", err)
+	}
+	if math.IsNaN(bounty.DataShare) || bounty.DataShare < 0 || bounty.DataShare > 1 {
+		return fmt.Errorf("dataShare must be between 0 and 1")
+	}
+	return nil
+}
+
+// hasBounty returns true if a bounty is posted for the problem
+func (problem Problem) hasBounty() bool {
+	return problem.Bounty.Amount > 0
+}
+
+// createEscrow holds the tokens of the bounty of a new problem in escrow, and returns the key of the escrow.
+// The deadline must not be passed, and the tokens are debited from the balance of the owner, which must
+// cover them. The debit is recorded as a contribution of the owner.
+func createEscrow(APIstub shim.ChaincodeStubInterface, problemKey string, owner string, bounty Bounty) (string, error) {
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return "", err
+	}
+	deadline, _ := time.Parse(time.RFC3339, bounty.Deadline)
+	if !deadline.After(txTime) {
+		return "", fmt.Errorf("deadline of the bounty %s is passed", bounty.Deadline)
+	}
+	balance, err := getBalance(APIstub, owner)
+	if err != nil {
+		return "", err
+	}
+	if balance.Total < bounty.Amount {
+		return "", fmt.Errorf("balance of %s (%d credits) is too low for a bounty of %d", owner, balance.Total, bounty.Amount)
+	}
+	escrowKey := "escrow_" + strings.TrimPrefix(problemKey, "problem_")
+	err = recordContributions(APIstub, escrowKey, []Contribution{{Owner: owner, Problem: problemKey, Role: escrowRole,
+		Item: escrowKey, Credits: -bounty.Amount, CreditedAt: txTime.Format(time.RFC3339)}})
+	if err != nil {
+		return "", err
+	}
+	escrow := Escrow{ObjectType: "escrow", Problem: problemKey, Owner: owner, Bounty: bounty, Status: "held"}
+	return escrowKey, storeEscrow(APIstub, escrowKey, escrow)
+}
+
+// getEscrow retrieves an escrow from the ledger given its key
+func getEscrow(APIstub shim.ChaincodeStubInterface, escrowKey string) (escrow Escrow, err error) {
+	value, err := APIstub.GetState(escrowKey)
+	if err != nil {
+		return escrow, err
+	}
+	if value == nil {
+		return escrow, fmt.Errorf("no escrow with key %s", escrowKey)
+	}
+	err = unmarshalRecord(APIstub, value, &escrow)
+	return escrow, err
+}
+
+// storeEscrow stores an escrow in the ledger
+func storeEscrow(APIstub shim.ChaincodeStubInterface, escrowKey string, escrow Escrow) error {
+	escrowAsBytes, err := marshalRecord(&escrow)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(escrowKey, escrowAsBytes)
+}
+
+// getDataOwners returns the number of distinct data owned by each organisation among the train data
+// of the done learnuplets of an algo
+func getDataOwners(APIstub shim.ChaincodeStubInterface, algoKey string) (map[string]int, error) {
+	learnuplets, err := getLearnupletsByIndex(APIstub, "algo", algoKey)
+	if err != nil {
+		return nil, err
+	}
+	dataKeys := make(map[string]bool)
+	for _, learnuplet := range learnuplets {
+		if learnuplet.Status != "done" {
+			continue
+		}
+		for dataKey := range learnuplet.TrainData {
+			dataKeys[dataKey] = true
+		}
+	}
+	owners := make(map[string]int)
+	for dataKey := range dataKeys {
+		data, err := getItem(APIstub, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		owners[data.Owner]++
+	}
+	return owners, nil
+}
+
+// payBounty computes the payouts of the bounty of an escrow to the owner of the winning algo and to the
+// owners of the data it trained on, in proportion to their number of data. Amounts are rounded down,
+// the rest going to the owner of the algo.
+func payBounty(bounty Bounty, algoOwner string, dataOwners map[string]int) []Payout {
+	var owners []string
+	nbData := 0
+	for owner, count := range dataOwners {
+		owners = append(owners, owner)
+		nbData += count
+	}
+	sort.Strings(owners)
+	var payouts []Payout
+	rest := bounty.Amount
+	if nbData > 0 {
+		dataAmount := int(math.Floor(float64(bounty.Amount) * bounty.DataShare))
+		for _, owner := range owners {
+			amount := dataAmount * dataOwners[owner] / nbData
+			if amount == 0 {
+				continue
+			}
+			payouts = append(payouts, Payout{Owner: owner, Role: bountyDataRole, Amount: amount})
+			rest -= amount
+		}
+	}
+	return append([]Payout{{Owner: algoOwner, Role: bountyRole, Amount: rest}}, payouts...)
+}
+
+// closeProblem is the smart contract to close a problem: it is archived, and its bounty is paid out.
+// Problems with a bounty can be closed by anyone once the deadline is passed: the owner of the best algo
+// of the leaderboard (on the primary metric) is paid out, and the owners of the data it trained on get their
+// share. If no model is done, the tokens are released to the owner of the problem.
+// Problems without bounty can only be closed by their owner (see checkProblemOwner).
+// Args (1 string): problemKey
+func (s *SmartContract) closeProblem(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1: problemKey")
+	}
+	problemKey := args[0]
+	fmt.Printf("- start closing problem %s \n", problemKey)
+
+	problem, err := getProblem(APIstub, problemKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if problem.Status == "archived" {
+		return shim.Error("Problem " + problemKey + " is already archived")
+	}
+	if !problem.hasBounty() {
+		if err = checkProblemOwner(APIstub, problemKey, problem); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	if problem.hasBounty() {
+		err = closeEscrow(APIstub, problemKey, problem)
+		if err != nil {
+			return shim.Error("Problem paying out bounty - " + err.Error())
+		}
+	}
+	problem.Status = "archived"
+	err = storeProblem(APIstub, problemKey, problem)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end closing problem %s \n", problemKey)
+	return shim.Success(nil)
+}
+
+// closeEscrow pays out the bounty of a problem whose deadline is passed, or refunds it to the owner of
+// the problem if no model was done
+func closeEscrow(APIstub shim.ChaincodeStubInterface, problemKey string, problem Problem) error {
+	escrow, err := getEscrow(APIstub, problem.Escrow)
+	if err != nil {
+		return err
+	}
+	if escrow.Status != "held" {
+		return fmt.Errorf("bounty is already %s", escrow.Status)
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	deadline, err := time.Parse(time.RFC3339, escrow.Bounty.Deadline)
+	if err != nil {
+		return err
+	}
+	if txTime.Before(deadline) {
+		return fmt.Errorf("deadline %s is not passed", escrow.Bounty.Deadline)
+	}
+	escrow.ClosedTxID = APIstub.GetTxID()
+	escrow.ClosedAt = txTime.Format(time.RFC3339)
+
+	leaderboard, err := getLeaderboard(APIstub, problemKey, problem, problem.getPrimaryMetric())
+	if err != nil {
+		return err
+	}
+	if len(leaderboard) == 0 {
+		escrow.Status = "released"
+		escrow.Payouts = []Payout{{Owner: escrow.Owner, Role: refundRole, Amount: escrow.Bounty.Amount}}
+		fmt.Printf("-- no model done, bounty of %s released \n", problemKey)
+	} else {
+		escrow.Winner = leaderboard[0].Algo
+		algo, err := getItem(APIstub, escrow.Winner)
+		if err != nil {
+			return err
+		}
+		dataOwners, err := getDataOwners(APIstub, escrow.Winner)
+		if err != nil {
+			return err
+		}
+		escrow.Payouts = payBounty(escrow.Bounty, algo.Owner, dataOwners)
+		escrow.Status = "paid"
+		fmt.Printf("-- bounty of %s paid out to %s \n", problemKey, algo.Owner)
+	}
+	var contributions []Contribution
+	for _, payout := range escrow.Payouts {
+		contributions = append(contributions, Contribution{Owner: payout.Owner, Problem: problemKey, Role: payout.Role,
+			Item: problem.Escrow, Credits: payout.Amount, CreditedAt: escrow.ClosedAt})
+	}
+	err = recordContributions(APIstub, problem.Escrow, contributions)
+	if err != nil {
+		return err
+	}
+	return storeEscrow(APIstub, problem.Escrow, escrow)
+}
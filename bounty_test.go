@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+func TestValidateBounty(t *testing.T) {
+	tests := []struct {
+		bounty Bounty
+		valid  bool
+	}{
+		{Bounty{}, true},
+		{Bounty{Amount: 100, Deadline: "2030-01-01T00:00:00Z"}, true},
+		{Bounty{Amount: 100, Deadline: "2030-01-01T00:00:00Z", DataShare: 0.3}, true},
+		{Bounty{Amount: 100}, false},
+		{Bounty{Amount: -1, Deadline: "2030-01-01T00:00:00Z"}, false},
+		{Bounty{Deadline: "2030-01-01T00:00:00Z"}, false},
+		{Bounty{Amount: 100, Deadline: "2030-01-01T00:00:00Z", DataShare: 1.5}, false},
+	}
+	for _, test := range tests {
+		settings := ProblemSettings{Bounty: test.bounty}
+		err := validateBounty(&settings)
+		if (err == nil) != test.valid {
+			t.Errorf("Validation of %+v should be %t - %v", test.bounty, test.valid, err)
+		}
+	}
+	payouts := payBounty(Bounty{Amount: 101, DataShare: 0.5}, "OrgC", map[string]int{"OrgB": 3, "OrgE": 1})
+	if len(payouts) != 3 || payouts[0] != (Payout{"OrgC", bountyRole, 52}) || payouts[1] != (Payout{"OrgB", bountyDataRole, 37}) ||
+		payouts[2] != (Payout{"OrgE", bountyDataRole, 12}) {
+		t.Errorf("Bounty not paid out as expected: %+v", payouts)
+	}
+}
+
+func TestCloseProblem(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	start := int64(1500000000)
+	deadline := time.Unix(start+1000, 0).UTC().Format(time.RFC3339)
+	tx := 0
+	// invoke calls a smart contract as an organisation in its own transaction, a given number of seconds after start
+	invoke := func(org string, seconds int64, function func(shim.ChaincodeStubInterface, []string) sc.Response,
+		args ...string) sc.Response {
+		tx++
+		txId := fmt.Sprintf("mockTxID_%d", tx)
+		mockStub.MockTransactionStart(txId)
+		defer mockStub.MockTransactionEnd(txId)
+		mockStub.TxTimestamp = &timestamp.Timestamp{Seconds: start + seconds}
+		setCreator(t, mockStub, org)
+		return function(mockStub, args)
+	}
+	balance := func(org string) int {
+		balance := Balance{}
+		json.Unmarshal(invoke(org, 0, smartContract.queryBalance).Payload, &balance)
+		return balance.Total
+	}
+	fundTestOrg(t, mockStub, "OrgA", 150)
+
+	// ACT
+	unfunded := invoke("OrgF", 0, smartContract.registerProblem, "dda81bfc-b5f4-5ba2-b81a-b464248f02d2", "4",
+		"data_0, data_1", "", `{"bounty": {"amount": 101, "deadline": "`+deadline+`", "dataShare": 0.5}}`)
+	passed := invoke("OrgA", 2000, smartContract.registerProblem, "dda81bfc-b5f4-5ba2-b81a-b464248f02d2", "4",
+		"data_0, data_1", "", `{"bounty": {"amount": 101, "deadline": "`+deadline+`", "dataShare": 0.5}}`)
+	registered := invoke("OrgA", 0, smartContract.registerProblem, "dda81bfc-b5f4-5ba2-b81a-b464248f02d2", "4",
+		"data_0, data_1", "", `{"bounty": {"amount": 101, "deadline": "`+deadline+`", "dataShare": 0.5}}`)
+	problemKey := getKeys(t, mockStub, "problem")[0]
+	for i := 0; i < 3; i++ {
+		invoke("OrgB", 0, smartContract.registerItem, "data", fmt.Sprintf("8fa81bfc-b5f4-4ba2-b81a-b464248000%02d", i), problemKey, "")
+	}
+	invoke("OrgE", 0, smartContract.registerItem, "data", "8fa81bfc-b5f4-4ba2-b81a-b46424800099", problemKey, "")
+	invoke("OrgC", 0, smartContract.registerItem, "algo", "8fa81bfc-b5f4-4ba2-b81a-algo1", problemKey, "algo1")
+	invoke("OrgD", 0, smartContract.registerItem, "algo", "8fa81bfc-b5f4-4ba2-b81a-algo2", problemKey, "algo2")
+	// the model of algo1 is better than the one of algo2
+	for _, key := range getKeys(t, mockStub, "learnuplet") {
+		learnuplet := Learnuplet{}
+		json.Unmarshal(mockStub.State[key], &learnuplet)
+		learnuplet.Status = "done"
+		learnuplet.Perfs = map[string]float64{"perf": 0.6}
+		if learnuplet.Algo[getAlgoKey(learnuplet)] == "8fa81bfc-b5f4-4ba2-b81a-algo1" {
+			learnuplet.Perfs["perf"] = 0.9
+		}
+		learnuplet.Perf = learnuplet.Perfs["perf"]
+		mockStub.MockTransactionStart("mockTxID")
+		storeLearnuplet(mockStub, key, learnuplet)
+		mockStub.MockTransactionEnd("mockTxID")
+	}
+	early := invoke("OrgZ", 500, smartContract.closeProblem, problemKey)
+	archived := invoke("OrgA", 500, smartContract.setProblemStatus, problemKey, "archived")
+	closed := invoke("OrgZ", 1000, smartContract.closeProblem, problemKey)
+	closedAgain := invoke("OrgZ", 1000, smartContract.closeProblem, problemKey)
+	problem, _ := getProblem(mockStub, problemKey)
+	escrow, _ := getEscrow(mockStub, problem.Escrow)
+	var contributions []Contribution
+	json.Unmarshal(invoke("OrgZ", 0, smartContract.queryContributions, "OrgE", problemKey).Payload, &contributions)
+
+	// ASSERT
+	if passed.Status == 200 {
+		t.Errorf("Bounty with a passed deadline should be rejected")
+	}
+	if unfunded.Status == 200 {
+		t.Errorf("Bounty should be covered by the balance of the owner")
+	}
+	if registered.Status != 200 || problem.Owner != "OrgA" || problem.Escrow == "" {
+		t.Fatalf("Problem with a bounty not registered as expected - %s %+v", registered.Message, problem)
+	}
+	if balance("OrgA") != 49 {
+		t.Errorf("Bounty not debited from the balance of the owner: %d credits left", balance("OrgA"))
+	}
+	if early.Status == 200 || archived.Status == 200 {
+		t.Errorf("Problem with a bounty should not be closed before its deadline")
+	}
+	if closed.Status != 200 || closedAgain.Status == 200 || problem.Status != "archived" {
+		t.Fatalf("Problem not closed as expected - %s", closed.Message)
+	}
+	if escrow.Status != "paid" || escrow.Winner == "" || len(escrow.Payouts) != 3 || escrow.ClosedAt != deadline {
+		t.Errorf("Payout trail not recorded as expected: %+v", escrow)
+	}
+	// 50 tokens are shared between the owners of the 4 train data, the rest goes to the owner of algo1
+	if balance("OrgC") != 52 || balance("OrgB") != 37 || balance("OrgE") != 12 || balance("OrgD") != 0 {
+		t.Errorf("Bounty not paid out as expected: %+v", escrow.Payouts)
+	}
+	if len(contributions) != 1 || contributions[0].Role != bountyDataRole || contributions[0].Item != problem.Escrow {
+		t.Errorf("Payouts not recorded as contributions: %+v", contributions)
+	}
+}
+
+func TestReleaseBounty(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	fundTestOrg(t, mockStub, "OrgA", 100)
+	start := int64(1500000000)
+	deadline := time.Unix(start+1000, 0).UTC().Format(time.RFC3339)
+	mockStub.MockTransactionStart("mockTxID_register")
+	mockStub.TxTimestamp = &timestamp.Timestamp{Seconds: start}
+	setCreator(t, mockStub, "OrgA")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1", "", `{"bounty": {"amount": 100, "deadline": "`+deadline+`"}}`)
+	mockStub.MockTransactionEnd("mockTxID_register")
+	held, _ := getBalance(mockStub, "OrgA")
+
+	// ACT
+	mockStub.MockTransactionStart("mockTxID_close")
+	mockStub.TxTimestamp = &timestamp.Timestamp{Seconds: start + 1000}
+	setCreator(t, mockStub, "OrgZ")
+	closed := smartContract.closeProblem(mockStub, []string{problemKey})
+	mockStub.MockTransactionEnd("mockTxID_close")
+
+	// ASSERT
+	if closed.Status != 200 {
+		t.Fatalf("Problem not closed - %s", closed.Message)
+	}
+	problem, _ := getProblem(mockStub, problemKey)
+	escrow, _ := getEscrow(mockStub, problem.Escrow)
+	released, _ := getBalance(mockStub, "OrgA")
+	if held.Total != 0 || escrow.Status != "released" || released.Total != 100 {
+		t.Errorf("Bounty not refunded: %d then %d credits, escrow %+v", held.Total, released.Total, escrow)
+	}
+	var contributions []Contribution
+	setCreator(t, mockStub, "OrgA")
+	json.Unmarshal(smartContract.queryContributions(mockStub, []string{"", problemKey}).Payload, &contributions)
+	if len(contributions) != 2 || contributions[0].Role != escrowRole || contributions[0].Credits != -100 ||
+		contributions[1].Role != refundRole || contributions[1].Credits != 100 {
+		t.Errorf("Escrow and refund not recorded as contributions: %+v", contributions)
+	}
+}
+
+// fundTestOrg sets the balance of an organisation
+func fundTestOrg(t *testing.T, mockStub *testStub, org string, credits int) {
+	mockStub.MockTransactionStart("mockTxID_fund")
+	defer mockStub.MockTransactionEnd("mockTxID_fund")
+	if err := storeBalance(mockStub, Balance{ObjectType: "balance", Owner: org, Total: credits}); err != nil {
+		t.Fatalf("Balance of %s not stored - %s", org, err)
+	}
+}
+
+func TestCloseProblemWithoutBounty(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	mockStub.MockTransactionStart("mockTxID")
+	setCreator(t, mockStub, "OrgA")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1")
+
+	// ACT
+	setCreator(t, mockStub, "OrgB")
+	other := smartContract.closeProblem(mockStub, []string{problemKey})
+	setCreator(t, mockStub, "OrgA")
+	closed := smartContract.closeProblem(mockStub, []string{problemKey})
+	mockStub.MockTransactionEnd("mockTxID")
+
+	// ASSERT
+	if other.Status == 200 {
+		t.Errorf("Problem without bounty should only be closed by its owner")
+	}
+	if closed.Status != 200 {
+		t.Errorf("Problem not closed by its owner - %s", closed.Message)
+	}
+	if problem, _ := getProblem(mockStub, problemKey); problem.Status != "archived" {
+		t.Errorf("Closed problem not archived: %s", problem.Status)
+	}
+}
@@ -0,0 +1,208 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/satori/go.uuid"
+)
+
+// maxBranches is the maximum number of parallel branches of a problem
+const maxBranches = 64
+
+// validateBranches checks the number of parallel branches of problem settings.
+// Models of parallel branches are aggregated before the next round, so that the
+// chaining policy of a problem trained in parallel is sequential.
+func validateBranches(settings *ProblemSettings) error {
+	if settings.Branches < 0 || settings.Branches > maxBranches {
+		return fmt.Errorf("branches must be between 0 and %d", maxBranches)
+	}
+	if settings.Branches <= 1 {
+		return nil
+	}
+	switch settings.ChainingPolicy {
+	case "", sequentialChainingPolicy:
+		settings.ChainingPolicy = sequentialChainingPolicy
+	default:
+		return fmt.Errorf("chaining policy of a problem trained in parallel branches must be %s", sequentialChainingPolicy)
+	}
+	return nil
+}
+
+// isParallel returns true if mini-batches of the problem are trained in parallel branches,
+// as they are for federated problems, with one branch per organisation
+func (problem Problem) isParallel() bool {
+	return problem.Branches > 1 || problem.Federated
+}
+
+// lastAggregation returns the key of the aggregation learnuplet of highest rank of the chain
+// which is not cancelled, empty if there is none
+func (chain *modelChain) lastAggregation() string {
+	key := ""
+	for _, learnuplet := range chain.learnuplets {
+		if learnuplet.Type == "aggregation" && learnuplet.Status != "cancelled" {
+			key = learnuplet.Key
+		}
+	}
+	return key
+}
+
+// inputModels returns the models of the done learnuplets an aggregation learnuplet depends on
+func (chain *modelChain) inputModels(aggregation Learnuplet) map[string]string {
+	models := make(map[string]string)
+	for _, dependency := range aggregation.Dependencies {
+		for _, learnuplet := range chain.learnuplets {
+			if learnuplet.Key == dependency && learnuplet.Status == "done" {
+				models[learnuplet.Key] = learnuplet.ModelEndAddress
+			}
+		}
+	}
+	return models
+}
+
+// sameModels returns true if two maps of models are equal
+func sameModels(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, model := range a {
+		if other, ok := b[key]; !ok || other != model {
+			return false
+		}
+	}
+	return true
+}
+
+// createParallelLearnuplets creates the learnuplets of an algo of a problem trained in parallel branches,
+// following the existing learnuplets of the algo.
+// Train data are grouped in mini-batches according to the batching strategy of the problem, and mini-batches
+// in rounds of as many mini-batches as branches. In each round, a learnuplet
+// trains each mini-batch from the same model, then an aggregation learnuplet combines their models.
+// Learnuplets of round r have rank 2r and its aggregation 2r+1, so that the aggregation waits for
+// the learnuplets of its round, and the learnuplets of the next round for the aggregation.
+func createParallelLearnuplets(APIstub shim.ChaincodeStubInterface, problemKey string, problem Problem,
+	algoKey string, algoAddress string, trainData []string) error {
+
+	chain, err := getModelChain(APIstub, problem, algoKey)
+	if err != nil {
+		return err
+	}
+	chain.initialModel = algoAddress
+	rank := chain.lastRank() + 1
+	if rank%2 == 1 {
+		// the aggregation of the last round was cancelled: start a new round
+		rank++
+	}
+	modelStartAddress := ""
+	if chain.isSettled(rank) {
+		modelStartAddress = chain.startModel(rank)
+	}
+	previous := chain.lastAggregation()
+
+	testData, err := getDataAddress(APIstub, problem.TestData)
+	if err != nil {
+		return err
+	}
+	newLearnuplet := func(learnupletType string, rank int, branch int, trainData map[string]string,
+		dependencies []string) Learnuplet {
+		return Learnuplet{
+			ObjectType:        "learnuplet",
+			Type:              learnupletType,
+			Problem:           map[string]string{problemKey: problem.StorageAddress},
+			Algo:              map[string]string{algoKey: algoAddress},
+			ModelStartAddress: modelStartAddress,
+			ModelEndAddress:   uuid.NewV4().String(),
+			TrainData:         trainData,
+			TestData:          testData,
+			Status:            "todo",
+			Rank:              rank,
+			Round:             rank / 2,
+			Branch:            branch,
+			Dependencies:      dependencies,
+			TrainPerf:         make(map[string]float64),
+			TestPerf:          make(map[string]float64),
+			Perfs:             make(map[string]float64),
+			TrainPerfs:        make(map[string]map[string]float64),
+			TestPerfs:         make(map[string]map[string]float64),
+		}
+	}
+
+	assignment, err := newBatchAssignment(APIstub, problem.Batching, problemKey, algoKey, trainData, problem.SizeTrainDataset)
+	if err != nil {
+		return err
+	}
+	assignmentKey := "batches_" + uuid.NewV4().String()
+	for start := 0; start < len(assignment.Batches); start += problem.Branches {
+		var dependencies []string
+		if previous != "" {
+			dependencies = []string{previous}
+		}
+		var branchKeys []string
+		for branch := 0; branch < problem.Branches && start+branch < len(assignment.Batches); branch++ {
+			batchData, err := getDataAddress(APIstub, assignment.Batches[start+branch])
+			if err != nil {
+				return err
+			}
+			learnuplet := newLearnuplet("train", rank, branch, batchData, dependencies)
+			learnuplet.BatchAssignment = assignmentKey
+			learnuplet.Batch = start + branch
+			learnupletKey := "learnuplet_" + uuid.NewV4().String()
+			err = putNewLearnuplet(APIstub, learnupletKey, learnuplet)
+			if err != nil {
+				return err
+			}
+			branchKeys = append(branchKeys, learnupletKey)
+			assignment.Learnuplets = append(assignment.Learnuplets, learnupletKey)
+		}
+		aggregationKey := "learnuplet_" + uuid.NewV4().String()
+		aggregation := newLearnuplet("aggregation", rank+1, 0, make(map[string]string), branchKeys)
+		aggregation.ModelStartAddress = ""
+		err = putNewLearnuplet(APIstub, aggregationKey, aggregation)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("-- creation of round %d of %s ok \n", rank/2, algoKey)
+		// learnuplets of the next rounds start from the model of this round, not known yet
+		previous = aggregationKey
+		modelStartAddress = ""
+		rank += 2
+	}
+	if len(assignment.Batches) > 0 {
+		return storeBatchAssignment(APIstub, assignmentKey, assignment)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateBranches(t *testing.T) {
+	tests := []struct {
+		settings ProblemSettings
+		policy   string
+	}{
+		{ProblemSettings{}, ""},
+		{ProblemSettings{Branches: 1, ChainingPolicy: bestChainingPolicy}, bestChainingPolicy},
+		{ProblemSettings{Branches: 4}, sequentialChainingPolicy},
+		{ProblemSettings{Branches: 4, ChainingPolicy: sequentialChainingPolicy}, sequentialChainingPolicy},
+		{ProblemSettings{Branches: 4, ChainingPolicy: bestChainingPolicy}, "invalid"},
+		{ProblemSettings{Branches: 4, ChainingPolicy: restartChainingPolicy}, "invalid"},
+		{ProblemSettings{Branches: -1}, "invalid"},
+		{ProblemSettings{Branches: maxBranches + 1}, "invalid"},
+	}
+	for _, test := range tests {
+		settings := test.settings
+		err := validateBranches(&settings)
+		if test.policy == "invalid" {
+			if err == nil {
+				t.Errorf("Settings %+v should be rejected", test.settings)
+			}
+		} else if err != nil || settings.ChainingPolicy != test.policy {
+			t.Errorf("Settings %+v have chaining policy %q instead of %q - %v", test.settings, settings.ChainingPolicy, test.policy, err)
+		}
+	}
+}
+
+func TestParallelLearnuplets(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	// learnuplets returns the learnuplets of the ledger by round and type
+	learnuplets := func() map[int]map[string][]keyedLearnuplet {
+		rounds := make(map[int]map[string][]keyedLearnuplet)
+		for _, key := range getKeys(t, mockStub, "learnuplet") {
+			learnuplet := keyedLearnuplet{Key: key}
+			json.Unmarshal(mockStub.State[key], &learnuplet.Learnuplet)
+			if rounds[learnuplet.Round] == nil {
+				rounds[learnuplet.Round] = make(map[string][]keyedLearnuplet)
+			}
+			rounds[learnuplet.Round][learnuplet.Type] = append(rounds[learnuplet.Round][learnuplet.Type], learnuplet)
+		}
+		return rounds
+	}
+	report := func(txId string, learnuplet keyedLearnuplet) {
+		mockStub.MockTransactionStart(txId)
+		assignTestWorker(t, smartContract, mockStub, learnuplet.Key, "Arbeiter_12")
+		response := smartContract.reportLearn(mockStub, testReport(t, mockStub, learnuplet.Key, "done"))
+		mockStub.MockTransactionEnd(txId)
+		if response.GetStatus() != 200 {
+			t.Fatalf("reportLearn of %s of round %d fails - %s", learnuplet.Type, learnuplet.Round, response.Message)
+		}
+	}
+
+	// ACT
+	mockStub.MockTransactionStart("mockTxID")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1", "", `{"branches": 2}`)
+	registerTestItems(t, smartContract, mockStub, problemKey, 3, "algo1")
+	registerTestWorkers(t, smartContract, mockStub, "Arbeiter_12")
+	readyResponse := smartContract.queryReadyLearnuplets(mockStub, []string{problemKey})
+	mockStub.MockTransactionEnd("mockTxID")
+	created := learnuplets()
+	report("mockTxID_0", created[0]["train"][0])
+	afterFirstBranch := learnuplets()
+	report("mockTxID_1", created[0]["train"][1])
+	afterRound := learnuplets()
+	report("mockTxID_2", afterRound[0]["aggregation"][0])
+	afterAggregation := learnuplets()
+	// a new data creates a new round following the existing ones
+	mockStub.MockTransactionStart("mockTxID_3")
+	registerTestItems(t, smartContract, mockStub, problemKey, 1)
+	mockStub.MockTransactionEnd("mockTxID_3")
+	afterNewData := learnuplets()
+
+	// ASSERT
+	// 3 mini-batches in 2 branches: 2 rounds of 2 and 1 learnuplets, each one with an aggregation
+	if len(created) != 2 || len(created[0]["train"]) != 2 || len(created[1]["train"]) != 1 ||
+		len(created[0]["aggregation"]) != 1 || len(created[1]["aggregation"]) != 1 {
+		t.Fatalf("Unexpected learnuplets created: %v", created)
+	}
+	algoAddress := "8fa81bfc-b5f4-4ba2-b81a-algo1"
+	aggregation0 := created[0]["aggregation"][0]
+	for branch, learnuplet := range created[0]["train"] {
+		if learnuplet.Rank != 0 || learnuplet.ModelStartAddress != algoAddress || len(learnuplet.TrainData) != 1 {
+			t.Errorf("Learnuplet of branch %d of round 0 not created as expected: %+v", branch, learnuplet)
+		}
+	}
+	if aggregation0.Rank != 1 || len(aggregation0.Dependencies) != 2 || aggregation0.ModelStartAddress != "" {
+		t.Errorf("Aggregation of round 0 not created as expected: %+v", aggregation0)
+	}
+	round1 := created[1]["train"][0]
+	if round1.Rank != 2 || len(round1.Dependencies) != 1 || round1.Dependencies[0] != aggregation0.Key || round1.ModelStartAddress != "" {
+		t.Errorf("Learnuplet of round 1 not created as expected: %+v", round1)
+	}
+	var ready []readyLearnuplet
+	json.Unmarshal(readyResponse.GetPayload(), &ready)
+	if len(ready) != 2 {
+		t.Errorf("%d learnuplets ready instead of the 2 branches of round 0", len(ready))
+	}
+	// the aggregation waits for all branches
+	if afterFirstBranch[0]["aggregation"][0].ModelStartAddress != "" {
+		t.Errorf("Aggregation should wait for all branches of its round")
+	}
+	aggregated := afterRound[0]["aggregation"][0]
+	if aggregated.ModelStartAddress != algoAddress || len(aggregated.InputModels) != 2 ||
+		aggregated.InputModels[created[0]["train"][1].Key] != created[0]["train"][1].ModelEndAddress {
+		t.Errorf("Input models of aggregation not set: %+v", aggregated)
+	}
+	if afterAggregation[1]["train"][0].ModelStartAddress != aggregation0.ModelEndAddress {
+		t.Errorf("Learnuplet of round 1 should start from the aggregated model")
+	}
+	if len(afterNewData) != 3 || len(afterNewData[2]["train"]) != 1 || afterNewData[2]["train"][0].Rank != 4 ||
+		afterNewData[2]["train"][0].Dependencies[0] != created[1]["aggregation"][0].Key {
+		t.Errorf("New data should create round 2 after the aggregation of round 1: %v", afterNewData[2])
+	}
+}
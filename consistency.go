@@ -0,0 +1,262 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// derivedIndexes are the indexes whose entries only mark a record, the key of which is their last attribute.
+// Their entries are derived from the records, contrary to the indexes of records (see recordIndexes).
+var derivedIndexes = []string{
+	"algo~problem~key", "data~problem~key", "learnuplet~algo~key", "learnuplet~status~key", "round~algo~key", "tag~type~key",
+}
+
+// IndexEntry is an entry of an index, given by the index and the attributes of its composite key
+type IndexEntry struct {
+	Index      string   `json:"index"`
+	Attributes []string `json:"attributes"`
+}
+
+// IndexIssue is an inconsistency between an index entry and the Record it points to.
+// Kind belongs to [missing, orphan, mismatch]: the entry of a record is missing, or the entry
+// points to a record which does not exist, or to a record which does not match it (such as
+// the entry of a learnuplet with another status).
+type IndexIssue struct {
+	Kind string `json:"kind"`
+	IndexEntry
+	Record string `json:"record"`
+}
+
+// ConsistencyReport is the report of a chunk of the check of the indexes. Repaired is
+// the number of issues fixed, and Bookmark the bookmark of the next chunk, empty at the end.
+type ConsistencyReport struct {
+	Scanned  int          `json:"scanned"`
+	Issues   []IndexIssue `json:"issues"`
+	Repaired int          `json:"repaired"`
+	Bookmark string       `json:"bookmark"`
+}
+
+// expectedIndexEntries returns the entries of the derived indexes which point to a record
+func expectedIndexEntries(APIstub shim.ChaincodeStubInterface, key string, value []byte) (entries []IndexEntry, err error) {
+	if !isRecord(value) {
+		return entries, nil
+	}
+	header := struct {
+		ObjectType string `json:"docType"`
+	}{}
+	if err = json.Unmarshal(value, &header); err != nil {
+		return entries, fmt.Errorf("Problem Unmarshal %s - %s", key, err)
+	}
+	switch header.ObjectType {
+	case "algo", "data":
+		item := Item{}
+		if err = unmarshalRecord(APIstub, value, &item); err != nil {
+			return entries, fmt.Errorf("Problem Unmarshal %s - %s", key, err)
+		}
+		// withdrawn items and removed data are not associated with their problem anymore
+		if item.Status == "active" {
+			entries = append(entries, IndexEntry{item.ObjectType + "~problem~key", []string{item.ObjectType, item.Problem, key}})
+		}
+		entries = append(entries, tagEntries(item.ObjectType, key, item.Metadata.Tags)...)
+	case "problem":
+		problem := Problem{}
+		if err = unmarshalRecord(APIstub, value, &problem); err != nil {
+			return entries, fmt.Errorf("Problem Unmarshal %s - %s", key, err)
+		}
+		entries = tagEntries(problem.ObjectType, key, problem.Metadata.Tags)
+	case "learnuplet":
+		learnuplet := Learnuplet{}
+		if err = unmarshalRecord(APIstub, value, &learnuplet); err != nil {
+			return entries, fmt.Errorf("Problem Unmarshal %s - %s", key, err)
+		}
+		entries = []IndexEntry{
+			{"learnuplet~algo~key", []string{"learnuplet", getAlgoKey(learnuplet), key}},
+			{"learnuplet~status~key", []string{"learnuplet", learnuplet.Status, key}},
+		}
+	case "round":
+		round := Round{}
+		if err = unmarshalRecord(APIstub, value, &round); err != nil {
+			return entries, fmt.Errorf("Problem Unmarshal %s - %s", key, err)
+		}
+		entries = []IndexEntry{{"round~algo~key", []string{"round", round.Algo, key}}}
+	}
+	return entries, nil
+}
+
+// tagEntries returns the entries of the index tag~type~key of an object
+func tagEntries(objectType string, key string, tags []string) (entries []IndexEntry) {
+	for _, tag := range tags {
+		entries = append(entries, IndexEntry{"tag~type~key", []string{tag, objectType, key}})
+	}
+	return entries
+}
+
+// checkIndexes cross-checks a chunk of the ledger, of at most limit entries from a bookmark: the entries
+// of the derived indexes each record should have, and the record each index entry points to.
+// When repair is true, missing entries are created, and orphan and mismatching ones deleted.
+func checkIndexes(APIstub shim.ChaincodeStubInterface, limit int, bookmark string, repair bool) (report ConsistencyReport, err error) {
+	report.Issues = []IndexIssue{}
+	emptyValue := []byte{0x00}
+
+	// checkRecord checks that the entries expected for a record are in the indexes
+	checkRecord := func(key string, value []byte) error {
+		entries, err := expectedIndexEntries(APIstub, key, value)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			entryKey, err := APIstub.CreateCompositeKey(entry.Index, entry.Attributes)
+			if err != nil {
+				return err
+			}
+			entryValue, err := APIstub.GetState(entryKey)
+			if err != nil {
+				return err
+			}
+			if entryValue != nil {
+				continue
+			}
+			report.Issues = append(report.Issues, IndexIssue{"missing", entry, key})
+			if repair {
+				if err = APIstub.PutState(entryKey, emptyValue); err != nil {
+					return err
+				}
+				report.Repaired++
+			}
+		}
+		return nil
+	}
+
+	// checkEntry checks that an index entry points to a record which expects it
+	checkEntry := func(key string) error {
+		index, attributes, err := APIstub.SplitCompositeKey(key)
+		if err != nil {
+			return err
+		}
+		issue := IndexIssue{Kind: "orphan", IndexEntry: IndexEntry{index, attributes}}
+		if len(attributes) > 0 {
+			issue.Record = attributes[len(attributes)-1]
+			value, err := APIstub.GetState(issue.Record)
+			if err != nil {
+				return err
+			}
+			if value != nil {
+				issue.Kind = "mismatch"
+				entries, err := expectedIndexEntries(APIstub, issue.Record, value)
+				if err != nil {
+					return err
+				}
+				for _, entry := range entries {
+					if entry.Index == index && strings.Join(entry.Attributes, "\x00") == strings.Join(attributes, "\x00") {
+						return nil
+					}
+				}
+			}
+		}
+		report.Issues = append(report.Issues, issue)
+		if repair {
+			if err = APIstub.DelState(key); err != nil {
+				return err
+			}
+			report.Repaired++
+		}
+		return nil
+	}
+
+	report.Scanned, report.Bookmark, err = scanLedger(APIstub, derivedIndexes, limit, bookmark, func(key string, value []byte) error {
+		if strings.HasPrefix(key, compositeKeyNamespace) {
+			return checkEntry(key)
+		}
+		return checkRecord(key, value)
+	})
+	return report, err
+}
+
+// checkConsistency is the smart contract to check a chunk of the ledger against the indexes
+// algo~problem~key, data~problem~key, learnuplet~algo~key, learnuplet~status~key, round~algo~key
+// and tag~type~key, reporting the missing, orphan and mismatching entries (see IndexIssue)
+// Args (1 or 2 strings): limit (number of entries checked), optionally bookmark returned by the previous call
+func (s *SmartContract) checkConsistency(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	limit, bookmark, err := parseChunkArgs(args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- start checking %d entries from %q \n", limit, bookmark)
+
+	report, err := checkIndexes(APIstub, limit, bookmark, false)
+	if err != nil {
+		return shim.Error("Problem checking the indexes - " + err.Error())
+	}
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end checking, %d issues found \n", len(report.Issues))
+	return shim.Success(payload)
+}
+
+// repairIndexes is the smart contract to repair the indexes of a chunk of the ledger: the missing
+// entries are created, and the orphan and mismatching ones deleted (see checkConsistency).
+// Only callable by admin organisations (see Config)
+// Args (1 or 2 strings): limit (number of entries checked), optionally bookmark returned by the previous call
+func (s *SmartContract) repairIndexes(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	limit, bookmark, err := parseChunkArgs(args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- start repairing %d entries from %q \n", limit, bookmark)
+
+	if _, _, err := getAdminConfig(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+	report, err := checkIndexes(APIstub, limit, bookmark, true)
+	if err != nil {
+		return shim.Error("Problem repairing the indexes - " + err.Error())
+	}
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end repairing, %d issues repaired \n", report.Repaired)
+	return shim.Success(payload)
+}
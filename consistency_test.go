@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+func TestCheckAndRepairIndexes(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	mockStub.MockInit("mockTxID_init", [][]byte{[]byte("init"), []byte("fresh"), []byte("OrgA")})
+	tx := 0
+	invoke := func(org string, function func(shim.ChaincodeStubInterface, []string) sc.Response, args ...string) sc.Response {
+		tx++
+		txId := fmt.Sprintf("mockTxID_%d", tx)
+		mockStub.MockTransactionStart(txId)
+		defer mockStub.MockTransactionEnd(txId)
+		setCreator(t, mockStub, org)
+		return function(mockStub, args)
+	}
+	// check checks the whole ledger in chunks, and returns the issues found
+	check := func(org string, function func(shim.ChaincodeStubInterface, []string) sc.Response) (issues []IndexIssue, repaired int) {
+		bookmark := ""
+		for chunk := 0; chunk < 100; chunk++ {
+			response := invoke(org, function, "4", bookmark)
+			if response.Status != shim.OK {
+				t.Fatalf("Check of the indexes failed - %s", response.Message)
+			}
+			report := ConsistencyReport{}
+			json.Unmarshal(response.Payload, &report)
+			if report.Scanned > 4 {
+				t.Errorf("Chunk larger than its limit: %+v", report)
+			}
+			issues, repaired = append(issues, report.Issues...), repaired+report.Repaired
+			if bookmark = report.Bookmark; bookmark == "" {
+				return issues, repaired
+			}
+		}
+		t.Fatalf("Check of the indexes did not complete")
+		return issues, repaired
+	}
+	mockStub.MockTransactionStart("mockTxID")
+	setCreator(t, mockStub, "OrgA")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1")
+	registerTestItems(t, smartContract, mockStub, problemKey, 2, "algo1")
+	smartContract.registerItem(mockStub, []string{"data", "8fa81bfc-b5f4-4ba2-b81a-b46424800009", problemKey, "", `{"tags": ["ecg"]}`})
+	problem, _ := getProblem(mockStub, problemKey)
+	smartContract.updateProblem(mockStub, []string{problemKey, "", "", problem.TestData[0]})
+	mockStub.MockTransactionEnd("mockTxID")
+	healthyIssues, _ := check("OrgB", smartContract.checkConsistency)
+
+	// corrupt the indexes: a missing tag and status entry, a stale status entry and an orphan item entry
+	_, learnuplets, _ := getCompositeLearnuplet(mockStub, "status", "todo")
+	if len(learnuplets) == 0 {
+		t.Fatalf("No learnuplet created")
+	}
+	learnupletKey := learnuplets[0]["key"].(string)
+	var tagIndexKey string
+	for _, dataKey := range getKeys(t, mockStub, "data") {
+		if data, _ := getItem(mockStub, dataKey); len(data.Metadata.Tags) > 0 {
+			tagIndexKey, _ = mockStub.CreateCompositeKey("tag~type~key", []string{"ecg", "data", dataKey})
+		}
+	}
+	todoIndexKey, _ := mockStub.CreateCompositeKey("learnuplet~status~key", []string{"learnuplet", "todo", learnupletKey})
+	doneIndexKey, _ := mockStub.CreateCompositeKey("learnuplet~status~key", []string{"learnuplet", "done", learnupletKey})
+	orphanIndexKey, _ := mockStub.CreateCompositeKey("data~problem~key", []string{"data", problemKey, "data_unknown"})
+	if mockStub.State[tagIndexKey] == nil {
+		t.Fatalf("Tag of the data not indexed")
+	}
+	mockStub.MockTransactionStart("mockTxID_corrupt")
+	mockStub.DelState(tagIndexKey)
+	mockStub.DelState(todoIndexKey)
+	mockStub.PutState(doneIndexKey, []byte{0x00})
+	mockStub.PutState(orphanIndexKey, []byte{0x00})
+	mockStub.MockTransactionEnd("mockTxID_corrupt")
+
+	// ACT
+	issues, checkRepaired := check("OrgB", smartContract.checkConsistency)
+	notAdmin := invoke("OrgB", smartContract.repairIndexes, "100")
+	invalidLimit := invoke("OrgA", smartContract.repairIndexes, "0")
+	repairedIssues, repaired := check("OrgA", smartContract.repairIndexes)
+	remainingIssues, _ := check("OrgB", smartContract.checkConsistency)
+
+	// ASSERT
+	if len(healthyIssues) != 0 {
+		t.Errorf("Issues found in consistent indexes: %+v", healthyIssues)
+	}
+	kinds := map[string]int{}
+	for _, issue := range issues {
+		kinds[issue.Kind]++
+	}
+	if len(issues) != 4 || kinds["missing"] != 2 || kinds["mismatch"] != 1 || kinds["orphan"] != 1 || checkRepaired != 0 {
+		t.Errorf("Unexpected issues found: %+v", issues)
+	}
+	if notAdmin.Status == shim.OK || invalidLimit.Status == shim.OK {
+		t.Errorf("Indexes should only be repaired by admins, in chunks of a positive size")
+	}
+	if len(repairedIssues) != 4 || repaired != 4 || len(remainingIssues) != 0 {
+		t.Errorf("%d issues repaired instead of 4, %d remaining: %+v", repaired, len(remainingIssues), remainingIssues)
+	}
+	if mockStub.State[tagIndexKey] == nil || mockStub.State[todoIndexKey] == nil ||
+		mockStub.State[doneIndexKey] != nil || mockStub.State[orphanIndexKey] != nil {
+		t.Errorf("Indexes not repaired")
+	}
+}
@@ -0,0 +1,567 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/satori/go.uuid"
+)
+
+// maxRounds is the maximum number of rounds of a federated problem
+const maxRounds = 1000
+
+// Round structure, coordinating a round of federated learning of an algo, stored with key round_<uuid>.
+// ObjectType is round (necessary when switching to couchDB).
+// Problem and Algo are the keys of the problem and the algo trained.
+// Number is the number of the round, starting from 0.
+// Status belongs to [open, aggregating, closed].
+// Learnuplets map each participating organisation to the key of its learnuplet, trained on its data.
+// Quorum is the number of done learnuplets required to close the round before all are finished,
+// all learnuplets which are not cancelled if 0.
+// StartModel is the model from which learnuplets of the round start.
+// Aggregation is the key of the learnuplet aggregating the models of the round, once closed,
+// and AggregatedModel its model, from which the next round starts.
+type Round struct {
+	Record
+	ObjectType      string            `json:"docType"`
+	Problem         string            `json:"problem"`
+	Algo            string            `json:"algo"`
+	Number          int               `json:"number"`
+	Status          string            `json:"status"`
+	Organisations   []string          `json:"organisations"`
+	Learnuplets     map[string]string `json:"learnuplets"`
+	Quorum          int               `json:"quorum"`
+	StartModel      string            `json:"startModel"`
+	Aggregation     string            `json:"aggregation"`
+	AggregatedModel string            `json:"aggregatedModel"`
+}
+
+// keyedRound is a round with its key on the ledger
+type keyedRound struct {
+	Key string `json:"key"`
+	Round
+}
+
+// validateFederation checks the federated settings of a problem, and sets their defaults:
+// a single round, closed once all organisations reported their learnuplet.
+func validateFederation(settings *ProblemSettings) error {
+	if !settings.Federated {
+		if settings.Rounds != 0 || settings.Quorum != 0 {
+			return fmt.Errorf("rounds and quorum are settings of federated problems")
+		}
+		return nil
+	}
+	if settings.Branches > 1 {
+		return fmt.Errorf("federated problems have one branch per organisation, branches cannot be set")
+	}
+	if settings.Rounds < 0 || settings.Rounds > maxRounds {
+		return fmt.Errorf("rounds must be between 1 and %d", maxRounds)
+	}
+	if settings.Rounds == 0 {
+		settings.Rounds = 1
+	}
+	if settings.Quorum < 0 {
+		return fmt.Errorf("quorum cannot be negative")
+	}
+	switch settings.ChainingPolicy {
+	case "", sequentialChainingPolicy:
+		settings.ChainingPolicy = sequentialChainingPolicy
+	default:
+		return fmt.Errorf("chaining policy of a federated problem must be %s", sequentialChainingPolicy)
+	}
+	return nil
+}
+
+// quorum returns the number of done learnuplets required to close the round early, given the number
+// of learnuplets of the round which are not cancelled: all of them if the quorum is not set, and at least one
+func (round Round) quorum(nbActive int) int {
+	quorum := round.Quorum
+	if quorum == 0 || quorum > nbActive {
+		quorum = nbActive
+	}
+	if quorum < 1 {
+		quorum = 1
+	}
+	return quorum
+}
+
+// getRounds returns the rounds of an algo, ordered by number
+func getRounds(APIstub shim.ChaincodeStubInterface, algoKey string) ([]keyedRound, error) {
+	roundIterator, err := APIstub.GetStateByPartialCompositeKey("round~algo~key", []string{"round", algoKey})
+	if err != nil {
+		return nil, err
+	}
+	defer roundIterator.Close()
+
+	var rounds []keyedRound
+	for roundIterator.HasNext() {
+		responseRange, err := roundIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := APIstub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		round, err := getRound(APIstub, compositeKeyParts[2])
+		if err != nil {
+			return nil, err
+		}
+		rounds = append(rounds, keyedRound{compositeKeyParts[2], round})
+	}
+	sort.Slice(rounds, func(i, j int) bool { return rounds[i].Number < rounds[j].Number })
+	return rounds, nil
+}
+
+// getRound returns the round with a given key
+func getRound(APIstub shim.ChaincodeStubInterface, roundKey string) (round Round, err error) {
+	value, err := APIstub.GetState(roundKey)
+	if err != nil {
+		return round, err
+	}
+	if value == nil {
+		return round, fmt.Errorf("no round with key %s", roundKey)
+	}
+	err = unmarshalRecord(APIstub, value, &round)
+	return round, err
+}
+
+// storeRound stores a round
+func storeRound(APIstub shim.ChaincodeStubInterface, roundKey string, round Round) error {
+	roundAsBytes, err := marshalRecord(&round)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(roundKey, roundAsBytes)
+}
+
+// getDataByOrganisation returns the keys of the train data of a problem, grouped by owner
+func getDataByOrganisation(APIstub shim.ChaincodeStubInterface, problemKey string,
+	problem Problem) (map[string][]string, error) {
+
+	dataKeys, err := getProblemItems(APIstub, problemKey, "data")
+	if err != nil {
+		return nil, err
+	}
+	testData := make(map[string]bool)
+	for _, dataKey := range problem.TestData {
+		testData[dataKey] = true
+	}
+	dataByOrganisation := make(map[string][]string)
+	for _, dataKey := range dataKeys {
+		if testData[dataKey] {
+			continue
+		}
+		value, err := APIstub.GetState(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		data := Item{}
+		err = unmarshalRecord(APIstub, value, &data)
+		if err != nil {
+			return nil, fmt.Errorf("Problem Unmarshal %s - %s", dataKey, err)
+		}
+		dataByOrganisation[data.Owner] = append(dataByOrganisation[data.Owner], dataKey)
+	}
+	for _, organisationData := range dataByOrganisation {
+		sort.Strings(organisationData)
+	}
+	return dataByOrganisation, nil
+}
+
+// openRound creates a round of an algo of a federated problem, with a learnuplet for each organisation
+// owning train data of the problem, starting from a given model. Nothing is created if there is no
+// train data. Learnuplets of round r have rank 2r, and its aggregation 2r+1 (see createParallelLearnuplets).
+func openRound(APIstub shim.ChaincodeStubInterface, problemKey string, problem Problem,
+	algoKey string, algoAddress string, number int, startModel string) error {
+
+	dataByOrganisation, err := getDataByOrganisation(APIstub, problemKey, problem)
+	if err != nil {
+		return err
+	}
+	// Only data whose usage policy allows the algo are trained on
+	for organisation, data := range dataByOrganisation {
+		data, err = filterAllowedData(APIstub, algoKey, data)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			delete(dataByOrganisation, organisation)
+		} else {
+			dataByOrganisation[organisation] = data
+		}
+	}
+	if len(dataByOrganisation) == 0 {
+		fmt.Printf("-- no data to open round %d of %s \n", number, algoKey)
+		return nil
+	}
+	var organisations []string
+	for organisation := range dataByOrganisation {
+		organisations = append(organisations, organisation)
+	}
+	sort.Strings(organisations)
+	testData, err := getDataAddress(APIstub, problem.TestData)
+	if err != nil {
+		return err
+	}
+
+	round := Round{
+		ObjectType:    "round",
+		Problem:       problemKey,
+		Algo:          algoKey,
+		Number:        number,
+		Status:        "open",
+		Organisations: organisations,
+		Learnuplets:   make(map[string]string),
+		Quorum:        problem.Quorum,
+		StartModel:    startModel,
+	}
+	for branch, organisation := range organisations {
+		trainData, err := getDataAddress(APIstub, dataByOrganisation[organisation])
+		if err != nil {
+			return err
+		}
+		learnuplet := Learnuplet{
+			ObjectType:        "learnuplet",
+			Type:              "train",
+			Problem:           map[string]string{problemKey: problem.StorageAddress},
+			Algo:              map[string]string{algoKey: algoAddress},
+			ModelStartAddress: startModel,
+			ModelEndAddress:   uuid.NewV4().String(),
+			TrainData:         trainData,
+			TestData:          testData,
+			Status:            "todo",
+			Rank:              2 * number,
+			Round:             number,
+			Branch:            branch,
+			Organisation:      organisation,
+			TrainPerf:         make(map[string]float64),
+			TestPerf:          make(map[string]float64),
+			Perfs:             make(map[string]float64),
+			TrainPerfs:        make(map[string]map[string]float64),
+			TestPerfs:         make(map[string]map[string]float64),
+		}
+		learnupletKey := "learnuplet_" + uuid.NewV4().String()
+		err = putNewLearnuplet(APIstub, learnupletKey, learnuplet)
+		if err != nil {
+			return err
+		}
+		round.Learnuplets[organisation] = learnupletKey
+	}
+
+	roundKey := "round_" + uuid.NewV4().String()
+	err = storeRound(APIstub, roundKey, round)
+	if err != nil {
+		return err
+	}
+	roundIndexKey, err := APIstub.CreateCompositeKey("round~algo~key", []string{"round", algoKey, roundKey})
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(roundIndexKey, []byte{0x00})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("-- opening of round %d of %s with %d organisations ok \n", number, algoKey, len(organisations))
+	return nil
+}
+
+// getRoundLearnuplets returns the learnuplets of the organisations of a round, with the given updated learnuplets
+func getRoundLearnuplets(APIstub shim.ChaincodeStubInterface, round Round,
+	updated ...keyedLearnuplet) ([]keyedLearnuplet, error) {
+
+	var learnuplets []keyedLearnuplet
+	for _, organisation := range round.Organisations {
+		learnupletKey := round.Learnuplets[organisation]
+		learnuplet := keyedLearnuplet{Key: learnupletKey}
+		for _, updatedLearnuplet := range updated {
+			if updatedLearnuplet.Key == learnupletKey {
+				learnuplet = updatedLearnuplet
+			}
+		}
+		if learnuplet.ObjectType == "" {
+			value, err := APIstub.GetState(learnupletKey)
+			if err != nil {
+				return nil, err
+			}
+			err = unmarshalRecord(APIstub, value, &learnuplet.Learnuplet)
+			if err != nil {
+				return nil, fmt.Errorf("Problem Unmarshal %s - %s", learnupletKey, err)
+			}
+		}
+		learnuplets = append(learnuplets, learnuplet)
+	}
+	return learnuplets, nil
+}
+
+// aggregateRound closes an open round: learnuplets not finished yet are cancelled, and an aggregation
+// learnuplet combining the models of the done ones is created. If none is done, the round is closed
+// and the next one opened from the same model.
+// updated are learnuplets of the round updated in the transaction.
+func aggregateRound(APIstub shim.ChaincodeStubInterface, roundKey string, round Round, problem Problem,
+	updated ...keyedLearnuplet) error {
+
+	learnuplets, err := getRoundLearnuplets(APIstub, round, updated...)
+	if err != nil {
+		return err
+	}
+	var dependencies []string
+	inputModels := make(map[string]string)
+	var algoAddress string
+	for _, learnuplet := range learnuplets {
+		algoAddress = learnuplet.Algo[round.Algo]
+		if learnuplet.Status == "done" {
+			dependencies = append(dependencies, learnuplet.Key)
+			inputModels[learnuplet.Key] = learnuplet.ModelEndAddress
+		} else if !isFinished(learnuplet.Learnuplet) {
+			err = setLearnupletStatus(APIstub, learnuplet.Key, learnuplet.Learnuplet, "cancelled")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(dependencies) == 0 {
+		return completeRound(APIstub, roundKey, round, problem, "")
+	}
+
+	aggregationKey := "learnuplet_" + uuid.NewV4().String()
+	aggregation := Learnuplet{
+		ObjectType:        "learnuplet",
+		Type:              "aggregation",
+		Problem:           map[string]string{round.Problem: problem.StorageAddress},
+		Algo:              map[string]string{round.Algo: algoAddress},
+		ModelStartAddress: round.StartModel,
+		ModelEndAddress:   uuid.NewV4().String(),
+		TrainData:         make(map[string]string),
+		TestData:          learnuplets[0].TestData,
+		Status:            "todo",
+		Rank:              2*round.Number + 1,
+		Round:             round.Number,
+		Dependencies:      dependencies,
+		InputModels:       inputModels,
+		TrainPerf:         make(map[string]float64),
+		TestPerf:          make(map[string]float64),
+		Perfs:             make(map[string]float64),
+		TrainPerfs:        make(map[string]map[string]float64),
+		TestPerfs:         make(map[string]map[string]float64),
+	}
+	err = putNewLearnuplet(APIstub, aggregationKey, aggregation)
+	if err != nil {
+		return err
+	}
+	round.Status = "aggregating"
+	round.Aggregation = aggregationKey
+	return storeRound(APIstub, roundKey, round)
+}
+
+// completeRound closes a round with its aggregated model, empty if the aggregation failed,
+// and opens the next round unless the number of rounds of the problem is reached
+func completeRound(APIstub shim.ChaincodeStubInterface, roundKey string, round Round, problem Problem,
+	aggregatedModel string) error {
+
+	round.Status = "closed"
+	round.AggregatedModel = aggregatedModel
+	err := storeRound(APIstub, roundKey, round)
+	if err != nil {
+		return err
+	}
+	if round.Number+1 >= problem.Rounds {
+		fmt.Printf("-- last round of %s closed \n", round.Algo)
+		return nil
+	}
+	startModel := aggregatedModel
+	if startModel == "" {
+		startModel = round.StartModel
+	}
+	value, err := APIstub.GetState(round.Algo)
+	if err != nil {
+		return err
+	}
+	algo := Item{}
+	err = unmarshalRecord(APIstub, value, &algo)
+	if err != nil {
+		return fmt.Errorf("Problem Unmarshal %s - %s", round.Algo, err)
+	}
+	return openRound(APIstub, round.Problem, problem, round.Algo, algo.StorageAddress, round.Number+1, startModel)
+}
+
+// findRound returns the round of an algo with a given number
+func findRound(APIstub shim.ChaincodeStubInterface, algoKey string, number int) (keyedRound, error) {
+	rounds, err := getRounds(APIstub, algoKey)
+	if err != nil {
+		return keyedRound{}, err
+	}
+	for _, round := range rounds {
+		if round.Number == number {
+			return round, nil
+		}
+	}
+	return keyedRound{}, fmt.Errorf("no round %d for algo %s", number, algoKey)
+}
+
+// updateRound updates the round of a learnuplet of a federated problem just reported:
+// the round is closed once all its learnuplets are finished, and completed once aggregated
+func updateRound(APIstub shim.ChaincodeStubInterface, problem Problem, reported keyedLearnuplet) error {
+	round, err := findRound(APIstub, getAlgoKey(reported.Learnuplet), reported.Round)
+	if err != nil {
+		return err
+	}
+	if reported.Type == "aggregation" {
+		if round.Status != "aggregating" || round.Aggregation != reported.Key {
+			return nil
+		}
+		aggregatedModel := ""
+		if reported.Status == "done" {
+			aggregatedModel = reported.ModelEndAddress
+		}
+		return completeRound(APIstub, round.Key, round.Round, problem, aggregatedModel)
+	}
+	if round.Status != "open" {
+		return nil
+	}
+	learnuplets, err := getRoundLearnuplets(APIstub, round.Round, reported)
+	if err != nil {
+		return err
+	}
+	for _, learnuplet := range learnuplets {
+		if !isFinished(learnuplet.Learnuplet) {
+			return nil
+		}
+	}
+	return aggregateRound(APIstub, round.Key, round.Round, problem, reported)
+}
+
+// federatedLearnuplets opens the first round of the algos of a federated problem which have none yet,
+// or of a given algo. Data registered once an algo has rounds join its next round.
+func federatedLearnuplets(APIstub shim.ChaincodeStubInterface, problemKey string, problem Problem,
+	algoKeys []string) error {
+
+	for _, algoKey := range algoKeys {
+		rounds, err := getRounds(APIstub, algoKey)
+		if err != nil {
+			return err
+		}
+		if len(rounds) > 0 {
+			continue
+		}
+		value, err := APIstub.GetState(algoKey)
+		if err != nil {
+			return err
+		}
+		algo := Item{}
+		err = unmarshalRecord(APIstub, value, &algo)
+		if err != nil {
+			return fmt.Errorf("Problem Unmarshal %s - %s", algoKey, err)
+		}
+		err = openRound(APIstub, problemKey, problem, algoKey, algo.StorageAddress, 0, algo.StorageAddress)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeRound is the smart contract to close an open round of federated learning once its quorum
+// is reached: learnuplets of organisations which did not report yet are cancelled, and the models of the
+// done ones are aggregated. Rounds are closed automatically once all organisations reported.
+// Args (1 string): roundKey
+func (s *SmartContract) closeRound(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1: roundKey")
+	}
+	roundKey := args[0]
+	fmt.Printf("- start closing round %s \n", roundKey)
+
+	round, err := getRound(APIstub, roundKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if round.Status != "open" {
+		return shim.Error("Round is not open but " + round.Status)
+	}
+	learnuplets, err := getRoundLearnuplets(APIstub, round)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	nbDone, nbActive := 0, 0
+	for _, learnuplet := range learnuplets {
+		if learnuplet.Status == "done" {
+			nbDone++
+		}
+		if learnuplet.Status != "cancelled" {
+			nbActive++
+		}
+	}
+	if quorum := round.quorum(nbActive); nbDone < quorum {
+		return shim.Error(fmt.Sprintf("Quorum not reached: %d learnuplets done out of %d required", nbDone, quorum))
+	}
+	problem, err := getProblem(APIstub, round.Problem)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = aggregateRound(APIstub, roundKey, round, problem)
+	if err != nil {
+		return shim.Error("Problem closing round - " + err.Error())
+	}
+	fmt.Printf("- end closing round %s \n", roundKey)
+	return shim.Success(nil)
+}
+
+// queryRounds is the smart contract to get the rounds of federated learning of an algo
+// Args (1 string): algoKey
+func (s *SmartContract) queryRounds(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1: algoKey")
+	}
+	fmt.Printf("- start looking for rounds of %s \n", args[0])
+
+	rounds, err := getRounds(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	payload, err := json.Marshal(rounds)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end looking for rounds of %s \n", args[0])
+	return shim.Success(payload)
+}
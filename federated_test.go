@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+func TestValidateFederation(t *testing.T) {
+	tests := []struct {
+		settings ProblemSettings
+		valid    bool
+	}{
+		{ProblemSettings{}, true},
+		{ProblemSettings{Federated: true, Rounds: 3, Quorum: 2}, true},
+		{ProblemSettings{Rounds: 3}, false},
+		{ProblemSettings{Federated: true, Rounds: -1}, false},
+		{ProblemSettings{Federated: true, Quorum: -1}, false},
+		{ProblemSettings{Federated: true, Branches: 2}, false},
+		{ProblemSettings{Federated: true, ChainingPolicy: bestChainingPolicy}, false},
+	}
+	for _, test := range tests {
+		settings := test.settings
+		err := validateFederation(&settings)
+		if (err == nil) != test.valid {
+			t.Errorf("Validation of %+v should be %t - %v", test.settings, test.valid, err)
+		}
+	}
+	settings := ProblemSettings{Federated: true}
+	validateFederation(&settings)
+	if settings.Rounds != 1 || settings.ChainingPolicy != sequentialChainingPolicy {
+		t.Errorf("Default federated settings are %+v", settings)
+	}
+}
+
+func TestFederatedRounds(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	tx := 0
+	// invoke calls a smart contract as an organisation in its own transaction
+	invoke := func(org string, function func(shim.ChaincodeStubInterface, []string) sc.Response, args ...string) sc.Response {
+		tx++
+		txId := fmt.Sprintf("mockTxID_%d", tx)
+		mockStub.MockTransactionStart(txId)
+		defer mockStub.MockTransactionEnd(txId)
+		setCreator(t, mockStub, org)
+		return function(mockStub, args)
+	}
+	rounds := func(algoKey string) []keyedRound {
+		var rounds []keyedRound
+		json.Unmarshal(invoke("OrgA", smartContract.queryRounds, algoKey).Payload, &rounds)
+		return rounds
+	}
+	learnuplet := func(key string) Learnuplet {
+		learnuplet := Learnuplet{}
+		json.Unmarshal(mockStub.State[key], &learnuplet)
+		return learnuplet
+	}
+
+	// ACT
+	mockStub.MockTransactionStart("mockTxID")
+	setCreator(t, mockStub, "OrgA")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1", "", `{"federated": true, "rounds": 2, "quorum": 1}`)
+	registerTestItems(t, smartContract, mockStub, problemKey, 2)
+	registerTestWorkers(t, smartContract, mockStub, "worker_a")
+	setCreator(t, mockStub, "OrgB")
+	smartContract.registerItem(mockStub, []string{"data", "8fa81bfc-b5f4-4ba2-b81a-b46424800099", problemKey, ""})
+	registerTestWorkers(t, smartContract, mockStub, "worker_b")
+	registerTestItems(t, smartContract, mockStub, problemKey, 0, "algo1")
+	setCreator(t, mockStub, "OrgC")
+	registerTestWorkers(t, smartContract, mockStub, "worker_c")
+	mockStub.MockTransactionEnd("mockTxID")
+	algoKey := getKeys(t, mockStub, "algo")[0]
+	round0 := rounds(algoKey)
+	learnupletA, learnupletB := round0[0].Learnuplets["OrgA"], round0[0].Learnuplets["OrgB"]
+	wrongWorker := invoke("OrgB", smartContract.setUpletWorker, learnupletA, "worker_b")
+	invoke("OrgA", smartContract.setUpletWorker, learnupletA, "worker_a")
+	wrongOrg := invoke("OrgB", smartContract.reportLearn, testReport(t, mockStub, learnupletA, "done")...)
+	earlyClose := invoke("OrgA", smartContract.closeRound, round0[0].Key)
+	doneA := invoke("OrgA", smartContract.reportLearn, testReport(t, mockStub, learnupletA, "done")...)
+	// the quorum of 1 is reached: the learnuplet of OrgB is cancelled
+	closed := invoke("OrgC", smartContract.closeRound, round0[0].Key)
+	aggregating := rounds(algoKey)
+	invoke("OrgC", smartContract.setUpletWorker, aggregating[0].Aggregation, "worker_c")
+	aggregated := invoke("OrgC", smartContract.reportLearn, testReport(t, mockStub, aggregating[0].Aggregation, "done")...)
+	round1 := rounds(algoKey)
+	// in round 1, the round is aggregated once all organisations reported
+	var failedA, doneB sc.Response
+	if len(round1) == 2 {
+		invoke("OrgA", smartContract.setUpletWorker, round1[1].Learnuplets["OrgA"], "worker_a")
+		invoke("OrgB", smartContract.setUpletWorker, round1[1].Learnuplets["OrgB"], "worker_b")
+		failedA = invoke("OrgA", smartContract.reportLearn, testReport(t, mockStub, round1[1].Learnuplets["OrgA"], "failed")...)
+		doneB = invoke("OrgB", smartContract.reportLearn, testReport(t, mockStub, round1[1].Learnuplets["OrgB"], "done")...)
+	}
+	final := rounds(algoKey)
+	var lastAggregated sc.Response
+	if len(final) == 2 {
+		invoke("OrgC", smartContract.setUpletWorker, final[1].Aggregation, "worker_c")
+		lastAggregated = invoke("OrgC", smartContract.reportLearn, testReport(t, mockStub, final[1].Aggregation, "done")...)
+	}
+	last := rounds(algoKey)
+
+	// ASSERT
+	if len(round0) != 1 || len(round0[0].Organisations) != 2 || round0[0].Status != "open" || round0[0].Number != 0 {
+		t.Fatalf("Round 0 not opened as expected: %+v", round0)
+	}
+	if l := learnuplet(learnupletA); l.Organisation != "OrgA" || len(l.TrainData) != 2 || l.Rank != 0 || l.ModelStartAddress != round0[0].StartModel {
+		t.Errorf("Learnuplet of OrgA not created as expected: %+v", l)
+	}
+	if wrongWorker.Status == 200 || wrongOrg.Status == 200 {
+		t.Errorf("Learnuplet of OrgA should only be trained and reported by OrgA")
+	}
+	if earlyClose.Status == 200 {
+		t.Errorf("Round should not be closed before its quorum")
+	}
+	if doneA.Status != 200 || closed.Status != 200 || aggregated.Status != 200 {
+		t.Fatalf("Round 0 fails - %s%s%s", doneA.Message, closed.Message, aggregated.Message)
+	}
+	if learnuplet(learnupletB).Status != "cancelled" || aggregating[0].Status != "aggregating" {
+		t.Errorf("Round 0 not closed as expected: %+v", aggregating[0])
+	}
+	aggregation := learnuplet(aggregating[0].Aggregation)
+	if aggregation.Type != "aggregation" || aggregation.Rank != 1 || len(aggregation.InputModels) != 1 ||
+		aggregation.InputModels[learnupletA] != learnuplet(learnupletA).ModelEndAddress {
+		t.Errorf("Aggregation of round 0 not created as expected: %+v", aggregation)
+	}
+	if len(round1) != 2 || round1[0].Status != "closed" || round1[0].AggregatedModel != aggregation.ModelEndAddress ||
+		round1[1].StartModel != aggregation.ModelEndAddress || len(round1[1].Learnuplets) != 2 {
+		t.Fatalf("Round 1 not opened from the aggregated model: %+v", round1)
+	}
+	if failedA.Status != 200 || doneB.Status != 200 || lastAggregated.Status != 200 {
+		t.Fatalf("Round 1 fails - %s%s%s", failedA.Message, doneB.Message, lastAggregated.Message)
+	}
+	if final[1].Status != "aggregating" || len(learnuplet(final[1].Aggregation).InputModels) != 1 {
+		t.Errorf("Round 1 should be aggregated once all organisations reported: %+v", final[1])
+	}
+	// the problem has 2 rounds
+	if len(last) != 2 || last[1].Status != "closed" {
+		t.Errorf("Last round not closed as expected: %+v", last)
+	}
+}
@@ -0,0 +1,384 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/satori/go.uuid"
+)
+
+// configKey is the key of the protocol configuration in the ledger
+const configKey = "config"
+
+// Config structure, holding the protocol parameters read by the smart contracts at execution time.
+// It is changed by the admin organisations (MSP IDs), a proposal being applied once Quorum admins voted for it.
+// Version is incremented by each applied proposal.
+// DefaultSizeTrainDataset is the size of mini-batches of problems registered without one (0 to require it),
+// LearnupletTimeout the time in seconds after which a pending learnuplet can be timed out, ClaimWindow the number
+// of ready learnuplets among which a claiming worker is given one, and a worker is unreliable when it finished at
+// least MinFinishedForReputation learnuplets with a reputation lower than MinReputation.
+// A learnuplet timing out after MaxRetries retries is failed instead of being given back to the scheduler.
+type Config struct {
+	Record
+	ObjectType               string   `json:"docType"`
+	Version                  int      `json:"version"`
+	Admins                   []string `json:"admins"`
+	Quorum                   int      `json:"quorum"`
+	DefaultSizeTrainDataset  int      `json:"defaultSizeTrainDataset"`
+	LearnupletTimeout        int      `json:"learnupletTimeout"`
+	ClaimWindow              int      `json:"claimWindow"`
+	MinFinishedForReputation int      `json:"minFinishedForReputation"`
+	MinReputation            float64  `json:"minReputation"`
+	MaxRetries               int      `json:"maxRetries"`
+}
+
+// defaultConfig is the configuration used until one is stored in the ledger
+var defaultConfig = Config{
+	ObjectType:               "config",
+	DefaultSizeTrainDataset:  0,
+	LearnupletTimeout:        24 * 60 * 60,
+	ClaimWindow:              8,
+	MinFinishedForReputation: 5,
+	MinReputation:            0.5,
+	MaxRetries:               3,
+}
+
+// ConfigProposal structure, stored with key proposal_<uuid>.
+// Changes are the fields of the configuration to change, as JSON ("{\"claimWindow\": 16}").
+// Votes are the votes of admins, the proposer voting for it. Status is pending until the proposal is
+// applied, or rejected when too many admins voted against it (or when the changes are not valid anymore
+// once other proposals were applied).
+type ConfigProposal struct {
+	Record
+	ObjectType  string          `json:"docType"`
+	Proposer    string          `json:"proposer"`
+	Changes     string          `json:"changes"`
+	BaseVersion int             `json:"baseVersion"`
+	Votes       map[string]bool `json:"votes"`
+	Status      string          `json:"status"`
+	ProposedAt  string          `json:"proposedAt"`
+	ClosedAt    string          `json:"closedAt"`
+}
+
+// validate checks the parameters of a configuration
+func (config Config) validate() error {
+	if len(config.Admins) == 0 {
+		return fmt.Errorf("admins cannot be empty")
+	}
+	admins := make(map[string]bool)
+	for _, admin := range config.Admins {
+		if admin == "" || admins[admin] {
+			return fmt.Errorf("admins must be distinct and non empty")
+		}
+		admins[admin] = true
+	}
+	if config.Quorum < 1 || config.Quorum > len(config.Admins) {
+		return fmt.Errorf("quorum must be between 1 and the number of admins (%d)", len(config.Admins))
+	}
+	if config.DefaultSizeTrainDataset < 0 {
+		return fmt.Errorf("defaultSizeTrainDataset cannot be negative")
+	}
+	if config.LearnupletTimeout <= 0 {
+		return fmt.Errorf("learnupletTimeout must be strictly positive")
+	}
+	if config.ClaimWindow < 1 {
+		return fmt.Errorf("claimWindow must be strictly positive")
+	}
+	if config.MinFinishedForReputation < 0 {
+		return fmt.Errorf("minFinishedForReputation cannot be negative")
+	}
+	if math.IsNaN(config.MinReputation) || config.MinReputation < 0 || config.MinReputation > 1 {
+		return fmt.Errorf("minReputation must be between 0 and 1")
+	}
+	if config.MaxRetries < 0 {
+		return fmt.Errorf("maxRetries cannot be negative")
+	}
+	return nil
+}
+
+// isAdmin returns true if an organisation is an admin of the configuration
+func (config Config) isAdmin(org string) bool {
+	for _, admin := range config.Admins {
+		if admin == org {
+			return true
+		}
+	}
+	return false
+}
+
+// learnupletTimeout returns the time after which a pending learnuplet can be timed out
+func (config Config) learnupletTimeout() time.Duration {
+	return time.Duration(config.LearnupletTimeout) * time.Second
+}
+
+// applyChanges returns the configuration with the changes of a proposal, which must be valid.
+// The version cannot be changed.
+func (config Config) applyChanges(changes string) (Config, error) {
+	changed := config
+	changed.Admins = append([]string{}, config.Admins...)
+	decoder := json.NewDecoder(bytes.NewReader([]byte(changes)))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&changed)
+	if err != nil {
+		return config, fmt.Errorf("invalid configuration changes - %s", err)
+	}
+	if changed.Version != config.Version || changed.ObjectType != config.ObjectType {
+		return config, fmt.Errorf("invalid configuration changes - version and docType cannot be changed")
+	}
+	err = changed.validate()
+	if err != nil {
+		return config, fmt.Errorf("invalid configuration changes - %s", err)
+	}
+	return changed, nil
+}
+
+// getConfig returns the configuration stored in the ledger, or the default one
+func getConfig(APIstub shim.ChaincodeStubInterface) (config Config, err error) {
+	value, err := APIstub.GetState(configKey)
+	if err != nil {
+		return config, err
+	}
+	if value == nil {
+		return defaultConfig, nil
+	}
+	err = unmarshalRecord(APIstub, value, &config)
+	if err != nil {
+		return config, fmt.Errorf("Problem Unmarshal config - %s", err)
+	}
+	return config, nil
+}
+
+// storeConfig stores the configuration in the ledger
+func storeConfig(APIstub shim.ChaincodeStubInterface, config Config) error {
+	configAsBytes, err := marshalRecord(&config)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(configKey, configAsBytes)
+}
+
+// initConfig stores the configuration with its admin organisations, given as a comma separated list,
+// and optionally its quorum (a majority of admins by default), when the chaincode is initialised.
+// A configuration already stored is kept, so that upgrading the chaincode does not reset it.
+func initConfig(APIstub shim.ChaincodeStubInterface, args []string) error {
+	value, err := APIstub.GetState(configKey)
+	if err != nil {
+		return err
+	}
+	if value != nil || len(args) == 0 {
+		return nil
+	}
+	config := defaultConfig
+	config.Admins = nil
+	for _, admin := range strings.Split(args[0], ",") {
+		config.Admins = append(config.Admins, strings.TrimSpace(admin))
+	}
+	config.Quorum = len(config.Admins)/2 + 1
+	if len(args) > 1 {
+		config.Quorum, err = strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("quorum must be an integer - %s", err)
+		}
+	}
+	err = config.validate()
+	if err != nil {
+		return fmt.Errorf("invalid configuration - %s", err)
+	}
+	return storeConfig(APIstub, config)
+}
+
+// queryConfig is the smart contract to get the protocol configuration
+// Args: none
+func (s *SmartContract) queryConfig(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting none")
+	}
+	config, err := getConfig(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	payload, err := json.Marshal(config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
+
+// proposeConfigChange is the smart contract to propose a change of the protocol configuration
+// Only callable by admin organisations, the proposer voting for the change
+// Args (1 string): changes ("{\"claimWindow\": 16, \"learnupletTimeout\": 7200}")
+func (s *SmartContract) proposeConfigChange(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1: changes")
+	}
+	fmt.Printf("- start proposing configuration change %s \n", args[0])
+
+	config, caller, err := getAdminConfig(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err = config.applyChanges(args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposal := ConfigProposal{ObjectType: "proposal", Proposer: caller, Changes: args[0], BaseVersion: config.Version,
+		Votes: map[string]bool{}, Status: "pending", ProposedAt: txTime.Format(time.RFC3339)}
+	proposalKey := "proposal_" + uuid.NewV4().String()
+	err = voteProposal(APIstub, config, proposalKey, proposal, caller, true)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end proposing configuration change %s \n", proposalKey)
+	return shim.Success(nil)
+}
+
+// voteConfigChange is the smart contract to vote for or against a pending configuration change
+// Only callable by admin organisations, once per proposal
+// Args (2 strings): proposalKey, vote (yes or no)
+func (s *SmartContract) voteConfigChange(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2: proposalKey, vote")
+	}
+	proposalKey := args[0]
+	if args[1] != "yes" && args[1] != "no" {
+		return shim.Error("Vote must be yes or no")
+	}
+	fmt.Printf("- start voting %s on %s \n", args[1], proposalKey)
+
+	config, caller, err := getAdminConfig(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	value, err := APIstub.GetState(proposalKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if value == nil || !strings.HasPrefix(proposalKey, "proposal_") {
+		return shim.Error("No proposal with key - " + proposalKey)
+	}
+	proposal := ConfigProposal{}
+	err = unmarshalRecord(APIstub, value, &proposal)
+	if err != nil {
+		return shim.Error("Problem Unmarshal proposal - " + err.Error())
+	}
+	if proposal.Status != "pending" {
+		return shim.Error("Proposal " + proposalKey + " is already " + proposal.Status)
+	}
+	if _, ok := proposal.Votes[caller]; ok {
+		return shim.Error(caller + " already voted on proposal " + proposalKey)
+	}
+	err = voteProposal(APIstub, config, proposalKey, proposal, caller, args[1] == "yes")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end voting on %s \n", proposalKey)
+	return shim.Success(nil)
+}
+
+// getAdminConfig returns the configuration and the organisation of the caller, which must be an admin
+func getAdminConfig(APIstub shim.ChaincodeStubInterface) (config Config, caller string, err error) {
+	config, err = getConfig(APIstub)
+	if err != nil {
+		return config, caller, err
+	}
+	caller, err = getCallerOrg(APIstub)
+	if err != nil {
+		return config, caller, err
+	}
+	if !config.isAdmin(caller) {
+		return config, caller, fmt.Errorf("%s is not an admin organisation", caller)
+	}
+	return config, caller, nil
+}
+
+// voteProposal records the vote of an admin on a proposal, and closes it once the votes of the current
+// admins reach the quorum: the changes are applied, or the proposal is rejected when the quorum
+// cannot be reached anymore
+func voteProposal(APIstub shim.ChaincodeStubInterface, config Config, proposalKey string, proposal ConfigProposal,
+	admin string, vote bool) error {
+
+	proposal.Votes[admin] = vote
+	yes, no := 0, 0
+	for _, org := range config.Admins {
+		if vote, ok := proposal.Votes[org]; ok && vote {
+			yes++
+		} else if ok {
+			no++
+		}
+	}
+	if yes >= config.Quorum || no > len(config.Admins)-config.Quorum {
+		txTime, err := getTxTime(APIstub)
+		if err != nil {
+			return err
+		}
+		proposal.ClosedAt = txTime.Format(time.RFC3339)
+		proposal.Status = "rejected"
+	}
+	if yes >= config.Quorum {
+		// the changes are applied on the current configuration, which other proposals may have changed
+		changed, err := config.applyChanges(proposal.Changes)
+		if err == nil {
+			changed.Version++
+			err = storeConfig(APIstub, changed)
+			if err != nil {
+				return err
+			}
+			proposal.Status = "applied"
+			fmt.Printf("-- configuration changed to version %d \n", changed.Version)
+		} else {
+			fmt.Printf("-- proposal %s rejected - %s \n", proposalKey, err)
+		}
+	}
+	proposalAsBytes, err := marshalRecord(&proposal)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(proposalKey, proposalAsBytes)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+func TestApplyConfigChanges(t *testing.T) {
+	config := defaultConfig
+	config.Admins = []string{"OrgA", "OrgB"}
+	config.Quorum = 2
+	tests := []struct {
+		changes string
+		valid   bool
+	}{
+		{`{"claimWindow": 16}`, true},
+		{`{"admins": ["OrgA", "OrgB", "OrgC"], "quorum": 3}`, true},
+		{`{"minReputation": 0.3, "learnupletTimeout": 3600}`, true},
+		{`{"claimWindow": 0}`, false},
+		{`{"quorum": 3}`, false},
+		{`{"admins": ["OrgA", "OrgA"]}`, false},
+		{`{"minReputation": 2}`, false},
+		{`{"version": 5}`, false},
+		{`{"maxRetries": 0}`, true},
+		{`{"maxRetries": -1}`, false},
+		{`{"leaseDuration": 3600}`, false},
+	}
+	for _, test := range tests {
+		_, err := config.applyChanges(test.changes)
+		if (err == nil) != test.valid {
+			t.Errorf("Validation of %s should be %t - %v", test.changes, test.valid, err)
+		}
+	}
+	changed, _ := config.applyChanges(`{"admins": ["OrgC"], "quorum": 1}`)
+	if config.Admins[0] != "OrgA" || changed.Admins[0] != "OrgC" || changed.ClaimWindow != defaultConfig.ClaimWindow {
+		t.Errorf("Changes not applied on a copy of the configuration: %+v %+v", config, changed)
+	}
+}
+
+func TestConfigGovernance(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	tx := 0
+	// invoke calls a smart contract as an organisation in its own transaction
+	invoke := func(org string, function func(shim.ChaincodeStubInterface, []string) sc.Response, args ...string) sc.Response {
+		tx++
+		txId := fmt.Sprintf("mockTxID_%d", tx)
+		mockStub.MockTransactionStart(txId)
+		defer mockStub.MockTransactionEnd(txId)
+		setCreator(t, mockStub, org)
+		return function(mockStub, args)
+	}
+	getProposal := func(proposalKey string) ConfigProposal {
+		proposal := ConfigProposal{}
+		json.Unmarshal(mockStub.State[proposalKey], &proposal)
+		return proposal
+	}
+
+	// ACT
+	noDefault := invoke("OrgA", smartContract.registerProblem, "dda81bfc-b5f4-5ba2-b81a-b464248f02d2", "", "")
+	initialised := mockStub.MockInit("mockTxID_init", [][]byte{[]byte("init"), []byte("fresh"), []byte("OrgA, OrgB, OrgC")})
+	notAdmin := invoke("OrgD", smartContract.proposeConfigChange, `{"claimWindow": 16}`)
+	invalid := invoke("OrgA", smartContract.proposeConfigChange, `{"claimWindow": 0}`)
+	proposed := invoke("OrgA", smartContract.proposeConfigChange, `{"learnupletTimeout": 60, "defaultSizeTrainDataset": 3}`)
+	proposalKey := getKeys(t, mockStub, "proposal")[0]
+	pending := getProposal(proposalKey)
+	votedTwice := invoke("OrgA", smartContract.voteConfigChange, proposalKey, "yes")
+	voted := invoke("OrgB", smartContract.voteConfigChange, proposalKey, "yes")
+	votedClosed := invoke("OrgC", smartContract.voteConfigChange, proposalKey, "yes")
+	invoke("OrgB", smartContract.proposeConfigChange, `{"admins": ["OrgB"], "quorum": 1}`)
+	var rejectedKey string
+	for _, key := range getKeys(t, mockStub, "proposal") {
+		if key != proposalKey {
+			rejectedKey = key
+		}
+	}
+	invoke("OrgA", smartContract.voteConfigChange, rejectedKey, "no")
+	invoke("OrgC", smartContract.voteConfigChange, rejectedKey, "no")
+	config := Config{}
+	json.Unmarshal(invoke("OrgD", smartContract.queryConfig).Payload, &config)
+	registered := invoke("OrgA", smartContract.registerProblem, "dda81bfc-b5f4-5ba2-b81a-b464248f02d2", "", "")
+	reinitialised := mockStub.MockInit("mockTxID_upgrade", [][]byte{[]byte("init"), []byte("upgrade"), []byte("OrgD")})
+	upgraded, _ := getConfig(mockStub)
+
+	// ASSERT
+	if noDefault.Status == 200 {
+		t.Errorf("Problem registered without size of mini-batches nor default one")
+	}
+	if initialised.Status != 200 {
+		t.Fatalf("Init fails - %s", initialised.Message)
+	}
+	if notAdmin.Status == 200 || invalid.Status == 200 {
+		t.Errorf("Configuration changes should only be valid ones proposed by admins")
+	}
+	if proposed.Status != 200 || pending.Status != "pending" || !pending.Votes["OrgA"] || pending.Proposer != "OrgA" {
+		t.Fatalf("Proposal not recorded as expected - %s %+v", proposed.Message, pending)
+	}
+	if votedTwice.Status == 200 || voted.Status != 200 || votedClosed.Status == 200 {
+		t.Errorf("Admins should vote once on pending proposals - %s", voted.Message)
+	}
+	if proposal := getProposal(proposalKey); proposal.Status != "applied" || proposal.ClosedAt == "" {
+		t.Errorf("Proposal reaching the quorum not applied: %+v", proposal)
+	}
+	if proposal := getProposal(rejectedKey); proposal.Status != "rejected" {
+		t.Errorf("Proposal which cannot reach the quorum not rejected: %+v", proposal)
+	}
+	if config.Version != 1 || config.LearnupletTimeout != 60 || config.Quorum != 2 || len(config.Admins) != 3 ||
+		config.ClaimWindow != defaultConfig.ClaimWindow {
+		t.Errorf("Configuration not changed as expected: %+v", config)
+	}
+	if registered.Status != 200 {
+		t.Errorf("Problem not registered with the default size of mini-batches - %s", registered.Message)
+	}
+	if problem, _ := getProblem(mockStub, getKeys(t, mockStub, "problem")[0]); problem.SizeTrainDataset != 3 {
+		t.Errorf("Default size of mini-batches not used: %d", problem.SizeTrainDataset)
+	}
+	if reinitialised.Status != 200 || upgraded.Version != 1 || upgraded.Admins[0] != "OrgA" {
+		t.Errorf("Configuration reset by a chaincode upgrade: %+v", upgraded)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// leaderboardEntry is the score of an algo on a metric of its problem: the performance of its best
+// model in each cross-validation fold, and the mean and variance of these performances across folds.
+// Folds is the number of folds in which a model of the algo is done, Models the best model of each one.
+type leaderboardEntry struct {
+	Algo      string          `json:"algo"`
+	Name      string          `json:"name"`
+	Folds     int             `json:"folds"`
+	FoldPerfs map[int]float64 `json:"foldPerfs"`
+	Models    map[int]string  `json:"models"`
+	Mean      float64         `json:"mean"`
+	Variance  float64         `json:"variance"`
+}
+
+// getMetric returns the metric of a problem with a given name, the primary metric if the name is empty
+func (problem Problem) getMetric(name string) (Metric, error) {
+	if name == "" {
+		return problem.getPrimaryMetric(), nil
+	}
+	for _, metric := range problem.getMetrics() {
+		if metric.Name == name {
+			return metric, nil
+		}
+	}
+	return Metric{}, fmt.Errorf("metric %s is not declared by the problem", name)
+}
+
+// learnupletPerf returns the performance of the model of a done learnuplet on a metric.
+// Learnuplets reported before problems had several metrics only have a perf for the primary metric.
+func learnupletPerf(problem Problem, learnuplet Learnuplet, metric Metric) (float64, bool) {
+	if learnuplet.Status != "done" {
+		return 0, false
+	}
+	if perf, ok := learnuplet.Perfs[metric.Name]; ok {
+		return perf, true
+	}
+	if len(learnuplet.Perfs) == 0 && metric.Name == problem.getPrimaryMetric().Name {
+		return learnuplet.Perf, true
+	}
+	return 0, false
+}
+
+// meanVariance returns the mean and the (population) variance of values
+func meanVariance(values []float64) (mean float64, variance float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	for _, value := range values {
+		mean += value
+	}
+	mean /= float64(len(values))
+	for _, value := range values {
+		variance += (value - mean) * (value - mean)
+	}
+	return mean, variance / float64(len(values))
+}
+
+// newLeaderboardEntry scores an algo from its learnuplets. It returns false if no model of the algo is done.
+func newLeaderboardEntry(problem Problem, metric Metric, algoKey string, name string,
+	learnuplets []keyedLearnuplet) (leaderboardEntry, bool) {
+
+	entry := leaderboardEntry{Algo: algoKey, Name: name, FoldPerfs: make(map[int]float64), Models: make(map[int]string)}
+	for _, learnuplet := range learnuplets {
+		perf, ok := learnupletPerf(problem, learnuplet.Learnuplet, metric)
+		if !ok {
+			continue
+		}
+		if best, found := entry.FoldPerfs[learnuplet.Fold]; !found || metric.better(perf, best) {
+			entry.FoldPerfs[learnuplet.Fold] = perf
+			entry.Models[learnuplet.Fold] = learnuplet.ModelEndAddress
+		}
+	}
+	if len(entry.FoldPerfs) == 0 {
+		return entry, false
+	}
+	var folds []int
+	for fold := range entry.FoldPerfs {
+		folds = append(folds, fold)
+	}
+	sort.Ints(folds)
+	var perfs []float64
+	for _, fold := range folds {
+		perfs = append(perfs, entry.FoldPerfs[fold])
+	}
+	entry.Folds = len(perfs)
+	entry.Mean, entry.Variance = meanVariance(perfs)
+	return entry, true
+}
+
+// getLeaderboard ranks the active algos of a problem on a metric, from the best to the worst
+func getLeaderboard(APIstub shim.ChaincodeStubInterface, problemKey string, problem Problem,
+	metric Metric) ([]leaderboardEntry, error) {
+
+	algoKeys, err := getProblemItems(APIstub, problemKey, "algo")
+	if err != nil {
+		return nil, err
+	}
+	leaderboard := []leaderboardEntry{}
+	for _, algoKey := range algoKeys {
+		value, err := APIstub.GetState(algoKey)
+		if err != nil {
+			return nil, err
+		}
+		algo := Item{}
+		err = unmarshalRecord(APIstub, value, &algo)
+		if err != nil {
+			return nil, fmt.Errorf("Problem Unmarshal %s - %s", algoKey, err)
+		}
+		learnuplets, err := getLearnupletsByIndex(APIstub, "algo", algoKey)
+		if err != nil {
+			return nil, err
+		}
+		if entry, ok := newLeaderboardEntry(problem, metric, algoKey, algo.Name, learnuplets); ok {
+			leaderboard = append(leaderboard, entry)
+		}
+	}
+	sort.Slice(leaderboard, func(i, j int) bool {
+		a, b := leaderboard[i], leaderboard[j]
+		if a.Mean != b.Mean {
+			return metric.better(a.Mean, b.Mean)
+		}
+		return a.Algo < b.Algo
+	})
+	return leaderboard, nil
+}
+
+// queryLeaderboard is the smart contract to rank the algos of a problem on a metric, by the mean
+// performance of their best models across cross-validation folds. Problems which are not
+// cross-validated have a single fold.
+// Args (1 or 2 strings): problemKey, optionally metric (primary metric of the problem otherwise)
+func (s *SmartContract) queryLeaderboard(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2: problemKey, optionally metric")
+	}
+	problemKey := args[0]
+	fmt.Printf("- start building leaderboard of %s \n", problemKey)
+
+	problem, err := getProblem(APIstub, problemKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	metricName := ""
+	if len(args) == 2 {
+		metricName = args[1]
+	}
+	metric, err := problem.getMetric(metricName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	leaderboard, err := getLeaderboard(APIstub, problemKey, problem, metric)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	payload, err := json.Marshal(leaderboard)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end building leaderboard of %s \n", problemKey)
+	return shim.Success(payload)
+}
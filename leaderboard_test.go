@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestMeanVariance(t *testing.T) {
+	mean, variance := meanVariance([]float64{0.6, 0.8, 0.7})
+	if math.Abs(mean-0.7) > 1e-9 || math.Abs(variance-0.02/3) > 1e-9 {
+		t.Errorf("Mean %g and variance %g instead of 0.7 and %g", mean, variance, 0.02/3)
+	}
+	if mean, variance := meanVariance(nil); mean != 0 || variance != 0 {
+		t.Errorf("Mean and variance of no value should be 0")
+	}
+}
+
+func TestNewLeaderboardEntry(t *testing.T) {
+	problem := Problem{ProblemSettings: ProblemSettings{
+		Metrics:       []Metric{{Name: "auc", Direction: "higher"}, {Name: "logloss", Direction: "lower"}},
+		PrimaryMetric: "auc",
+	}}
+	learnuplet := func(fold int, status string, auc float64, logloss float64) keyedLearnuplet {
+		return keyedLearnuplet{Learnuplet: Learnuplet{Fold: fold, Status: status, ModelEndAddress: "model",
+			Perfs: map[string]float64{"auc": auc, "logloss": logloss}}}
+	}
+	learnuplets := []keyedLearnuplet{
+		learnuplet(0, "done", 0.7, 0.5),
+		learnuplet(0, "done", 0.8, 0.6),
+		learnuplet(1, "done", 0.6, 0.4),
+		learnuplet(1, "failed", 0.9, 0.1),
+		learnuplet(2, "todo", 0, 0),
+	}
+
+	entry, ok := newLeaderboardEntry(problem, problem.getPrimaryMetric(), "algo_1", "algo1", learnuplets)
+	logloss, _ := problem.getMetric("logloss")
+	lossEntry, _ := newLeaderboardEntry(problem, logloss, "algo_1", "algo1", learnuplets)
+	_, found := newLeaderboardEntry(problem, logloss, "algo_1", "algo1", learnuplets[3:])
+
+	if !ok || entry.Folds != 2 || entry.FoldPerfs[0] != 0.8 || entry.FoldPerfs[1] != 0.6 ||
+		math.Abs(entry.Mean-0.7) > 1e-9 || math.Abs(entry.Variance-0.01) > 1e-9 {
+		t.Errorf("Unexpected auc entry: %+v", entry)
+	}
+	if lossEntry.FoldPerfs[0] != 0.5 || lossEntry.FoldPerfs[1] != 0.4 {
+		t.Errorf("Best models on logloss should be the lowest ones: %+v", lossEntry)
+	}
+	if found {
+		t.Errorf("An algo without done model should not be in the leaderboard")
+	}
+	if _, err := problem.getMetric("accuracy"); err == nil {
+		t.Errorf("Undeclared metric should not be found")
+	}
+}
+
+func TestQueryLeaderboard(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	mockStub.MockTransactionStart("mockTxID")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "10", "", `{"split": {"strategy": "kfold", "folds": 2}}`)
+	registerTestItems(t, smartContract, mockStub, problemKey, 30, "algo1", "algo2", "algo3")
+	// models of algo1 are better than the ones of algo2 on average, algo3 has none
+	perfs := map[string][]float64{"algo1": {0.7, 0.9}, "algo2": {0.75, 0.75}}
+	for _, key := range getKeys(t, mockStub, "learnuplet") {
+		learnuplet := Learnuplet{}
+		json.Unmarshal(mockStub.State[key], &learnuplet)
+		for algoName, algoPerfs := range perfs {
+			if learnuplet.Algo[getAlgoKey(learnuplet)] == "8fa81bfc-b5f4-4ba2-b81a-"+algoName {
+				learnuplet.Status = "done"
+				learnuplet.Perf = algoPerfs[learnuplet.Fold]
+				learnuplet.Perfs = map[string]float64{"perf": algoPerfs[learnuplet.Fold]}
+			}
+		}
+		storeLearnuplet(mockStub, key, learnuplet)
+	}
+
+	// ACT
+	response := smartContract.queryLeaderboard(mockStub, []string{problemKey})
+	wrongMetric := smartContract.queryLeaderboard(mockStub, []string{problemKey, "auc"})
+	mockStub.MockTransactionEnd("mockTxID")
+
+	// ASSERT
+	if response.GetStatus() != 200 {
+		t.Fatalf("queryLeaderboard fails - %s", response.Message)
+	}
+	if wrongMetric.GetStatus() == 200 {
+		t.Errorf("queryLeaderboard should fail on an undeclared metric")
+	}
+	var leaderboard []leaderboardEntry
+	json.Unmarshal(response.GetPayload(), &leaderboard)
+	if len(leaderboard) != 2 || leaderboard[0].Name != "algo1" || leaderboard[1].Name != "algo2" {
+		t.Fatalf("Unexpected leaderboard: %+v", leaderboard)
+	}
+	if leaderboard[0].Folds != 2 || math.Abs(leaderboard[0].Mean-0.8) > 1e-9 || math.Abs(leaderboard[0].Variance-0.01) > 1e-9 ||
+		leaderboard[1].Variance != 0 {
+		t.Errorf("Unexpected scores: %+v", leaderboard)
+	}
+}
@@ -0,0 +1,308 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Metadata describes a problem, an algo or a data, for display purposes.
+// Description is a free text.
+// Tags are lower case keywords, such as the data modality (image, ecg, ...).
+// Licence is the licence under which the object is shared.
+// Format is the format of the object on Storage, such as csv or dicom.
+// Created is the time of the registration, set by the orchestrator (RFC 3339).
+// Extra holds any other free-form information.
+type Metadata struct {
+	Description string            `json:"description"`
+	Tags        []string          `json:"tags"`
+	Licence     string            `json:"licence"`
+	Format      string            `json:"format"`
+	Created     string            `json:"created"`
+	Extra       map[string]string `json:"extra"`
+}
+
+// Limits of the metadata schema
+const (
+	maxDescriptionLength = 4096
+	maxFieldLength       = 128
+	maxTags              = 16
+	maxExtra             = 32
+)
+
+var tagRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// parseMetadata decodes and validates metadata given as a JSON string.
+// Unknown fields are rejected, and Created is ignored since it is set by the orchestrator.
+func parseMetadata(metadataAsString string) (metadata Metadata, err error) {
+	if strings.TrimSpace(metadataAsString) == "" {
+		return metadata, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(metadataAsString)))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&metadata)
+	if err != nil {
+		return metadata, fmt.Errorf("invalid metadata - %s", err)
+	}
+	metadata.Created = ""
+
+	if len(metadata.Description) > maxDescriptionLength {
+		return metadata, fmt.Errorf("invalid metadata - description longer than %d characters", maxDescriptionLength)
+	}
+	if len(metadata.Licence) > maxFieldLength || len(metadata.Format) > maxFieldLength {
+		return metadata, fmt.Errorf("invalid metadata - licence and format must be shorter than %d characters", maxFieldLength)
+	}
+	if len(metadata.Tags) > maxTags {
+		return metadata, fmt.Errorf("invalid metadata - more than %d tags", maxTags)
+	}
+	seenTags := make(map[string]bool)
+	for _, tag := range metadata.Tags {
+		if !tagRegexp.MatchString(tag) {
+			return metadata, fmt.Errorf("invalid metadata - tag %q must match %s", tag, tagRegexp.String())
+		}
+		if seenTags[tag] {
+			return metadata, fmt.Errorf("invalid metadata - duplicated tag %q", tag)
+		}
+		seenTags[tag] = true
+	}
+	if len(metadata.Extra) > maxExtra {
+		return metadata, fmt.Errorf("invalid metadata - more than %d extra fields", maxExtra)
+	}
+	for key, value := range metadata.Extra {
+		if key == "" || len(key) > maxFieldLength || len(value) > maxFieldLength {
+			return metadata, fmt.Errorf("invalid metadata - extra field %q must have a key and a value shorter than %d characters", key, maxFieldLength)
+		}
+	}
+	return metadata, nil
+}
+
+// getTxTime returns the time of the transaction, which is the same on all endorsing peers
+func getTxTime(APIstub shim.ChaincodeStubInterface) (time.Time, error) {
+	txTimestamp, err := APIstub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(txTimestamp.GetSeconds(), int64(txTimestamp.GetNanos())).UTC(), nil
+}
+
+// newMetadata parses metadata given at registration and sets its creation time
+func newMetadata(APIstub shim.ChaincodeStubInterface, metadataAsString string) (metadata Metadata, err error) {
+	metadata, err = parseMetadata(metadataAsString)
+	if err != nil {
+		return metadata, err
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return metadata, err
+	}
+	metadata.Created = txTime.Format(time.RFC3339)
+	return metadata, nil
+}
+
+// indexTags updates the composite key tag~type~key of an object,
+// given its old and its new tags
+func indexTags(APIstub shim.ChaincodeStubInterface, objectType string, key string,
+	oldTags []string, newTags []string) error {
+
+	indexName := "tag~type~key"
+	for _, tag := range oldTags {
+		tagIndexKey, err := APIstub.CreateCompositeKey(indexName, []string{tag, objectType, key})
+		if err != nil {
+			return err
+		}
+		err = APIstub.DelState(tagIndexKey)
+		if err != nil {
+			return err
+		}
+	}
+	for _, tag := range newTags {
+		tagIndexKey, err := APIstub.CreateCompositeKey(indexName, []string{tag, objectType, key})
+		if err != nil {
+			return err
+		}
+		err = APIstub.PutState(tagIndexKey, []byte{0x00})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateMetadata is the smart contract to replace the metadata of a problem, an algo or a data.
+// The creation time is kept. Problems and items can only be updated by the organisation which registered them.
+// Args (2 strings): objectKey, metadata ("{\"description\": \"...\", \"tags\": [\"image\"], ...}")
+func (s *SmartContract) updateMetadata(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2: objectKey, metadata")
+	}
+
+	objectKey := args[0]
+	fmt.Printf("- start update metadata of %s \n", objectKey)
+
+	metadata, err := parseMetadata(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	value, err := APIstub.GetState(objectKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if value == nil {
+		return shim.Error("No object with key - " + objectKey)
+	}
+	caller, err := getCallerOrg(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var objectType string
+	var oldTags []string
+	var objectAsBytes []byte
+	if strings.HasPrefix(objectKey, "problem_") {
+		problem := Problem{}
+		err = unmarshalRecord(APIstub, value, &problem)
+		if err != nil {
+			return shim.Error("Problem Unmarshal problem - " + err.Error())
+		}
+		err = checkProblemOwner(APIstub, objectKey, problem)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		objectType = problem.ObjectType
+		oldTags = problem.Metadata.Tags
+		metadata.Created = problem.Metadata.Created
+		problem.Metadata = metadata
+		objectAsBytes, err = marshalRecord(&problem)
+	} else if strings.HasPrefix(objectKey, "data_") || strings.HasPrefix(objectKey, "algo_") {
+		item := Item{}
+		err = unmarshalRecord(APIstub, value, &item)
+		if err != nil {
+			return shim.Error("Problem Unmarshal item - " + err.Error())
+		}
+		if item.Owner != "" && item.Owner != caller {
+			return shim.Error("Metadata of " + objectKey + " can only be updated by " + item.Owner)
+		}
+		objectType = item.ObjectType
+		oldTags = item.Metadata.Tags
+		metadata.Created = item.Metadata.Created
+		item.Metadata = metadata
+		objectAsBytes, err = marshalRecord(&item)
+	} else {
+		return shim.Error("Metadata can only be set on problems, algos and data")
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = APIstub.PutState(objectKey, objectAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = indexTags(APIstub, objectType, objectKey, oldTags, metadata.Tags)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("- end update metadata of %s \n", objectKey)
+	return shim.Success(nil)
+}
+
+// queryByTag is the smart contract to get all open problems, and active algos and data, with a given tag
+// Args (1 or 2 strings): tag, optionally objectType (problem, algo or data)
+func (s *SmartContract) queryByTag(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2: tag, optionally objectType")
+	}
+
+	tag := args[0]
+	fmt.Printf("- start looking for objects with tag %s \n", tag)
+
+	// Query the tag~type~key index by tag, and by type if given
+	tagIterator, err := APIstub.GetStateByPartialCompositeKey("tag~type~key", args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer tagIterator.Close()
+
+	var objects []map[string]interface{}
+	for tagIterator.HasNext() {
+		responseRange, err := tagIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, compositeKeyParts, err := APIstub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		returnedKey := compositeKeyParts[2]
+		value, err := APIstub.GetState(returnedKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		// the index may still reference a deleted object
+		if value == nil {
+			continue
+		}
+		var object map[string]interface{}
+		err = unmarshalRecord(APIstub, value, &object)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		// only open problems, and items which are still active, are returned
+		if object["docType"] == "problem" && object["status"] != "open" ||
+			object["docType"] != "problem" && object["status"] != "active" {
+			continue
+		}
+		object["key"] = returnedKey
+		objects = append(objects, object)
+	}
+
+	payload, err := json.Marshal(objects)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end looking for objects with tag %s \n", tag)
+	return shim.Success(payload)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+		valid    bool
+	}{
+		{"empty", "", true},
+		{"complete", `{"description": "chest x-rays", "tags": ["image", "x-ray"], "licence": "CC-BY-4.0", "format": "dicom", "extra": {"modality": "CR"}}`, true},
+		{"unknown field", `{"descr": "chest x-rays"}`, false},
+		{"invalid tag", `{"tags": ["Chest X-Ray"]}`, false},
+		{"duplicated tag", `{"tags": ["image", "image"]}`, false},
+		{"empty extra key", `{"extra": {"": "CR"}}`, false},
+		{"not a json object", `["image"]`, false},
+	}
+	for _, test := range tests {
+		_, err := parseMetadata(test.metadata)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: parseMetadata returned error %v", test.name, err)
+		}
+	}
+}
+
+func TestUpdateMetadataAndQueryByTag(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+	metadata := `{"description": "chest x-rays", "tags": ["image", "x-ray"], "licence": "CC-BY-4.0", "format": "dicom"}`
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	setCreator(t, mockStub, "OrgA")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "2")
+	registerResponse := smartContract.registerItem(mockStub, []string{"data", "8fa81bfc-b5f4-4ba2-b81a-b46424800000", problemKey, "xrays", metadata})
+	invalidResponse := smartContract.registerItem(mockStub, []string{"data", "8fa81bfc-b5f4-4ba2-b81a-b46424800001", problemKey, "xrays", `{"tags": "image"}`})
+	dataKey := ""
+	for _, key := range getKeys(t, mockStub, "data") {
+		item := Item{}
+		itemAsBytes, _ := mockStub.GetState(key)
+		json.Unmarshal(itemAsBytes, &item)
+		if item.Name == "xrays" {
+			dataKey = key
+		}
+	}
+	imageBeforeUpdate := smartContract.queryByTag(mockStub, []string{"image"})
+	setCreator(t, mockStub, "OrgB")
+	otherOrgData := smartContract.updateMetadata(mockStub, []string{dataKey, `{"tags": ["spam"]}`})
+	otherOrgProblem := smartContract.updateMetadata(mockStub, []string{problemKey, `{"tags": ["spam"]}`})
+	setCreator(t, mockStub, "OrgA")
+	updateResponse := smartContract.updateMetadata(mockStub, []string{dataKey, `{"description": "chest x-rays", "tags": ["x-ray", "radiology"]}`})
+	problemResponse := smartContract.updateMetadata(mockStub, []string{problemKey, `{"tags": ["radiology"]}`})
+	imageAfterUpdate := smartContract.queryByTag(mockStub, []string{"image"})
+	radiologyData := smartContract.queryByTag(mockStub, []string{"radiology", "data"})
+	radiology := smartContract.queryByTag(mockStub, []string{"radiology"})
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	if s := registerResponse.GetStatus(); s != 200 {
+		t.Fatalf("the status is %d, instead of 200 - %s", s, registerResponse.Message)
+	}
+	if invalidResponse.GetStatus() == 200 {
+		t.Errorf("Registration with invalid metadata should fail")
+	}
+	if otherOrgData.GetStatus() == 200 || otherOrgProblem.GetStatus() == 200 {
+		t.Errorf("Metadata updated by another organisation than the owner")
+	}
+	if updateResponse.GetStatus() != 200 || problemResponse.GetStatus() != 200 {
+		t.Fatalf("Update of metadata fails")
+	}
+	countObjects := func(payload []byte) int {
+		var objects []map[string]interface{}
+		json.Unmarshal(payload, &objects)
+		return len(objects)
+	}
+	if n := countObjects(imageBeforeUpdate.GetPayload()); n != 1 {
+		t.Errorf("%d objects tagged image instead of 1", n)
+	}
+	if n := countObjects(imageAfterUpdate.GetPayload()); n != 0 {
+		t.Errorf("%d objects still tagged image instead of 0", n)
+	}
+	if n := countObjects(radiologyData.GetPayload()); n != 1 {
+		t.Errorf("%d data tagged radiology instead of 1", n)
+	}
+	if n := countObjects(radiology.GetPayload()); n != 2 {
+		t.Errorf("%d objects tagged radiology instead of 2", n)
+	}
+	// the creation time is kept
+	item := Item{}
+	itemAsBytes, _ := mockStub.GetState(dataKey)
+	json.Unmarshal(itemAsBytes, &item)
+	if item.Metadata.Created == "" || item.Metadata.Licence != "" {
+		t.Errorf("Update of metadata fails")
+	}
+}
+
+func TestUpdateMetadataOfOwnerlessProblem(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	mockStub.MockInit("mockTxID_init", [][]byte{[]byte("init"), []byte("fresh"), []byte("OrgA")})
+	problemKey := "problem_dda81bfc-b5f4-5ba2-b81a-b464248f02d2"
+	invoke := func(org string, args ...string) int32 {
+		mockStub.MockTransactionStart("mockTxID")
+		defer mockStub.MockTransactionEnd("mockTxID")
+		setCreator(t, mockStub, org)
+		return smartContract.updateMetadata(mockStub, args).Status
+	}
+
+	// ACT
+	mockStub.MockTransactionStart("mockTxID_problem")
+	storeProblem(mockStub, problemKey, Problem{ObjectType: "problem", Status: "open", SizeTrainDataset: 1})
+	mockStub.MockTransactionEnd("mockTxID_problem")
+	notAdmin := invoke("OrgB", problemKey, `{"tags": ["spam"]}`)
+	admin := invoke("OrgA", problemKey, `{"tags": ["radiology"]}`)
+
+	// ASSERT
+	if notAdmin == 200 {
+		t.Errorf("Metadata of a problem without owner updated by an organisation which is not admin")
+	}
+	if admin != 200 {
+		t.Errorf("Metadata of a problem without owner not updated by an admin")
+	}
+}
+
+func TestQueryByTagFilters(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	metadata := `{"tags": ["x-ray"]}`
+
+	// ACT
+	mockStub.MockTransactionStart("mockTxID")
+	setCreator(t, mockStub, "OrgA")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1", metadata)
+	smartContract.registerItem(mockStub, []string{"data", "8fa81bfc-b5f4-4ba2-b81a-b46424800000", problemKey, "kept", metadata})
+	smartContract.registerItem(mockStub, []string{"data", "8fa81bfc-b5f4-4ba2-b81a-b46424800001", problemKey, "withdrawn", metadata})
+	dataKeys := map[string]string{}
+	for _, key := range getKeys(t, mockStub, "data") {
+		item := Item{}
+		json.Unmarshal(mockStub.State[key], &item)
+		dataKeys[item.Name] = key
+	}
+	withdrawn := smartContract.withdrawItem(mockStub, []string{dataKeys["withdrawn"], "consent revoked"})
+	frozen := smartContract.setProblemStatus(mockStub, []string{problemKey, "frozen"})
+	danglingKey, _ := mockStub.CreateCompositeKey("tag~type~key", []string{"x-ray", "data", "data_deleted"})
+	mockStub.PutState(danglingKey, []byte{0x00})
+	response := smartContract.queryByTag(mockStub, []string{"x-ray"})
+	mockStub.MockTransactionEnd("mockTxID")
+
+	// ASSERT
+	if withdrawn.Status != 200 || frozen.Status != 200 {
+		t.Fatalf("Withdrawal or freeze fails - %s %s", withdrawn.Message, frozen.Message)
+	}
+	if response.Status != 200 {
+		t.Fatalf("queryByTag fails - %s", response.Message)
+	}
+	var objects []map[string]interface{}
+	json.Unmarshal(response.Payload, &objects)
+	if len(objects) != 1 || objects[0]["key"] != dataKeys["kept"] {
+		t.Errorf("Objects tagged x-ray are %v instead of the kept data", objects)
+	}
+}
@@ -0,0 +1,266 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Metric is a named evaluation metric of a problem.
+// Direction is higher if higher values are better, lower otherwise (e.g. log-loss).
+// Min and Max optionally bound the values of the metric, such as [0, 1] for an AUC.
+type Metric struct {
+	Name      string   `json:"name"`
+	Direction string   `json:"direction"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+}
+
+// defaultMetric is the metric of problems which do not declare any
+var defaultMetric = Metric{Name: "perf", Direction: "higher"}
+
+// better returns true if perf a is strictly better than perf b for the metric
+func (metric Metric) better(a float64, b float64) bool {
+	if metric.Direction == "lower" {
+		return a < b
+	}
+	return a > b
+}
+
+// validateMetrics checks the metrics declared by a problem and sets the primary metric
+// to the first one if not given
+func validateMetrics(settings *ProblemSettings) error {
+	if len(settings.Metrics) == 0 {
+		if settings.PrimaryMetric != "" && settings.PrimaryMetric != defaultMetric.Name {
+			return fmt.Errorf("primary metric %s is not declared", settings.PrimaryMetric)
+		}
+		return nil
+	}
+	names := make(map[string]bool)
+	for _, metric := range settings.Metrics {
+		if metric.Name == "" {
+			return fmt.Errorf("metrics must have a name")
+		}
+		if names[metric.Name] {
+			return fmt.Errorf("metric %s is declared twice", metric.Name)
+		}
+		if metric.Direction != "higher" && metric.Direction != "lower" {
+			return fmt.Errorf("direction of metric %s must be higher or lower", metric.Name)
+		}
+		if metric.Min != nil && metric.Max != nil && *metric.Min > *metric.Max {
+			return fmt.Errorf("range of metric %s is empty", metric.Name)
+		}
+		names[metric.Name] = true
+	}
+	if settings.PrimaryMetric == "" {
+		settings.PrimaryMetric = settings.Metrics[0].Name
+	}
+	if !names[settings.PrimaryMetric] {
+		return fmt.Errorf("primary metric %s is not declared", settings.PrimaryMetric)
+	}
+	return nil
+}
+
+// getMetrics returns the metrics of a problem
+func (problem Problem) getMetrics() []Metric {
+	if len(problem.Metrics) == 0 {
+		return []Metric{defaultMetric}
+	}
+	return problem.Metrics
+}
+
+// getPrimaryMetric returns the metric used to rank models of a problem
+func (problem Problem) getPrimaryMetric() Metric {
+	for _, metric := range problem.getMetrics() {
+		if metric.Name == problem.PrimaryMetric {
+			return metric
+		}
+	}
+	return problem.getMetrics()[0]
+}
+
+// parsePerfs parses the performances of a model, given either as a single number,
+// which is the value of the primary metric, or as a map {"metric": value, ...}.
+// All values of declared metrics must be given.
+func parsePerfs(problem Problem, perfAsString string) (perfs map[string]float64, err error) {
+	primaryMetric := problem.getPrimaryMetric()
+	if value, errFloat := strconv.ParseFloat(strings.TrimSpace(perfAsString), 64); errFloat == nil {
+		perfs = map[string]float64{primaryMetric.Name: value}
+	} else if err = json.Unmarshal([]byte(perfAsString), &perfs); err != nil {
+		return nil, fmt.Errorf("perf must be a number or a map {\"metric\": value, ...} - %s", err)
+	}
+	return perfs, checkMetricNames(problem, perfs)
+}
+
+// parseDataPerfs parses the performances of a model on each data, given as a map
+// {"data_i": perf_i, ...}, where perf_i is either a single number, which is the value of the
+// primary metric, or a map {"metric": value, ...}.
+func parseDataPerfs(problem Problem, perfAsString string) (dataPerfs map[string]map[string]float64, err error) {
+	var rawPerfs map[string]json.RawMessage
+	err = json.Unmarshal([]byte(perfAsString), &rawPerfs)
+	if err != nil {
+		return nil, err
+	}
+	dataPerfs = make(map[string]map[string]float64)
+	for dataKey, rawPerf := range rawPerfs {
+		perfs, err := parsePerfs(problem, string(rawPerf))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", dataKey, err)
+		}
+		dataPerfs[dataKey] = perfs
+	}
+	return dataPerfs, nil
+}
+
+// checkMetricNames checks that performances are given for all the metrics declared by a problem,
+// and only for them
+func checkMetricNames(problem Problem, perfs map[string]float64) error {
+	declared := make(map[string]bool)
+	var missing, unexpected []string
+	for _, metric := range problem.getMetrics() {
+		declared[metric.Name] = true
+		if _, ok := perfs[metric.Name]; !ok {
+			missing = append(missing, metric.Name)
+		}
+	}
+	for name := range perfs {
+		if !declared[name] {
+			unexpected = append(unexpected, name)
+		}
+	}
+	if len(missing) > 0 || len(unexpected) > 0 {
+		sort.Strings(unexpected)
+		return fmt.Errorf("missing metrics [%s], unexpected metrics [%s]",
+			strings.Join(missing, ", "), strings.Join(unexpected, ", "))
+	}
+	return nil
+}
+
+// primaryPerfs extracts the values of the primary metric from performances on each data
+func primaryPerfs(problem Problem, dataPerfs map[string]map[string]float64) map[string]float64 {
+	primaryMetric := problem.getPrimaryMetric()
+	perfs := make(map[string]float64)
+	for dataKey, metricPerfs := range dataPerfs {
+		perfs[dataKey] = metricPerfs[primaryMetric.Name]
+	}
+	return perfs
+}
+
+// checkPerfValue checks that a value of a metric is finite and within its declared range
+func (metric Metric) checkPerfValue(value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("%s is not finite", metric.Name)
+	}
+	if metric.Min != nil && value < *metric.Min {
+		return fmt.Errorf("%s value %g is lower than its minimum %g", metric.Name, value, *metric.Min)
+	}
+	if metric.Max != nil && value > *metric.Max {
+		return fmt.Errorf("%s value %g is greater than its maximum %g", metric.Name, value, *metric.Max)
+	}
+	return nil
+}
+
+// checkDataKeys compares the data keys of reported performances with the expected data keys,
+// and describes missing and unexpected keys
+func checkDataKeys(kind string, expected map[string]string, dataPerfs map[string]map[string]float64) []string {
+	var missing, unexpected []string
+	for dataKey := range expected {
+		if _, ok := dataPerfs[dataKey]; !ok {
+			missing = append(missing, dataKey)
+		}
+	}
+	for dataKey := range dataPerfs {
+		if _, ok := expected[dataKey]; !ok {
+			unexpected = append(unexpected, dataKey)
+		}
+	}
+	var problems []string
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		problems = append(problems, fmt.Sprintf("%s is missing [%s]", kind, strings.Join(missing, ", ")))
+	}
+	if len(unexpected) > 0 {
+		sort.Strings(unexpected)
+		problems = append(problems, fmt.Sprintf("%s has unexpected [%s]", kind, strings.Join(unexpected, ", ")))
+	}
+	return problems
+}
+
+// validateReportedPerfs checks the performances reported for a learnuplet: trainPerf and testPerf
+// must give performances for exactly the train and test data of the learnuplet, and all values
+// must be finite and within the range of their metric.
+// All problems found are listed in the returned error.
+func validateReportedPerfs(problem Problem, learnuplet Learnuplet, perfs map[string]float64,
+	trainPerfs map[string]map[string]float64, testPerfs map[string]map[string]float64) error {
+
+	problems := checkDataKeys("trainPerf", learnuplet.TrainData, trainPerfs)
+	problems = append(problems, checkDataKeys("testPerf", learnuplet.TestData, testPerfs)...)
+
+	checkValues := func(kind string, perfs map[string]float64) {
+		for _, metric := range problem.getMetrics() {
+			value, ok := perfs[metric.Name]
+			if !ok {
+				continue
+			}
+			if err := metric.checkPerfValue(value); err != nil {
+				problems = append(problems, kind+": "+err.Error())
+			}
+		}
+	}
+	checkValues("perf", perfs)
+	for _, dataPerfs := range []struct {
+		kind  string
+		perfs map[string]map[string]float64
+	}{{"trainPerf", trainPerfs}, {"testPerf", testPerfs}} {
+		dataKeys := make([]string, 0, len(dataPerfs.perfs))
+		for dataKey := range dataPerfs.perfs {
+			dataKeys = append(dataKeys, dataKey)
+		}
+		sort.Strings(dataKeys)
+		for _, dataKey := range dataKeys {
+			checkValues(dataPerfs.kind+" of "+dataKey, dataPerfs.perfs[dataKey])
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid performances: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProblemSettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		settings      string
+		valid         bool
+		primaryMetric string
+	}{
+		{"no settings", "", true, ""},
+		{"primary metric", `{"metrics": [{"name": "auc", "direction": "higher"}, {"name": "logloss", "direction": "lower"}], "primaryMetric": "logloss"}`, true, "logloss"},
+		{"default primary metric", `{"metrics": [{"name": "auc", "direction": "higher"}, {"name": "logloss", "direction": "lower"}]}`, true, "auc"},
+		{"undeclared primary metric", `{"metrics": [{"name": "auc", "direction": "higher"}], "primaryMetric": "logloss"}`, false, ""},
+		{"wrong direction", `{"metrics": [{"name": "auc", "direction": "up"}]}`, false, ""},
+		{"duplicated metric", `{"metrics": [{"name": "auc", "direction": "higher"}, {"name": "auc", "direction": "higher"}]}`, false, ""},
+		{"unknown setting", `{"metric": "auc"}`, false, ""},
+	}
+	for _, test := range tests {
+		settings, err := parseProblemSettings(test.settings)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: parseProblemSettings returned error %v", test.name, err)
+		}
+		if err == nil && settings.PrimaryMetric != test.primaryMetric {
+			t.Errorf("%s: primary metric is %s instead of %s", test.name, settings.PrimaryMetric, test.primaryMetric)
+		}
+	}
+}
+
+func TestParsePerfs(t *testing.T) {
+	problem := Problem{ProblemSettings: ProblemSettings{
+		Metrics:       []Metric{{Name: "auc", Direction: "higher"}, {Name: "logloss", Direction: "lower"}},
+		PrimaryMetric: "auc"}}
+	tests := []struct {
+		name  string
+		perf  string
+		perfs map[string]float64
+		valid bool
+	}{
+		{"all metrics", `{"auc": 0.8, "logloss": 0.4}`, map[string]float64{"auc": 0.8, "logloss": 0.4}, true},
+		{"missing metric", `{"auc": 0.8}`, nil, false},
+		{"unexpected metric", `{"auc": 0.8, "logloss": 0.4, "f1": 0.7}`, nil, false},
+		{"single number", `0.8`, nil, false},
+	}
+	for _, test := range tests {
+		perfs, err := parsePerfs(problem, test.perf)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: parsePerfs returned error %v", test.name, err)
+		}
+		if err == nil && !reflect.DeepEqual(perfs, test.perfs) {
+			t.Errorf("%s: parsePerfs returned %v instead of %v", test.name, perfs, test.perfs)
+		}
+	}
+	// problems without declared metrics accept a single number
+	perfs, err := parsePerfs(Problem{}, "0.8")
+	if err != nil || perfs[defaultMetric.Name] != 0.8 {
+		t.Errorf("parsePerfs of a single number fails")
+	}
+}
+
+func TestReportLearnMetrics(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+	settings := `{"metrics": [{"name": "auc", "direction": "higher"}, {"name": "logloss", "direction": "lower"}], "primaryMetric": "logloss"}`
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1", "", settings)
+	for _, address := range []string{"8fa81bfc-b5f4-4ba2-b81a-b46424800000",
+		"8fa81bfc-b5f4-4ba2-b81a-b46424800001"} {
+		smartContract.registerItem(mockStub, []string{"data", address, problemKey, ""})
+	}
+	smartContract.registerItem(mockStub, []string{"algo", "8fa81bfc-b5f4-4ba2-b81a-b464248f02d1", problemKey, "myalgo"})
+	algoKey := getKeys(t, mockStub, "algo")[0]
+	_, learnuplets, _ := getCompositeLearnuplet(mockStub, "algo", algoKey)
+	if len(learnuplets) == 0 {
+		t.Fatalf("No learnuplet created")
+	}
+	registerTestWorkers(t, smartContract, mockStub, "Arbeiter_12")
+	for _, learnuplet := range learnuplets {
+		assignTestWorker(t, smartContract, mockStub, learnuplet["key"].(string), "Arbeiter_12")
+	}
+	// done reports require performances
+	withoutPerf := smartContract.reportLearn(mockStub, []string{learnuplets[0]["key"].(string), "done", "", "", ""})
+	// report learnuplets, the one of rank 0 having the best logloss
+	responses := make(map[int]string)
+	for _, learnuplet := range learnuplets {
+		key := learnuplet["key"].(string)
+		rank := int(learnuplet["rank"].(float64))
+		perfs := []string{`{"auc": 0.7, "logloss": 0.3}`, `{"auc": 0.8, "logloss": 0.5}`}[rank]
+		dataPerfs := func(data interface{}) string {
+			var dataPerfs []string
+			for dataKey := range data.(map[string]interface{}) {
+				dataPerfs = append(dataPerfs, `"`+dataKey+`": `+perfs)
+			}
+			return "{" + strings.Join(dataPerfs, ", ") + "}"
+		}
+		response := smartContract.reportLearn(mockStub, []string{key, "done", perfs,
+			dataPerfs(learnuplet["trainData"]), dataPerfs(learnuplet["testData"])})
+		responses[rank] = response.Message
+	}
+	_, _, modelAddress, err := getRankAlgoLearnuplet(mockStub, algoKey)
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	if len(learnuplets) != 2 {
+		t.Fatalf("%d learnuplets created instead of 2", len(learnuplets))
+	}
+	if withoutPerf.GetStatus() == 200 {
+		t.Errorf("Learnuplet reported done without performances")
+	}
+	var bestModel string
+	for _, learnuplet := range learnuplets {
+		key := learnuplet["key"].(string)
+		stored := Learnuplet{}
+		learnupletAsBytes, _ := mockStub.GetState(key)
+		json.Unmarshal(learnupletAsBytes, &stored)
+		if stored.Status != "done" {
+			t.Fatalf("Report of learnuplet of rank %d fails - %s", stored.Rank, responses[stored.Rank])
+		}
+		// perf is the one of the primary metric
+		if stored.Perf != stored.Perfs["logloss"] || len(stored.Perfs) != 2 || len(stored.TrainPerfs) != 1 {
+			t.Errorf("Performances of learnuplet of rank %d not stored", stored.Rank)
+		}
+		if stored.Rank == 0 {
+			bestModel = stored.ModelEndAddress
+		}
+	}
+	// best model has the lowest logloss
+	if err != nil || modelAddress != bestModel {
+		t.Errorf("Best model is %s instead of %s", modelAddress, bestModel)
+	}
+}
+
+func TestValidateReportedPerfs(t *testing.T) {
+	zero, one := 0.0, 1.0
+	problem := Problem{ProblemSettings: ProblemSettings{
+		Metrics:       []Metric{{Name: "auc", Direction: "higher", Min: &zero, Max: &one}},
+		PrimaryMetric: "auc"}}
+	learnuplet := Learnuplet{
+		TrainData: map[string]string{"data_1": "address_1", "data_2": "address_2"},
+		TestData:  map[string]string{"data_3": "address_3"}}
+	tests := []struct {
+		name       string
+		perf       float64
+		trainPerfs map[string]map[string]float64
+		testPerfs  map[string]map[string]float64
+		errors     []string
+	}{
+		{"valid", 0.8,
+			map[string]map[string]float64{"data_1": {"auc": 0.7}, "data_2": {"auc": 0.9}},
+			map[string]map[string]float64{"data_3": {"auc": 0.8}},
+			nil},
+		{"missing and unexpected data", 0.8,
+			map[string]map[string]float64{"data_1": {"auc": 0.7}, "data_3": {"auc": 0.9}},
+			map[string]map[string]float64{},
+			[]string{"trainPerf is missing [data_2]", "trainPerf has unexpected [data_3]", "testPerf is missing [data_3]"}},
+		{"out of range", 1.2,
+			map[string]map[string]float64{"data_1": {"auc": -0.1}, "data_2": {"auc": 0.9}},
+			map[string]map[string]float64{"data_3": {"auc": 0.8}},
+			[]string{"perf: auc value 1.2 is greater than its maximum 1", "trainPerf of data_1: auc value -0.1 is lower than its minimum 0"}},
+		{"not finite", math.NaN(),
+			map[string]map[string]float64{"data_1": {"auc": 0.7}, "data_2": {"auc": 0.9}},
+			map[string]map[string]float64{"data_3": {"auc": 0.8}},
+			[]string{"perf: auc is not finite"}},
+	}
+	for _, test := range tests {
+		err := validateReportedPerfs(problem, learnuplet, map[string]float64{"auc": test.perf}, test.trainPerfs, test.testPerfs)
+		if len(test.errors) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", test.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: no error returned", test.name)
+			continue
+		}
+		for _, expected := range test.errors {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("%s: error %q does not contain %q", test.name, err, expected)
+			}
+		}
+	}
+}
+
+// testReport returns the arguments of reportLearn for a learnuplet: if it is done, its performance
+// and the ones on each of its data are 0.8 on the primary metric
+func testReport(t *testing.T, mockStub *testStub, learnupletKey string, status string) []string {
+	if status != "done" {
+		return []string{learnupletKey, status, "", "", ""}
+	}
+	learnuplet := Learnuplet{}
+	if err := json.Unmarshal(mockStub.State[learnupletKey], &learnuplet); err != nil {
+		t.Fatalf("No learnuplet with key %s - %s", learnupletKey, err)
+	}
+	dataPerfs := func(data map[string]string) string {
+		perfs := make(map[string]float64)
+		for dataKey := range data {
+			perfs[dataKey] = 0.8
+		}
+		perfsAsBytes, _ := json.Marshal(perfs)
+		return string(perfsAsBytes)
+	}
+	return []string{learnupletKey, status, "0.8", dataPerfs(learnuplet.TrainData), dataPerfs(learnuplet.TestData)}
+}
@@ -0,0 +1,245 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Actions of Init: initialise an empty ledger, migrate the ledger of a previous version of the chaincode,
+// or initialise an empty ledger with demo problems, algos and data
+const (
+	freshAction    = "fresh"
+	upgradeAction  = "upgrade"
+	seedDemoAction = "seed-demo"
+)
+
+// schemaKey is the key of the schema version of the ledger
+const schemaKey = "schema"
+
+// Schema structure, recording the version of the records of the ledger: the number of migrations applied
+// to them. Ledgers initialised before schemas were versioned have no schema, which is version 0.
+// UpgradedAt (RFC 3339) and TxID are the time and the transaction of the last initialisation or upgrade.
+type Schema struct {
+	Record
+	ObjectType string `json:"docType"`
+	Version    int    `json:"version"`
+	UpgradedAt string `json:"upgradedAt"`
+	TxID       string `json:"txID"`
+}
+
+// migration transforms the records of type objectType (their docType, their keys starting with objectType_)
+// from the previous schema version. migrate returns the new value of a record, or nil if it is unchanged.
+type migration struct {
+	description string
+	objectType  string
+	migrate     func(APIstub shim.ChaincodeStubInterface, value []byte) ([]byte, error)
+}
+
+// migrations is the registry of migrations, the schema version of the ledger being the number of migrations
+// applied. New migrations are appended, and never modified once released.
+var migrations = []migration{
+	{"problems registered without status are open", "problem", migrateProblemStatus},
+	{"done learnuplets without performances per metric have their performance on the primary metric",
+		"learnuplet", migrateLearnupletPerfs},
+	{"configurations stored before learnuplets were retried a limited number of times allow 3 retries",
+		"config", migrateConfigRetries},
+}
+
+// latestSchemaVersion returns the schema version of the ledger once all migrations are applied
+func latestSchemaVersion() int {
+	return len(migrations)
+}
+
+// migrateProblemStatus sets problems registered before the problem lifecycle open
+func migrateProblemStatus(APIstub shim.ChaincodeStubInterface, value []byte) ([]byte, error) {
+	problem := Problem{}
+	err := json.Unmarshal(value, &problem)
+	if err != nil || problem.Status != "" {
+		return nil, err
+	}
+	problem.Status = "open"
+	return json.Marshal(problem)
+}
+
+// migrateLearnupletPerfs sets the performances per metric of learnuplets reported done before
+// problems had several metrics
+func migrateLearnupletPerfs(APIstub shim.ChaincodeStubInterface, value []byte) ([]byte, error) {
+	learnuplet := Learnuplet{}
+	err := json.Unmarshal(value, &learnuplet)
+	if err != nil || learnuplet.Status != "done" || len(learnuplet.Perfs) > 0 {
+		return nil, err
+	}
+	// the problem is read as stored, its metrics being the same in all schema versions
+	problemAsBytes, err := APIstub.GetState(getProblemKey(learnuplet))
+	if err != nil {
+		return nil, err
+	}
+	problem := Problem{}
+	err = json.Unmarshal(problemAsBytes, &problem)
+	if err != nil {
+		return nil, fmt.Errorf("Problem Unmarshal problem - %s", err)
+	}
+	learnuplet.Perfs = map[string]float64{problem.getPrimaryMetric().Name: learnuplet.Perf}
+	return json.Marshal(learnuplet)
+}
+
+// migrateConfigRetries sets the number of retries of a timed out learnuplet in configurations stored
+// before it was a parameter
+func migrateConfigRetries(APIstub shim.ChaincodeStubInterface, value []byte) ([]byte, error) {
+	fields := make(map[string]json.RawMessage)
+	err := json.Unmarshal(value, &fields)
+	if _, ok := fields["maxRetries"]; err != nil || ok {
+		return nil, err
+	}
+	fields["maxRetries"] = json.RawMessage("3")
+	return json.Marshal(fields)
+}
+
+// getSchema returns the schema of the ledger, and false if it has none
+func getSchema(APIstub shim.ChaincodeStubInterface) (schema Schema, found bool, err error) {
+	value, err := APIstub.GetState(schemaKey)
+	if err != nil || value == nil {
+		return schema, false, err
+	}
+	err = json.Unmarshal(value, &schema)
+	if err != nil {
+		return schema, false, fmt.Errorf("Problem Unmarshal schema - %s", err)
+	}
+	return schema, true, nil
+}
+
+// storeSchema records the schema version of the ledger with the time of the transaction
+func storeSchema(APIstub shim.ChaincodeStubInterface, version int) error {
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	schema := Schema{ObjectType: "schema", Version: version, UpgradedAt: txTime.Format(time.RFC3339), TxID: APIstub.GetTxID()}
+	schemaAsBytes, err := marshalRecord(&schema)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(schemaKey, schemaAsBytes)
+}
+
+// initFresh initialises an empty ledger at the latest schema version.
+// Fails if the ledger was already initialised, or has problems.
+func initFresh(APIstub shim.ChaincodeStubInterface) error {
+	_, found, err := getSchema(APIstub)
+	if err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("ledger already initialised, it can only be upgraded")
+	}
+	problemIterator, err := APIstub.GetStateByRange("problem_", "problem_z")
+	if err != nil {
+		return err
+	}
+	defer problemIterator.Close()
+	if problemIterator.HasNext() {
+		return fmt.Errorf("ledger has problems, it can only be upgraded")
+	}
+	return storeSchema(APIstub, latestSchemaVersion())
+}
+
+// upgradeSchema records the latest schema version, without rewriting the records of the ledger: an upgrade
+// stays a small transaction whatever the size of the ledger. Records written with an older schema version
+// are upgraded when read (see upgradeRecord), and rewritten in chunks with migrateObjects.
+func upgradeSchema(APIstub shim.ChaincodeStubInterface) error {
+	schema, _, err := getSchema(APIstub)
+	if err != nil {
+		return err
+	}
+	if schema.Version > latestSchemaVersion() {
+		return fmt.Errorf("ledger schema version %d is newer than the chaincode one %d", schema.Version, latestSchemaVersion())
+	}
+	return storeSchema(APIstub, latestSchemaVersion())
+}
+
+// initAction initialises or upgrades the ledger, the action defaulting to upgrade so that instantiating
+// or upgrading the chaincode never clobbers the ledger. The other arguments are the admin organisations
+// and quorum of the configuration, used if none is stored yet (see initConfig).
+func initAction(APIstub shim.ChaincodeStubInterface, s *SmartContract, args []string) error {
+	action := upgradeAction
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+	var err error
+	switch action {
+	case freshAction:
+		err = initFresh(APIstub)
+	case seedDemoAction:
+		err = initFresh(APIstub)
+		if err == nil {
+			if response := s.initLedger(APIstub); response.Status != shim.OK {
+				err = errors.New(response.Message)
+			}
+		}
+	case upgradeAction:
+		err = upgradeSchema(APIstub)
+	default:
+		return fmt.Errorf("unknown action %s, expecting %s, %s or %s", action, freshAction, upgradeAction, seedDemoAction)
+	}
+	if err != nil {
+		return err
+	}
+	return initConfig(APIstub, args)
+}
+
+// querySchema is the smart contract to get the schema version of the ledger and of the chaincode
+// Args: none
+func (s *SmartContract) querySchema(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting none")
+	}
+	schema, _, err := getSchema(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	payload, err := json.Marshal(map[string]interface{}{"ledger": schema, "chaincode": latestSchemaVersion()})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestInitActions(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	initLedger := func(mockStub *testStub, args ...string) int32 {
+		byteArgs := [][]byte{[]byte("init")}
+		for _, arg := range args {
+			byteArgs = append(byteArgs, []byte(arg))
+		}
+		return mockStub.MockInit("mockTxID_init", byteArgs).Status
+	}
+	freshStub := newTestStub("fresh", smartContract)
+	demoStub := newTestStub("demo", smartContract)
+	defaultStub := newTestStub("default", smartContract)
+
+	// ACT
+	fresh := initLedger(freshStub, "fresh")
+	freshAgain := initLedger(freshStub, "fresh")
+	demoAfterFresh := initLedger(freshStub, "seed-demo")
+	unknown := initLedger(freshStub, "reset")
+	demo := initLedger(demoStub, "seed-demo")
+	upgraded := initLedger(demoStub)
+	byDefault := initLedger(defaultStub)
+	freshSchema, _, _ := getSchema(freshStub)
+	defaultSchema, _, _ := getSchema(defaultStub)
+
+	// ASSERT
+	if fresh != shim.OK || freshSchema.Version != latestSchemaVersion() || freshSchema.TxID != "mockTxID_init" {
+		t.Errorf("Ledger not initialised as expected: %+v", freshSchema)
+	}
+	if len(getKeys(t, freshStub, "problem")) != 0 || len(getKeys(t, defaultStub, "problem")) != 0 {
+		t.Errorf("Demo problems registered without being asked")
+	}
+	if freshAgain == shim.OK || demoAfterFresh == shim.OK || unknown == shim.OK {
+		t.Errorf("An initialised ledger should only be upgraded")
+	}
+	if demo != shim.OK || upgraded != shim.OK || len(getKeys(t, demoStub, "problem")) != 2 {
+		t.Errorf("Demo ledger not seeded as expected")
+	}
+	if byDefault != shim.OK || defaultSchema.Version != latestSchemaVersion() {
+		t.Errorf("Empty ledger not upgraded by default: %+v", defaultSchema)
+	}
+}
+
+func TestUpgradeSchema(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	put := func(key string, value interface{}) {
+		valueAsBytes, _ := json.Marshal(value)
+		mockStub.MockTransactionStart("mockTxID")
+		mockStub.PutState(key, valueAsBytes)
+		mockStub.MockTransactionEnd("mockTxID")
+	}
+	// records of a ledger written before problem lifecycles and several metrics
+	put("problem_1", map[string]interface{}{"docType": "problem", "storageAddress": "97d10b05-d37f-4b8e-b701-9ebe93fd2161",
+		"sizeTrainDataset": 1, "testData": []string{"data_0"}, "primaryMetric": "auc",
+		"metrics": []Metric{{Name: "auc", Direction: "higher"}}})
+	put("learnuplet_1", map[string]interface{}{"docType": "learnuplet", "status": "done", "perf": 0.8,
+		"problem": map[string]string{"problem_1": "97d10b05-d37f-4b8e-b701-9ebe93fd2161"}})
+	put("learnuplet_2", map[string]interface{}{"docType": "learnuplet", "status": "todo",
+		"problem": map[string]string{"problem_1": "97d10b05-d37f-4b8e-b701-9ebe93fd2161"}})
+
+	// ACT
+	upgraded := mockStub.MockInit("mockTxID_upgrade", [][]byte{[]byte("init"), []byte("upgrade")})
+	schema, _, _ := getSchema(mockStub)
+	// records are left as stored, and upgraded on read
+	stored := Learnuplet{}
+	json.Unmarshal(mockStub.State["learnuplet_1"], &stored)
+	problem, _ := getProblem(mockStub, "problem_1")
+	learnuplet, todo := Learnuplet{}, Learnuplet{}
+	unmarshalRecord(mockStub, mockStub.State["learnuplet_1"], &learnuplet)
+	unmarshalRecord(mockStub, mockStub.State["learnuplet_2"], &todo)
+	put("problem_2", map[string]interface{}{"docType": "problem"})
+	upgradedAgain := mockStub.MockInit("mockTxID_upgrade", [][]byte{[]byte("init"), []byte("upgrade")})
+	upgradedOnRead, _ := getProblem(mockStub, "problem_2")
+	put(schemaKey, Schema{ObjectType: "schema", Version: latestSchemaVersion() + 1})
+	downgraded := mockStub.MockInit("mockTxID_upgrade", [][]byte{[]byte("init"), []byte("upgrade")})
+	var versions struct {
+		Ledger    Schema `json:"ledger"`
+		Chaincode int    `json:"chaincode"`
+	}
+	json.Unmarshal(smartContract.querySchema(mockStub, nil).Payload, &versions)
+
+	// ASSERT
+	if upgraded.Status != shim.OK || upgradedAgain.Status != shim.OK {
+		t.Fatalf("Upgrade fails - %s%s", upgraded.Message, upgradedAgain.Message)
+	}
+	if schema.Version != latestSchemaVersion() || schema.TxID != "mockTxID_upgrade" {
+		t.Errorf("Schema version not recorded by the upgrade: %+v", schema)
+	}
+	if stored.SchemaVersion != 0 || len(stored.Perfs) != 0 {
+		t.Errorf("Records rewritten by the upgrade: %+v", stored)
+	}
+	if problem.Status != "open" || problem.PrimaryMetric != "auc" || problem.SizeTrainDataset != 1 {
+		t.Errorf("Problem not migrated as expected: %+v", problem)
+	}
+	if learnuplet.Perfs["auc"] != 0.8 || len(learnuplet.Perfs) != 1 || learnuplet.SchemaVersion != latestSchemaVersion() {
+		t.Errorf("Learnuplet not migrated as expected: %+v", learnuplet)
+	}
+	if todo.Status != "todo" || len(todo.Perfs) != 0 || todo.SchemaVersion != latestSchemaVersion() {
+		t.Errorf("Learnuplet not only stamped with the schema version: %+v", todo)
+	}
+	if upgradedOnRead.Status != "open" {
+		t.Errorf("Record written before the upgrade not upgraded on read: %+v", upgradedOnRead)
+	}
+	if downgraded.Status == shim.OK {
+		t.Errorf("Ledger with a newer schema should not be upgraded by an older chaincode")
+	}
+	if versions.Ledger.Version != latestSchemaVersion()+1 || versions.Chaincode != latestSchemaVersion() {
+		t.Errorf("Schema versions not returned as expected: %+v", versions)
+	}
+}
@@ -0,0 +1,280 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Chaining policies, defining from which model a learnuplet starts: the model of the previous
+// rank (continual learning), the best one on the primary metric, or the initial model for each
+// mini-batch (independent evaluation).
+const (
+	sequentialChainingPolicy = "sequential"
+	bestChainingPolicy       = "best"
+	restartChainingPolicy    = "restart"
+)
+
+// modelChain is the chain of learnuplets of an algo, each one starting from a model trained
+// by the learnuplets of lower rank.
+// initialModel is the model of the algo, from which the chain starts.
+// Learnuplets of a parallel chain are trained in rounds of parallel branches (see createParallelLearnuplets).
+type modelChain struct {
+	policy       string
+	parallel     bool
+	metric       Metric
+	initialModel string
+	learnuplets  []keyedLearnuplet
+}
+
+// validateChainingPolicy checks the chaining policy of problem settings, and sets the default one
+func validateChainingPolicy(settings *ProblemSettings) error {
+	switch settings.ChainingPolicy {
+	case "":
+		settings.ChainingPolicy = bestChainingPolicy
+	case sequentialChainingPolicy, bestChainingPolicy, restartChainingPolicy:
+	default:
+		return fmt.Errorf("chaining policy must be %s, %s or %s",
+			sequentialChainingPolicy, bestChainingPolicy, restartChainingPolicy)
+	}
+	return nil
+}
+
+// getChainingPolicy returns the chaining policy of a problem, best by default
+func (problem Problem) getChainingPolicy() string {
+	if problem.ChainingPolicy == "" {
+		return bestChainingPolicy
+	}
+	return problem.ChainingPolicy
+}
+
+// isIndependent returns true if the learnuplets of the problem all start from the initial model,
+// so that they do not depend on each other
+func (problem Problem) isIndependent() bool {
+	return problem.getChainingPolicy() == restartChainingPolicy
+}
+
+// newModelChain returns the chain of the learnuplets of an algo of a problem
+func newModelChain(problem Problem, initialModel string, learnuplets []keyedLearnuplet) *modelChain {
+	chain := &modelChain{
+		policy:       problem.getChainingPolicy(),
+		parallel:     problem.isParallel(),
+		metric:       problem.getPrimaryMetric(),
+		initialModel: initialModel,
+		learnuplets:  append([]keyedLearnuplet(nil), learnuplets...),
+	}
+	sort.Slice(chain.learnuplets, func(i, j int) bool {
+		a, b := chain.learnuplets[i], chain.learnuplets[j]
+		if a.Rank != b.Rank {
+			return a.Rank < b.Rank
+		}
+		return a.Key < b.Key
+	})
+	return chain
+}
+
+// update replaces learnuplets of the chain by their updated version, since updates
+// made in a transaction cannot be read back from the ledger in the same transaction
+func (chain *modelChain) update(updated ...keyedLearnuplet) {
+	for _, learnuplet := range updated {
+		for i := range chain.learnuplets {
+			if chain.learnuplets[i].Key == learnuplet.Key {
+				chain.learnuplets[i] = learnuplet
+			}
+		}
+	}
+}
+
+// isSettled returns true if the start model of learnuplets of a given rank will not change anymore:
+// all learnuplets of lower rank are finished, or learnuplets restart from the initial model
+func (chain *modelChain) isSettled(rank int) bool {
+	if chain.policy == restartChainingPolicy {
+		return true
+	}
+	for _, learnuplet := range chain.learnuplets {
+		if learnuplet.Rank < rank && !isFinished(learnuplet.Learnuplet) {
+			return false
+		}
+	}
+	return true
+}
+
+// startModel returns the model from which learnuplets of a given rank start, according to the
+// chaining policy. Only done learnuplets of lower rank are considered: learnuplets following failed
+// or cancelled ones start from the last or best model before them, or from the initial model.
+// When several models have the same best performance, the one of highest rank is chosen.
+func (chain *modelChain) startModel(rank int) string {
+	model := chain.initialModel
+	if chain.policy == restartChainingPolicy {
+		return model
+	}
+	// learnuplets of a parallel chain start from the last aggregated model
+	if chain.parallel {
+		for _, learnuplet := range chain.learnuplets {
+			if learnuplet.Rank < rank && learnuplet.Status == "done" && learnuplet.Type == "aggregation" {
+				model = learnuplet.ModelEndAddress
+			}
+		}
+		return model
+	}
+	found := false
+	var bestPerf float64
+	// learnuplets are sorted by rank: the last candidate is the latest one
+	for _, learnuplet := range chain.learnuplets {
+		if learnuplet.Rank >= rank || learnuplet.Status != "done" {
+			continue
+		}
+		if chain.policy == sequentialChainingPolicy || !found || !chain.metric.better(bestPerf, learnuplet.Perf) {
+			model = learnuplet.ModelEndAddress
+			bestPerf = learnuplet.Perf
+			found = true
+		}
+	}
+	return model
+}
+
+// lastRank returns the highest rank of the learnuplets of the chain which are not cancelled,
+// -1 if there is none
+func (chain *modelChain) lastRank() int {
+	rank := -1
+	for _, learnuplet := range chain.learnuplets {
+		if learnuplet.Status != "cancelled" && learnuplet.Rank > rank {
+			rank = learnuplet.Rank
+		}
+	}
+	return rank
+}
+
+// folds returns the sorted cross-validation folds of the learnuplets of the chain
+func (chain *modelChain) folds() []int {
+	var folds []int
+	seen := make(map[int]bool)
+	for _, learnuplet := range chain.learnuplets {
+		if !seen[learnuplet.Fold] {
+			seen[learnuplet.Fold] = true
+			folds = append(folds, learnuplet.Fold)
+		}
+	}
+	sort.Ints(folds)
+	return folds
+}
+
+// forFold returns the chain of the learnuplets of a cross-validation fold. Each fold of a cross-validated
+// problem is a distinct chain, from the same initial model.
+func (chain *modelChain) forFold(fold int) *modelChain {
+	foldChain := *chain
+	foldChain.learnuplets = nil
+	for _, learnuplet := range chain.learnuplets {
+		if learnuplet.Fold == fold {
+			foldChain.learnuplets = append(foldChain.learnuplets, learnuplet)
+		}
+	}
+	return &foldChain
+}
+
+// propagate sets the start model of every learnuplet waiting to be trained whose predecessors are
+// all finished, and the input models of aggregation learnuplets, in each fold of the chain.
+// It returns the learnuplets which changed.
+func (chain *modelChain) propagate() []keyedLearnuplet {
+	var changed []keyedLearnuplet
+	for _, fold := range chain.folds() {
+		foldChanged := chain.forFold(fold).propagateFold()
+		chain.update(foldChanged...)
+		changed = append(changed, foldChanged...)
+	}
+	return changed
+}
+
+// propagateFold propagates models in a chain of learnuplets of a single fold
+func (chain *modelChain) propagateFold() []keyedLearnuplet {
+	var changed []keyedLearnuplet
+	for i, learnuplet := range chain.learnuplets {
+		if learnuplet.Status != "todo" || !chain.isSettled(learnuplet.Rank) {
+			continue
+		}
+		model := chain.startModel(learnuplet.Rank)
+		if learnuplet.Type == "aggregation" {
+			inputs := chain.inputModels(learnuplet.Learnuplet)
+			if model != learnuplet.ModelStartAddress || !sameModels(inputs, learnuplet.InputModels) {
+				chain.learnuplets[i].ModelStartAddress = model
+				chain.learnuplets[i].InputModels = inputs
+				changed = append(changed, chain.learnuplets[i])
+			}
+		} else if model != learnuplet.ModelStartAddress {
+			chain.learnuplets[i].ModelStartAddress = model
+			changed = append(changed, chain.learnuplets[i])
+		}
+	}
+	return changed
+}
+
+// getModelChain returns the chain of learnuplets of an algo, with the given updated learnuplets
+func getModelChain(APIstub shim.ChaincodeStubInterface, problem Problem, algoKey string,
+	updated ...keyedLearnuplet) (*modelChain, error) {
+
+	learnuplets, err := getLearnupletsByIndex(APIstub, "algo", algoKey)
+	if err != nil {
+		return nil, err
+	}
+	initialModel := ""
+	if len(learnuplets) > 0 {
+		initialModel = learnuplets[0].Algo[algoKey]
+	}
+	chain := newModelChain(problem, initialModel, learnuplets)
+	chain.update(updated...)
+	return chain, nil
+}
+
+// propagateModel updates the start model of the learnuplets of an algo following
+// the given updated learnuplets, once they are done, failed or cancelled
+func propagateModel(APIstub shim.ChaincodeStubInterface, problem Problem, algoKey string,
+	updated ...keyedLearnuplet) error {
+
+	chain, err := getModelChain(APIstub, problem, algoKey, updated...)
+	if err != nil {
+		return err
+	}
+	for _, learnuplet := range chain.propagate() {
+		fmt.Printf("-- %s starts from model %s \n", learnuplet.Key, learnuplet.ModelStartAddress)
+		err = storeLearnuplet(APIstub, learnuplet.Key, learnuplet.Learnuplet)
+		if err != nil {
+			return fmt.Errorf("Problem storing learnuplet %s - %s", learnuplet.Key, err)
+		}
+	}
+	return nil
+}
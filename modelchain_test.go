@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// testChain returns learnuplets of ranks 0 to n-1 with the given statuses and perfs,
+// learnuplet of rank i producing model_i
+func testChain(statuses []string, perfs []float64) []keyedLearnuplet {
+	var learnuplets []keyedLearnuplet
+	for i, status := range statuses {
+		learnuplet := Learnuplet{
+			Status:          status,
+			Rank:            i,
+			Perf:            perfs[i],
+			ModelEndAddress: fmt.Sprintf("model_%d", i),
+		}
+		if i == 0 {
+			learnuplet.ModelStartAddress = "initial"
+		}
+		learnuplets = append(learnuplets, keyedLearnuplet{fmt.Sprintf("learnuplet_%d", i), learnuplet})
+	}
+	return learnuplets
+}
+
+func TestModelChainPropagate(t *testing.T) {
+	lower := ProblemSettings{Metrics: []Metric{{Name: "logloss", Direction: "lower"}}, PrimaryMetric: "logloss"}
+	tests := []struct {
+		name     string
+		settings ProblemSettings
+		statuses []string
+		perfs    []float64
+		// expected start models of learnuplets by key, after propagation
+		expected map[string]string
+	}{
+		{
+			name:     "next rank starts from the best model",
+			statuses: []string{"done", "done", "todo", "todo"},
+			perfs:    []float64{0.9, 0.7, 0, 0},
+			expected: map[string]string{"learnuplet_2": "model_0", "learnuplet_3": ""},
+		},
+		{
+			name:     "next rank starts from the model of the previous rank",
+			settings: ProblemSettings{ChainingPolicy: sequentialChainingPolicy},
+			statuses: []string{"done", "done", "todo", "todo"},
+			perfs:    []float64{0.9, 0.7, 0, 0},
+			expected: map[string]string{"learnuplet_2": "model_1", "learnuplet_3": ""},
+		},
+		{
+			name:     "best model for a metric to minimise",
+			settings: lower,
+			statuses: []string{"done", "done", "todo"},
+			perfs:    []float64{0.9, 0.7, 0},
+			expected: map[string]string{"learnuplet_2": "model_1"},
+		},
+		{
+			name:     "ties go to the latest model",
+			statuses: []string{"done", "done", "done", "todo"},
+			perfs:    []float64{0.8, 0.8, 0.6, 0},
+			expected: map[string]string{"learnuplet_3": "model_1"},
+		},
+		{
+			name:     "failed predecessor is skipped",
+			settings: ProblemSettings{ChainingPolicy: sequentialChainingPolicy},
+			statuses: []string{"done", "failed", "todo"},
+			perfs:    []float64{0.5, 0, 0},
+			expected: map[string]string{"learnuplet_2": "model_0"},
+		},
+		{
+			name:     "only failed and cancelled predecessors",
+			statuses: []string{"failed", "cancelled", "todo", "todo"},
+			perfs:    []float64{0, 0, 0, 0},
+			expected: map[string]string{"learnuplet_2": "initial", "learnuplet_3": ""},
+		},
+		{
+			name:     "every waiting successor of settled predecessors is updated",
+			statuses: []string{"done", "failed", "failed", "todo"},
+			perfs:    []float64{0.5, 0, 0, 0},
+			expected: map[string]string{"learnuplet_3": "model_0"},
+		},
+		{
+			name:     "successors wait for pending predecessors",
+			statuses: []string{"done", "pending", "todo"},
+			perfs:    []float64{0.5, 0, 0},
+			expected: map[string]string{"learnuplet_2": ""},
+		},
+		{
+			name:     "restart from the initial model without waiting",
+			settings: ProblemSettings{ChainingPolicy: restartChainingPolicy},
+			statuses: []string{"done", "pending", "todo", "todo"},
+			perfs:    []float64{0.9, 0, 0, 0},
+			expected: map[string]string{"learnuplet_2": "initial", "learnuplet_3": "initial"},
+		},
+		{
+			name:     "last rank has no successor",
+			statuses: []string{"done", "done"},
+			perfs:    []float64{0.5, 0.6},
+			expected: map[string]string{},
+		},
+	}
+	for _, test := range tests {
+		problem := Problem{ProblemSettings: test.settings}
+		chain := newModelChain(problem, "initial", testChain(test.statuses, test.perfs))
+		changed := chain.propagate()
+		nbChanged := 0
+		for key, model := range test.expected {
+			for _, learnuplet := range chain.learnuplets {
+				if learnuplet.Key == key && learnuplet.ModelStartAddress != model {
+					t.Errorf("%s: %s starts from %q instead of %q", test.name, key, learnuplet.ModelStartAddress, model)
+				}
+			}
+			if model != "" {
+				nbChanged++
+			}
+		}
+		if len(changed) != nbChanged {
+			t.Errorf("%s: %d learnuplets changed instead of %d", test.name, len(changed), nbChanged)
+		}
+	}
+}
+
+func TestValidateChainingPolicy(t *testing.T) {
+	tests := map[string]string{
+		"":           bestChainingPolicy,
+		"latest":     "",
+		"sequential": sequentialChainingPolicy,
+		"best":       bestChainingPolicy,
+		"restart":    restartChainingPolicy,
+		"random":     "",
+	}
+	for policy, expected := range tests {
+		settings := ProblemSettings{ChainingPolicy: policy}
+		err := validateChainingPolicy(&settings)
+		if expected == "" && err == nil {
+			t.Errorf("Chaining policy %q should be rejected", policy)
+		} else if expected != "" && (err != nil || settings.ChainingPolicy != expected) {
+			t.Errorf("Chaining policy %q is %q instead of %q - %v", policy, settings.ChainingPolicy, expected, err)
+		}
+	}
+}
+
+func TestRestartChainingPolicy(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1", "", `{"chainingPolicy": "restart"}`)
+	registerTestItems(t, smartContract, mockStub, problemKey, 2, "algo1")
+	mockStub.MockTransactionEnd(txId)
+	// data registered once learnuplets exist create independent learnuplets too
+	mockStub.MockTransactionStart(txId)
+	registerTestItems(t, smartContract, mockStub, problemKey, 1)
+	readyResponse := smartContract.queryReadyLearnuplets(mockStub, []string{problemKey})
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	algo := Item{}
+	json.Unmarshal(mockStub.State[getKeys(t, mockStub, "algo")[0]], &algo)
+	learnupletKeys := getKeys(t, mockStub, "learnuplet")
+	if len(learnupletKeys) != 3 {
+		t.Fatalf("%d learnuplets created instead of 3", len(learnupletKeys))
+	}
+	for _, key := range learnupletKeys {
+		learnuplet := Learnuplet{}
+		json.Unmarshal(mockStub.State[key], &learnuplet)
+		if learnuplet.ModelStartAddress != algo.StorageAddress {
+			t.Errorf("Learnuplet of rank %d starts from %q instead of the algo", learnuplet.Rank, learnuplet.ModelStartAddress)
+		}
+	}
+	var ready []readyLearnuplet
+	json.Unmarshal(readyResponse.GetPayload(), &ready)
+	if len(ready) != 3 {
+		t.Errorf("%d learnuplets ready instead of 3", len(ready))
+	}
+}
+
+func TestModelChainLastRank(t *testing.T) {
+	chain := newModelChain(Problem{}, "initial", testChain([]string{"done", "todo", "cancelled"}, []float64{0.5, 0, 0}))
+	if rank := chain.lastRank(); rank != 1 {
+		t.Errorf("Last rank is %d instead of 1", rank)
+	}
+	if chain.isSettled(2) || !chain.isSettled(1) {
+		t.Errorf("Rank 1 should be settled, not rank 2")
+	}
+	if rank := newModelChain(Problem{}, "initial", nil).lastRank(); rank != -1 {
+		t.Errorf("Last rank of an empty chain is %d instead of -1", rank)
+	}
+}
+
+func TestReportLearnPropagation(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	report := func(txId string, rank int, status string) {
+		mockStub.MockTransactionStart(txId)
+		defer mockStub.MockTransactionEnd(txId)
+		for _, key := range getKeys(t, mockStub, "learnuplet") {
+			learnuplet := Learnuplet{}
+			json.Unmarshal(mockStub.State[key], &learnuplet)
+			if learnuplet.Rank == rank {
+				assignTestWorker(t, smartContract, mockStub, key, "Arbeiter_12")
+				response := smartContract.reportLearn(mockStub, testReport(t, mockStub, key, status))
+				if response.GetStatus() != 200 {
+					t.Fatalf("reportLearn of rank %d fails - %s", rank, response.Message)
+				}
+			}
+		}
+	}
+	startModels := func() map[int]string {
+		models := make(map[int]string)
+		for _, key := range getKeys(t, mockStub, "learnuplet") {
+			learnuplet := Learnuplet{}
+			json.Unmarshal(mockStub.State[key], &learnuplet)
+			models[learnuplet.Rank] = learnuplet.ModelStartAddress
+		}
+		return models
+	}
+	modelEnd := func(rank int) string {
+		for _, key := range getKeys(t, mockStub, "learnuplet") {
+			learnuplet := Learnuplet{}
+			json.Unmarshal(mockStub.State[key], &learnuplet)
+			if learnuplet.Rank == rank {
+				return learnuplet.ModelEndAddress
+			}
+		}
+		return ""
+	}
+
+	// ACT
+	mockStub.MockTransactionStart("mockTxID")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1")
+	registerTestItems(t, smartContract, mockStub, problemKey, 3, "algo1")
+	registerTestWorkers(t, smartContract, mockStub, "Arbeiter_12")
+	mockStub.MockTransactionEnd("mockTxID")
+	initialModel := startModels()[0]
+	// rank 0 fails: rank 1 starts from the initial model
+	report("mockTxID_0", 0, "failed")
+	afterFailure := startModels()
+	report("mockTxID_1", 1, "done")
+	afterDone := startModels()
+	// reporting the last rank does not fail
+	report("mockTxID_2", 2, "done")
+
+	// ASSERT
+	if afterFailure[1] != initialModel || afterFailure[2] != "" {
+		t.Errorf("After failure of rank 0, start models are %v", afterFailure)
+	}
+	if afterDone[2] != modelEnd(1) {
+		t.Errorf("Rank 2 starts from %s instead of the model of rank 1", afterDone[2])
+	}
+}
@@ -40,8 +40,11 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
 	sc "github.com/hyperledger/fabric/protos/peer"
 	"github.com/satori/go.uuid"
 )
@@ -55,11 +58,28 @@ type SmartContract struct {
 // StorageAddress is for now the uuid of the problem on storage.
 // Name is the name of the item, defined by the owner, no unicity requirement.
 // Problem is the key of the problem on the orchestrator, such as problem_uuid.
+// Owner is the organisation (MSP ID) which registered the item.
+// Status belongs to [active, withdrawn, removed]. A withdrawn item is kept for audit, with
+// the reason and the transaction of its withdrawal, but is not used anymore. A removed data
+// was removed from the test data of its problem, and is not used by the problem anymore.
+// Metadata describes the item (description, tags, licence, ...).
+// Policy is the usage policy of a data, set by its owner (see UsagePolicy).
+// Data of private problems (see Privacy) have an empty StorageAddress: it is stored in the private data
+// Collection of the problem, and StorageHash is its SHA-256 hash.
 type Item struct {
-	ObjectType     string `json:"docType"`
-	StorageAddress string `json:"storageAddress"`
-	Name           string `json:"name"`
-	Problem        string `json:"problem"`
+	Record
+	ObjectType       string      `json:"docType"`
+	StorageAddress   string      `json:"storageAddress"`
+	Name             string      `json:"name"`
+	Problem          string      `json:"problem"`
+	Owner            string      `json:"owner"`
+	Status           string      `json:"status"`
+	WithdrawalReason string      `json:"withdrawalReason"`
+	WithdrawalTxID   string      `json:"withdrawalTxID"`
+	Metadata         Metadata    `json:"metadata"`
+	StorageHash      string      `json:"storageHash"`
+	Collection       string      `json:"collection"`
+	Policy           UsagePolicy `json:"policy"`
 }
 
 // Problem structure.
@@ -67,11 +87,52 @@ type Item struct {
 // StorageAddress is for now the uuid of the problem on storage.
 // SizeTrainDataset is the size of the batch for learning tasks.
 // TestData is the list of test data keys on the ledger.
+// Status belongs to [open, frozen, archived]: frozen problems do not generate
+// new learnuplets anymore, archived ones are closed and hidden from default queries.
+// Metadata describes the problem (description, tags, licence, ...).
+// ProblemSettings are optional settings given at registration.
+// Owner is the organisation (MSP ID) which registered the problem, and Escrow the key of the escrow
+// holding its bounty, if any.
 type Problem struct {
+	Record
 	ObjectType       string   `json:"docType"`
 	StorageAddress   string   `json:"storageAddress"`
 	SizeTrainDataset int      `json:"sizeTrainDataset"`
 	TestData         []string `json:"testData"`
+	Status           string   `json:"status"`
+	Metadata         Metadata `json:"metadata"`
+	Owner            string   `json:"owner"`
+	Escrow           string   `json:"escrow"`
+	ProblemSettings
+}
+
+// ProblemSettings structure, flattened in the Problem structure.
+// Metrics are the metrics on which models are evaluated, PrimaryMetric being the one used
+// to rank them. By default, models are evaluated on a single metric perf, higher being better.
+// Requirements are the minimal capabilities of the workers training learnuplets of the problem.
+// ChainingPolicy defines the model from which learnuplets start: sequential, best (default) or restart.
+// Branches is the number of mini-batches trained in parallel in each round, 0 or 1 for a single chain.
+// Federated problems are trained in Rounds at the data owners, each organisation training a learnuplet
+// on its data, and a round being closed once Quorum learnuplets are done (see Round).
+// Split is the strategy splitting data into train and test data: fixed (default), kfold or holdout.
+// Batching is the strategy grouping train data in mini-batches: sequential (default), shuffle or stratified.
+// Privacy is the private data collection holding data addresses and per-data performances, if any.
+// Rewards is the rule crediting the contributors of each done learnuplet (see RewardRule).
+// Bounty is the reward of the best algo, paid out when the problem is closed (see Bounty).
+type ProblemSettings struct {
+	Metrics        []Metric     `json:"metrics"`
+	PrimaryMetric  string       `json:"primaryMetric"`
+	Requirements   Capabilities `json:"requirements"`
+	ChainingPolicy string       `json:"chainingPolicy"`
+	Branches       int          `json:"branches"`
+	Federated      bool         `json:"federated"`
+	Rounds         int          `json:"rounds"`
+	Quorum         int          `json:"quorum"`
+	Split          Split        `json:"split"`
+	Batching       Batching     `json:"batching"`
+	Privacy        Privacy      `json:"privacy"`
+	Rewards        RewardRule   `json:"rewards"`
+	Bounty         Bounty       `json:"bounty"`
 }
 
 // Learnuplet structure.
@@ -82,25 +143,64 @@ type Problem struct {
 // from which to start the learning task and where to store output of the learning.
 // TrainData and TestData map the train and test data keys to their addresses
 // on Orchestrator.
-// Worker is the uuid of the Compute worker realizing the training task.
-// Status belongs to [todo, pending, failed, done].
+// Worker is the identifier of the Compute worker realizing the training task,
+// and AssignedAt the time it was assigned the learnuplet (RFC 3339).
+// Attempts is the number of times the learnuplet was assigned to a worker (see timeoutLearnuplet).
+// Status belongs to [todo, pending, failed, done, cancelled].
+// Type is train, or aggregation for learnuplets combining the models of parallel branches.
 // Rank defines the order in which learnuplets must be trained.
-// Perf is the performance on the test dataset.
-// TrainPerf and TestPerf map data keys to perf of the model on them
+// Round and Branch locate learnuplets of problems trained in parallel branches: in each round,
+// learnuplets of all branches start from the same model, and an aggregation learnuplet combines
+// their models. Dependencies are the keys of the learnuplets they wait for, and InputModels map
+// the keys of the learnuplets aggregated to their models.
+// Fold is the cross-validation fold of the learnuplet, each fold being a distinct chain, 0 if the problem
+// is not cross-validated.
+// BatchAssignment is the key of the record of the grouping of train data in mini-batches (see BatchAssignment),
+// and Batch the index of the mini-batch of the learnuplet in it.
+// Organisation, set for federated problems, is the only organisation whose workers can train the learnuplet.
+// Perf is the performance on the test dataset for the primary metric of the problem.
+// TrainPerf and TestPerf map data keys to perf of the model on them for the primary metric.
+// Perfs, TrainPerfs and TestPerfs hold the same performances for all metrics of the problem.
+// For private problems, Collection is the private data collection of the problem: data addresses are
+// empty, and TrainPerf, TestPerf, TrainPerfs and TestPerfs are stored in the collection once reported,
+// PrivateHash being the SHA-256 hash of the private record.
 type Learnuplet struct {
-	ObjectType        string             `json:"docType"`
-	Problem           map[string]string  `json:"problem"`
-	Algo              map[string]string  `json:"algo"`
-	ModelStartAddress string             `json:"modelStartAddress"`
-	ModelEndAddress   string             `json:"modelEndAddress"`
-	TrainData         map[string]string  `json:"trainData"`
-	TestData          map[string]string  `json:"testData"`
-	Worker            string             `json:"worker"`
-	Status            string             `json:"status"`
-	Rank              int                `json:"rank"`
-	Perf              float64            `json:"perf"`
-	TrainPerf         map[string]float64 `json:"trainPerf"`
-	TestPerf          map[string]float64 `json:"testPerf"`
+	Record
+	ObjectType        string                        `json:"docType"`
+	Type              string                        `json:"type"`
+	Problem           map[string]string             `json:"problem"`
+	Algo              map[string]string             `json:"algo"`
+	ModelStartAddress string                        `json:"modelStartAddress"`
+	ModelEndAddress   string                        `json:"modelEndAddress"`
+	TrainData         map[string]string             `json:"trainData"`
+	TestData          map[string]string             `json:"testData"`
+	Worker            string                        `json:"worker"`
+	AssignedAt        string                        `json:"assignedAt"`
+	Attempts          int                           `json:"attempts"`
+	Status            string                        `json:"status"`
+	Rank              int                           `json:"rank"`
+	Round             int                           `json:"round"`
+	Branch            int                           `json:"branch"`
+	Fold              int                           `json:"fold"`
+	BatchAssignment   string                        `json:"batchAssignment"`
+	Batch             int                           `json:"batch"`
+	Dependencies      []string                      `json:"dependencies"`
+	InputModels       map[string]string             `json:"inputModels"`
+	Organisation      string                        `json:"organisation"`
+	Perf              float64                       `json:"perf"`
+	TrainPerf         map[string]float64            `json:"trainPerf"`
+	TestPerf          map[string]float64            `json:"testPerf"`
+	Perfs             map[string]float64            `json:"perfs"`
+	TrainPerfs        map[string]map[string]float64 `json:"trainPerfs"`
+	TestPerfs         map[string]map[string]float64 `json:"testPerfs"`
+	Collection        string                        `json:"collection"`
+	PrivateHash       string                        `json:"privateHash"`
+}
+
+// keyedLearnuplet is a learnuplet with its key on the ledger
+type keyedLearnuplet struct {
+	Key string `json:"key"`
+	Learnuplet
 }
 
 // ErrorUplet structure
@@ -117,9 +217,17 @@ func (e *errorUplet) Error() string {
 // Note that chaincode upgrade also calls this function to reset
 // or to migrate data, so be careful to avoid a scenario where you
 // inadvertently clobber your ledger's data!
-// Best practice is to have any Ledger initialization in separate function -- see initLedger()
+// The ledger is only populated with demo data when asked (seed-demo), and migrated otherwise (see initAction).
+// Args (0 to 3 strings): optionally action (fresh, upgrade or seed-demo, upgrade by default),
+// admin organisations (OrgA, OrgB, ...) and quorum of the configuration
 func (s *SmartContract) Init(APIstub shim.ChaincodeStubInterface) sc.Response {
-	s.initLedger(APIstub)
+	_, args := APIstub.GetFunctionAndParameters()
+	fmt.Printf("- start init %v \n", args)
+	err := initAction(APIstub, s, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Println("- end init")
 	return shim.Success(nil)
 }
 
@@ -138,14 +246,76 @@ func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) sc.Response
 		return s.queryProblemItems(APIstub, args)
 	} else if function == "registerItem" {
 		return s.registerItem(APIstub, args)
+	} else if function == "withdrawItem" {
+		return s.withdrawItem(APIstub, args)
+	} else if function == "updateMetadata" {
+		return s.updateMetadata(APIstub, args)
+	} else if function == "setDataPolicy" {
+		return s.setDataPolicy(APIstub, args)
+	} else if function == "queryDataUsage" {
+		return s.queryDataUsage(APIstub, args)
+	} else if function == "queryByTag" {
+		return s.queryByTag(APIstub, args)
 	} else if function == "registerProblem" {
 		return s.registerProblem(APIstub, args)
+	} else if function == "updateProblem" {
+		return s.updateProblem(APIstub, args)
+	} else if function == "setProblemStatus" {
+		return s.setProblemStatus(APIstub, args)
+	} else if function == "closeProblem" {
+		return s.closeProblem(APIstub, args)
 	} else if function == "queryStatusLearnuplet" {
 		return s.queryStatusLearnuplet(APIstub, args)
 	} else if function == "queryAlgoLearnuplet" {
 		return s.queryAlgoLearnuplet(APIstub, args)
+	} else if function == "queryReadyLearnuplets" {
+		return s.queryReadyLearnuplets(APIstub, args)
 	} else if function == "setUpletWorker" {
 		return s.setUpletWorker(APIstub, args)
+	} else if function == "claimNextLearnuplet" {
+		return s.claimNextLearnuplet(APIstub, args)
+	} else if function == "registerWorker" {
+		return s.registerWorker(APIstub, args)
+	} else if function == "updateWorker" {
+		return s.updateWorker(APIstub, args)
+	} else if function == "queryWorkers" {
+		return s.queryWorkers(APIstub, args)
+	} else if function == "timeoutLearnuplet" {
+		return s.timeoutLearnuplet(APIstub, args)
+	} else if function == "queryWorkerStats" {
+		return s.queryWorkerStats(APIstub, args)
+	} else if function == "closeRound" {
+		return s.closeRound(APIstub, args)
+	} else if function == "queryRounds" {
+		return s.queryRounds(APIstub, args)
+	} else if function == "verifyBatches" {
+		return s.verifyBatches(APIstub, args)
+	} else if function == "queryBalance" {
+		return s.queryBalance(APIstub, args)
+	} else if function == "queryContributions" {
+		return s.queryContributions(APIstub, args)
+	} else if function == "queryDataValue" {
+		return s.queryDataValue(APIstub, args)
+	} else if function == "querySchema" {
+		return s.querySchema(APIstub, args)
+	} else if function == "migrateObjects" {
+		return s.migrateObjects(APIstub, args)
+	} else if function == "exportState" {
+		return s.exportState(APIstub, args)
+	} else if function == "importState" {
+		return s.importState(APIstub, args)
+	} else if function == "checkConsistency" {
+		return s.checkConsistency(APIstub, args)
+	} else if function == "repairIndexes" {
+		return s.repairIndexes(APIstub, args)
+	} else if function == "queryConfig" {
+		return s.queryConfig(APIstub, args)
+	} else if function == "proposeConfigChange" {
+		return s.proposeConfigChange(APIstub, args)
+	} else if function == "voteConfigChange" {
+		return s.voteConfigChange(APIstub, args)
+	} else if function == "queryLeaderboard" {
+		return s.queryLeaderboard(APIstub, args)
 	} else if function == "reportLearn" {
 		return s.reportLearn(APIstub, args)
 	}
@@ -157,20 +327,18 @@ func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) sc.Response
 // initLedger
 // ============================================
 
-// initLedger populates the database.
-// TODO only for test purposes for now. This should be totally modified once
-// tests are done
+// initLedger populates an empty ledger with demo problems, algos and data, for the seed-demo action of Init
 func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface) sc.Response {
 
 	fmt.Println("- start Populate Ledger")
 
 	// Adding two problems
 	problems := []Problem{
-		Problem{ObjectType: "problem", StorageAddress: "97d10b05-d37f-4b8e-b701-9ebe93fd2161", SizeTrainDataset: 1, TestData: []string{"data_0"}},
-		Problem{ObjectType: "problem", StorageAddress: "3fbfe8d5-bfa9-4924-90e2-b11a89faf735", SizeTrainDataset: 2, TestData: []string{"data_0"}},
+		Problem{ObjectType: "problem", StorageAddress: "97d10b05-d37f-4b8e-b701-9ebe93fd2161", SizeTrainDataset: 1, TestData: []string{"data_0"}, Status: "open"},
+		Problem{ObjectType: "problem", StorageAddress: "3fbfe8d5-bfa9-4924-90e2-b11a89faf735", SizeTrainDataset: 2, TestData: []string{"data_0"}, Status: "open"},
 	}
 	for i, problem := range problems {
-		problemAsBytes, _ := json.Marshal(problem)
+		problemAsBytes, _ := marshalRecord(&problem)
 		problemKey := fmt.Sprintf("problem_%d", i)
 		APIstub.PutState(problemKey, problemAsBytes)
 		fmt.Println("-- added", problem)
@@ -183,7 +351,7 @@ func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface) sc.Respo
 	}
 
 	for i, algo := range algos {
-		algoAsBytes, _ := json.Marshal(algo)
+		algoAsBytes, _ := marshalRecord(&algo)
 		algoKey := fmt.Sprintf("algo_%d", i)
 		APIstub.PutState(algoKey, algoAsBytes)
 		fmt.Println("-- added", algo)
@@ -207,7 +375,7 @@ func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface) sc.Respo
 		Item{ObjectType: "data", StorageAddress: "92m81bfc-b5f4-4ba2-b81a-b464248f02d1", Problem: "problem_1", Name: ""},
 	}
 	for i, data := range datas {
-		dataAsBytes, _ := json.Marshal(data)
+		dataAsBytes, _ := marshalRecord(&data)
 		dataKey := fmt.Sprintf("data_%d", i)
 		APIstub.PutState(dataKey, dataAsBytes)
 		fmt.Println("-- added", data)
@@ -233,40 +401,98 @@ func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface) sc.Respo
 
 // registerProblem is the smart contract to register a problem and associated test data
 // Should be callable only by administrators
-// Args (3 strings): storageAddress, sizeTrainDataset, testDataAddresses (addressData0, addressData1, ...)
+// Args (3 to 5 strings): storageAddress, sizeTrainDataset (empty for the default one of the configuration),
+// testDataAddresses (addressData0, addressData1, ...),
+// optionally metadata ("{\"description\": \"...\", \"tags\": [\"image\"], ...}"),
+// optionally settings ("{\"metrics\": [{\"name\": \"auc\", \"direction\": \"higher\"}], \"primaryMetric\": \"auc\"}")
+// For private problems, testDataAddresses must be empty and given in the transient map instead.
 func (s *SmartContract) registerProblem(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
 
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3: storageAddress, sizeTrainDataset, testDataAdresses (addressData0, addressData1, ...)")
+	if len(args) < 3 || len(args) > 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 3 to 5: storageAddress, sizeTrainDataset, testDataAdresses (addressData0, addressData1, ...), optionally metadata and settings")
 	}
-	//       0          		1		 	         2
-	// "storageAddress", "sizeTrainDataset", "testDataAddresses"
+	//       0          		1		 	         2					3			4
+	// "storageAddress", "sizeTrainDataset", "testDataAddresses", "metadata", "settings"
 
-	fmt.Println("- start create problem \n")
+	fmt.Println("- start create problem")
 
 	// Clean input data
-	sizeTrainDataset, err := strconv.Atoi(args[1])
+	config, err := getConfig(APIstub)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	testDataAddress := strings.Split(strings.Replace(args[2], " ", "", -1), ",")
+	sizeTrainDataset := config.DefaultSizeTrainDataset
+	if args[1] != "" {
+		sizeTrainDataset, err = strconv.Atoi(args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if sizeTrainDataset <= 0 {
+			return shim.Error("sizeTrainDataset must be strictly positive")
+		}
+	} else if sizeTrainDataset == 0 {
+		return shim.Error("sizeTrainDataset must be given, the configuration has no default one")
+	}
+	var metadata Metadata
+	if len(args) >= 4 {
+		metadata, err = newMetadata(APIstub, args[3])
+	} else {
+		metadata, err = newMetadata(APIstub, "")
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var settings ProblemSettings
+	if len(args) == 5 {
+		settings, err = parseProblemSettings(args[4])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	// the split of the data is derived from the transaction, so that anyone can check it
+	settings.Split.Seed = APIstub.GetTxID()
+	// addresses of test data of private problems are not recorded in the transaction
+	testDataAddresses := args[2]
+	if settings.Privacy.Collection != "" {
+		testDataAddresses, err = getPrivateArgument(APIstub, "testDataAddresses", args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if testDataAddresses == "" {
+			return shim.Error("testDataAddresses of a private problem are missing from the transient map")
+		}
+	}
+	testDataAddress := strings.Split(strings.Replace(testDataAddresses, " ", "", -1), ",")
 
 	// Create Problem Key
 	problemKey := "problem_" + uuid.NewV4().String()
+	owner, err := getCallerOrg(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// Hold the bounty in escrow
+	escrowKey := ""
+	if settings.Bounty.Amount > 0 {
+		escrowKey, err = createEscrow(APIstub, problemKey, owner, settings.Bounty)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
 	// Store test data
-	testData, err := registerTestData(APIstub, problemKey, testDataAddress)
+	testData, err := registerTestData(APIstub, problemKey, testDataAddress, settings.Privacy.Collection)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
 	// Store Problem
-	var problem = Problem{ObjectType: "problem", StorageAddress: args[0], SizeTrainDataset: sizeTrainDataset, TestData: testData}
-	problemAsBytes, err := json.Marshal(problem)
+	var problem = Problem{ObjectType: "problem", StorageAddress: args[0], SizeTrainDataset: sizeTrainDataset,
+		TestData: testData, Status: "open", Metadata: metadata, Owner: owner, Escrow: escrowKey, ProblemSettings: settings}
+	err = storeProblem(APIstub, problemKey, problem)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	err = APIstub.PutState(problemKey, problemAsBytes)
+	err = indexTags(APIstub, problem.ObjectType, problemKey, nil, metadata.Tags)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -274,18 +500,89 @@ func (s *SmartContract) registerProblem(APIstub shim.ChaincodeStubInterface, arg
 	return shim.Success(nil)
 }
 
+// parseProblemSettings decodes and validates the settings of a problem given as a JSON string
+func parseProblemSettings(settingsAsString string) (settings ProblemSettings, err error) {
+	if strings.TrimSpace(settingsAsString) == "" {
+		return settings, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(settingsAsString))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&settings)
+	if err != nil {
+		return settings, fmt.Errorf("invalid problem settings - %s", err)
+	}
+	err = validateMetrics(&settings)
+	if err != nil {
+		return settings, fmt.Errorf("invalid problem settings - %s", err)
+	}
+	err = settings.Requirements.validate()
+	if err != nil {
+		return settings, fmt.Errorf("invalid problem settings - requirements - %s", err)
+	}
+	err = validateBranches(&settings)
+	if err != nil {
+		return settings, fmt.Errorf("invalid problem settings - %s", err)
+	}
+	err = validateFederation(&settings)
+	if err != nil {
+		return settings, fmt.Errorf("invalid problem settings - %s", err)
+	}
+	err = validateSplit(&settings)
+	if err != nil {
+		return settings, fmt.Errorf("invalid problem settings - split - %s", err)
+	}
+	err = validateBatching(&settings)
+	if err != nil {
+		return settings, fmt.Errorf("invalid problem settings - batching - %s", err)
+	}
+	err = validateChainingPolicy(&settings)
+	if err != nil {
+		return settings, fmt.Errorf("invalid problem settings - %s", err)
+	}
+	err = validatePrivacy(&settings)
+	if err != nil {
+		return settings, fmt.Errorf("invalid problem settings - privacy - %s", err)
+	}
+	err = validateRewards(&settings)
+	if err != nil {
+		return settings, fmt.Errorf("invalid problem settings - %s", err)
+	}
+	err = validateBounty(&settings)
+	if err != nil {
+		return settings, fmt.Errorf("invalid problem settings - bounty - %s", err)
+	}
+	return settings, nil
+}
+
 // registerTestData stores in the orchestrator new test data
-// given their addresses and Storage and their associated problem
+// given their addresses and Storage and their associated problem.
+// Addresses are stored in the private data collection of the problem, if any.
 func registerTestData(APIstub shim.ChaincodeStubInterface, problemKey string,
-	testDataAddress []string) (testData []string, err error) {
+	testDataAddress []string, collection string) (testData []string, err error) {
 
+	owner, err := getCallerOrg(APIstub)
+	if err != nil {
+		return testData, err
+	}
+	metadata, err := newMetadata(APIstub, "")
+	if err != nil {
+		return testData, err
+	}
 	for _, sdata := range testDataAddress {
 		// remove leading and trailing space and split address and owner
 		sdata = strings.TrimSpace(sdata)
 		// create data key
 		dataKey := "data_" + uuid.NewV4().String()
 		// store data
-		_, err = storeItem(APIstub, dataKey, "data", sdata, problemKey, "")
+		item := Item{ObjectType: "data", StorageAddress: sdata, Problem: problemKey, Owner: owner,
+			Status: "active", Metadata: metadata}
+		if collection != "" {
+			err = storePrivateItem(APIstub, collection, dataKey, &item)
+			if err != nil {
+				return testData, err
+			}
+		}
+		err = storeItem(APIstub, dataKey, item)
 		if err != nil {
 			return testData, err
 		}
@@ -296,24 +593,297 @@ func registerTestData(APIstub shim.ChaincodeStubInterface, problemKey string,
 	return testData, err
 }
 
+// getProblem retrieves a problem from the ledger given its key
+func getProblem(APIstub shim.ChaincodeStubInterface, problemKey string) (problem Problem, err error) {
+	value, err := APIstub.GetState(problemKey)
+	if err != nil {
+		return problem, err
+	}
+	if value == nil {
+		return problem, fmt.Errorf("no problem with key %s", problemKey)
+	}
+	err = unmarshalRecord(APIstub, value, &problem)
+	return problem, err
+}
+
+// checkProblemOwner checks that the submitter of the transaction is the owner of a problem.
+// Problems registered before their owner was recorded can only be managed by admin organisations.
+func checkProblemOwner(APIstub shim.ChaincodeStubInterface, problemKey string, problem Problem) error {
+	if problem.Owner == "" {
+		_, _, err := getAdminConfig(APIstub)
+		return err
+	}
+	caller, err := getCallerOrg(APIstub)
+	if err != nil {
+		return err
+	}
+	if caller != problem.Owner {
+		return fmt.Errorf("problem %s can only be managed by %s", problemKey, problem.Owner)
+	}
+	return nil
+}
+
+// storeProblem stores an updated problem in the ledger
+func storeProblem(APIstub shim.ChaincodeStubInterface, problemKey string, problem Problem) error {
+	problemAsBytes, err := marshalRecord(&problem)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(problemKey, problemAsBytes)
+}
+
+// =====================================================================================
+// 								Problem update
+// =====================================================================================
+
+// updateProblem is the smart contract to update the batch size and the test data of a problem.
+// Added test data are registered as for registerProblem. Removed test data are kept on the
+// ledger, but are not associated with the problem anymore.
+// Learnuplets which have not been started yet (status todo) are re-evaluated on the new test data.
+// Only callable by the owner of the problem (see checkProblemOwner)
+// Args (4 strings): problemKey, sizeTrainDataset, testDataAddresses to add (addressData0, ...),
+// testDataKeys to remove (data_0, ...). Empty strings leave the corresponding field unchanged.
+// For private problems, testDataAddresses to add must be empty and given in the transient map instead.
+func (s *SmartContract) updateProblem(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4: problemKey, sizeTrainDataset, testDataAddresses to add, testDataKeys to remove")
+	}
+	//       0          		1		 	         2						3
+	// "problemKey", "sizeTrainDataset", "testDataAddresses", "testDataKeys"
+
+	problemKey := args[0]
+	fmt.Printf("- start update problem %s \n", problemKey)
+
+	problem, err := getProblem(APIstub, problemKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = checkProblemOwner(APIstub, problemKey, problem); err != nil {
+		return shim.Error(err.Error())
+	}
+	if problem.Status == "archived" {
+		return shim.Error("Problem " + problemKey + " is archived and cannot be updated")
+	}
+
+	// Update size of the mini-batches, only used for learnuplets created from now on
+	if args[1] != "" {
+		sizeTrainDataset, err := strconv.Atoi(args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if sizeTrainDataset <= 0 {
+			return shim.Error("sizeTrainDataset must be strictly positive")
+		}
+		problem.SizeTrainDataset = sizeTrainDataset
+	}
+
+	// Remove test data
+	testDataChanged := false
+	if args[3] != "" {
+		removedData := strings.Split(strings.Replace(args[3], " ", "", -1), ",")
+		var unknownData []string
+		for _, dataKey := range removedData {
+			found := false
+			for i, testDataKey := range problem.TestData {
+				if testDataKey == dataKey {
+					problem.TestData = append(problem.TestData[:i], problem.TestData[i+1:]...)
+					found = true
+					break
+				}
+			}
+			if !found {
+				unknownData = append(unknownData, dataKey)
+				continue
+			}
+			// the removed data is not used by the problem anymore, neither for test nor for train
+			dataProblemIndexKey, err := APIstub.CreateCompositeKey("data~problem~key", []string{"data", problemKey, dataKey})
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			err = APIstub.DelState(dataProblemIndexKey)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			err = markDataRemoved(APIstub, dataKey)
+			if err != nil {
+				return shim.Error("Problem removing test data " + dataKey + " - " + err.Error())
+			}
+		}
+		if len(unknownData) > 0 {
+			return shim.Error("Not test data of " + problemKey + ": " + strings.Join(unknownData, ", "))
+		}
+		testDataChanged = true
+	}
+
+	// Add test data
+	testDataAddresses := args[2]
+	if problem.isPrivate() {
+		testDataAddresses, err = getPrivateArgument(APIstub, "testDataAddresses", args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if testDataAddresses != "" {
+		testDataAddress := strings.Split(strings.Replace(testDataAddresses, " ", "", -1), ",")
+		testData, err := registerTestData(APIstub, problemKey, testDataAddress, problem.Privacy.Collection)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		problem.TestData = append(problem.TestData, testData...)
+		testDataChanged = true
+	}
+
+	err = storeProblem(APIstub, problemKey, problem)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Re-evaluation: learnuplets not started yet are evaluated on the new test data,
+	// unless they are evaluated on their cross-validation fold
+	if testDataChanged && !problem.isCrossValidated() {
+		nbUpdated, err := refreshLearnupletTestData(APIstub, problemKey, problem.TestData)
+		if err != nil {
+			return shim.Error("Problem updating test data of learnuplets - " + err.Error())
+		}
+		fmt.Printf("-- test data of %d learnuplets updated \n", nbUpdated)
+	}
+
+	fmt.Printf("- end update problem %s \n", problemKey)
+	return shim.Success(nil)
+}
+
+// markDataRemoved marks a data removed from the test data of its problem
+func markDataRemoved(APIstub shim.ChaincodeStubInterface, dataKey string) error {
+	data, err := getItem(APIstub, dataKey)
+	if err != nil {
+		return err
+	}
+	data.Status = "removed"
+	dataAsBytes, err := marshalRecord(&data)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(dataKey, dataAsBytes)
+}
+
+// refreshLearnupletTestData sets the test data of all learnuplets of a problem
+// which have not been started yet (status todo).
+// It returns the number of updated learnuplets.
+func refreshLearnupletTestData(APIstub shim.ChaincodeStubInterface, problemKey string,
+	testData []string) (nbUpdated int, err error) {
+
+	mapTestData, err := getDataAddress(APIstub, testData)
+	if err != nil {
+		return nbUpdated, err
+	}
+	algoKeys, err := getProblemItems(APIstub, problemKey, "algo")
+	if err != nil {
+		return nbUpdated, err
+	}
+	for _, algoKey := range algoKeys {
+		_, learnuplets, err := getCompositeLearnuplet(APIstub, "algo", algoKey)
+		if err != nil {
+			return nbUpdated, err
+		}
+		for _, learnuplet := range learnuplets {
+			if status, _ := learnuplet["status"].(string); status != "todo" {
+				continue
+			}
+			learnupletKey, _ := learnuplet["key"].(string)
+			value, err := APIstub.GetState(learnupletKey)
+			if err != nil {
+				return nbUpdated, err
+			}
+			retrievedLearnuplet := Learnuplet{}
+			err = unmarshalRecord(APIstub, value, &retrievedLearnuplet)
+			if err != nil {
+				return nbUpdated, err
+			}
+			retrievedLearnuplet.TestData = mapTestData
+			retrievedLearnuplet.TestPerf = make(map[string]float64)
+			retrievedLearnuplet.TestPerfs = make(map[string]map[string]float64)
+			err = storeLearnuplet(APIstub, learnupletKey, retrievedLearnuplet)
+			if err != nil {
+				return nbUpdated, err
+			}
+			nbUpdated++
+		}
+	}
+	return nbUpdated, nil
+}
+
+// setProblemStatus is the smart contract to open or freeze a problem.
+// Frozen problems accept new data and algos, but do not generate new learnuplets.
+// Problems are archived with closeProblem, which pays out their bounty.
+// Only callable by the owner of the problem (see checkProblemOwner)
+// Args (2 strings): problemKey, status (open or frozen)
+func (s *SmartContract) setProblemStatus(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2: problemKey, status (open, frozen)")
+	}
+
+	problemKey := args[0]
+	status := args[1]
+	fmt.Printf("- start set status %s for %s \n", status, problemKey)
+
+	if status == "archived" {
+		return shim.Error("Problems are archived with closeProblem")
+	}
+	if status != "open" && status != "frozen" {
+		return shim.Error("Invalid problem status " + status + ". Expecting open or frozen")
+	}
+	problem, err := getProblem(APIstub, problemKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = checkProblemOwner(APIstub, problemKey, problem); err != nil {
+		return shim.Error(err.Error())
+	}
+	if problem.Status == "archived" {
+		return shim.Error("Problem " + problemKey + " is archived, its status cannot be changed")
+	}
+	problem.Status = status
+	err = storeProblem(APIstub, problemKey, problem)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("- end set status %s for %s \n", status, problemKey)
+	return shim.Success(nil)
+}
+
 // ===================================================================================
 // 						Item (data or algo) registration
 // ===================================================================================
 
-// storeItem stores an item (data or algo) in the chaincode
-func storeItem(APIstub shim.ChaincodeStubInterface, itemKey string, itemType string,
-	storageAddress string, problem string, name string) (item Item, err error) {
+// getCallerOrg returns the organisation (MSP ID) of the submitter of the transaction
+func getCallerOrg(APIstub shim.ChaincodeStubInterface) (string, error) {
+	creator, err := APIstub.GetCreator()
+	if err != nil {
+		return "", err
+	}
+	identity := &msp.SerializedIdentity{}
+	err = proto.Unmarshal(creator, identity)
+	if err != nil {
+		return "", fmt.Errorf("Problem unmarshaling creator - %s", err)
+	}
+	return identity.GetMspid(), nil
+}
 
-	item = Item{ObjectType: itemType, StorageAddress: storageAddress, Problem: problem, Name: name}
+// storeItem stores a new item (data or algo) in the chaincode,
+// and creates its composite keys
+func storeItem(APIstub shim.ChaincodeStubInterface, itemKey string, item Item) error {
 
-	itemAsBytes, err := json.Marshal(item)
+	itemAsBytes, err := marshalRecord(&item)
 	if err != nil {
-		return item, err
+		return err
 	}
 	// Store item
 	err = APIstub.PutState(itemKey, itemAsBytes)
 	if err != nil {
-		return item, err
+		return err
 	}
 
 	// Create composite key to enable (itemtype + problem + itemKey)-based range queries,
@@ -321,47 +891,331 @@ func storeItem(APIstub shim.ChaincodeStubInterface, itemKey string, itemType str
 	indexName := item.ObjectType + "~problem~key"
 	itemProblemIndexKey, err := APIstub.CreateCompositeKey(indexName, []string{item.ObjectType, item.Problem, itemKey})
 	if err != nil {
-		return item, err
+		return err
 	}
 	emptyValue := []byte{0x00}
 	err = APIstub.PutState(itemProblemIndexKey, emptyValue)
 	if err != nil {
-		return item, err
+		return err
 	}
 
-	return item, err
+	// Create composite keys tag~type~key
+	return indexTags(APIstub, item.ObjectType, itemKey, nil, item.Metadata.Tags)
 }
 
 // registerItem is the smart contract to register new data or algorithm,
 // and create associated learnuplets
-// Args (4 strings): itemType (data or algo), storageAddress, problem key on Orchestrator, name
+// Args (4 to 6 strings): itemType (data or algo), storageAddress, problem key on Orchestrator, name,
+// optionally metadata ("{\"description\": \"...\", \"tags\": [\"image\"], ...}"),
+// optionally the usage policy of a data (see setDataPolicy)
+// For data of private problems, storageAddress must be empty and given in the transient map instead.
 func (s *SmartContract) registerItem(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
 
-	if len(args) != 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 3: itemType, storage_address, problem")
+	if len(args) < 4 || len(args) > 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 4 to 6: itemType, storage_address, problem, name, optionally metadata and usage policy")
 	}
 
 	fmt.Println("- start create " + args[0])
 
+	// Archived problems do not accept new items, frozen ones do not generate new learnuplets
+	problem, err := getProblem(APIstub, args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if problem.Status == "archived" {
+		return shim.Error("Problem " + args[2] + " is archived and does not accept new " + args[0])
+	}
+	frozen := problem.Status == "frozen"
+
+	owner, err := getCallerOrg(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var metadata Metadata
+	if len(args) >= 5 {
+		metadata, err = newMetadata(APIstub, args[4])
+	} else {
+		metadata, err = newMetadata(APIstub, "")
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var policy UsagePolicy
+	if len(args) == 6 {
+		if args[0] != "data" && strings.TrimSpace(args[5]) != "" {
+			return shim.Error("Only data have a usage policy")
+		}
+		policy, err = parseUsagePolicy(args[5])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
 	// Create item key
 	itemKey := args[0] + "_" + uuid.NewV4().String()
 	// Store item in ledger and create composite key
-	item, err := storeItem(APIstub, itemKey, args[0], args[1], args[2], args[3])
+	item := Item{ObjectType: args[0], StorageAddress: args[1], Problem: args[2], Name: args[3],
+		Owner: owner, Status: "active", Metadata: metadata, Policy: policy}
+	// Addresses of data of private problems are only stored in the private data collection
+	if item.ObjectType == "data" && problem.isPrivate() {
+		item.StorageAddress, err = getPrivateArgument(APIstub, "storageAddress", args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if item.StorageAddress == "" {
+			return shim.Error("storageAddress of a private problem is missing from the transient map")
+		}
+		err = storePrivateItem(APIstub, problem.Privacy.Collection, itemKey, &item)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	err = storeItem(APIstub, itemKey, item)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	// Data held out by the split of the problem are only used for evaluation
+	if item.ObjectType == "data" && problem.Split.isHoldout(itemKey) {
+		err = addHoldoutData(APIstub, item.Problem, problem, itemKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		fmt.Println("-- " + itemKey + " held out as test data")
+		fmt.Println("- end create " + item.ObjectType)
+		return shim.Success(nil)
+	}
+	if frozen {
+		fmt.Println("-- problem " + item.Problem + " is frozen, no learnuplet created")
+		fmt.Println("- end create " + item.ObjectType)
+		return shim.Success(nil)
+	}
 	// Create associated learnuplet
 	if args[0] == "algo" {
 		fmt.Println("-- create associated learnuplets")
-		algoLearnuplet(APIstub, itemKey, item)
+		err = algoLearnuplet(APIstub, itemKey, item)
+		if err != nil {
+			return shim.Error("Problem creating learnuplets of algo - " + err.Error())
+		}
 	}
 	if args[0] == "data" {
 		fmt.Println("-- create associated learnuplets")
 		data := []string{itemKey}
-		dataLearnuplet(APIstub, data, item.Problem)
+		err = dataLearnuplet(APIstub, data, item.Problem)
+		if err != nil {
+			return shim.Error("Problem creating learnuplets of data - " + err.Error())
+		}
+	}
+	fmt.Println("- end create " + item.ObjectType)
+	return shim.Success(nil)
+}
+
+// ===================================================================================
+// 						Item (data or algo) withdrawal
+// ===================================================================================
+
+// withdrawItem is the smart contract to withdraw an algo, or to revoke the consent on data.
+// The item is kept for audit but is marked withdrawn and is not associated with its problem
+// anymore. Learnuplets not started yet (status todo) which train the algo or train on the data
+// are cancelled. Withdrawn test data are removed from the test data of the problem.
+// Only the organisation which registered the item can withdraw it.
+// Args (2 strings): itemKey, reason
+func (s *SmartContract) withdrawItem(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2: itemKey, reason")
+	}
+
+	itemKey := args[0]
+	fmt.Printf("- start withdraw %s \n", itemKey)
+
+	// Get item and check it can be withdrawn by the caller
+	value, err := APIstub.GetState(itemKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if value == nil {
+		return shim.Error("No item with key - " + itemKey)
+	}
+	item := Item{}
+	err = unmarshalRecord(APIstub, value, &item)
+	if err != nil {
+		return shim.Error("Problem Unmarshal item - " + err.Error())
+	}
+	if item.ObjectType != "data" && item.ObjectType != "algo" {
+		return shim.Error(itemKey + " is neither a data nor an algo")
+	}
+	if item.Status == "withdrawn" {
+		return shim.Error(itemKey + " is already withdrawn")
+	}
+	caller, err := getCallerOrg(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if item.Owner != "" && item.Owner != caller {
+		return shim.Error(itemKey + " can only be withdrawn by " + item.Owner)
+	}
+
+	// Mark the item withdrawn
+	item.Status = "withdrawn"
+	item.WithdrawalReason = args[1]
+	item.WithdrawalTxID = APIstub.GetTxID()
+	itemAsBytes, err := marshalRecord(&item)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(itemKey, itemAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// The address of withdrawn private data is erased, only its hash is kept for audit
+	if item.Collection != "" {
+		err = APIstub.DelPrivateData(item.Collection, itemKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// Remove the item from the itemType~problem~key index, so that no new learnuplet uses it
+	itemProblemIndexKey, err := APIstub.CreateCompositeKey(item.ObjectType+"~problem~key", []string{item.ObjectType, item.Problem, itemKey})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.DelState(itemProblemIndexKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Cancel learnuplets which have not been started yet
+	nbCancelled, err := cancelItemLearnuplets(APIstub, itemKey, item)
+	if err != nil {
+		return shim.Error("Problem cancelling learnuplets of " + itemKey + " - " + err.Error())
+	}
+	fmt.Printf("-- %d learnuplets cancelled \n", nbCancelled)
+
+	fmt.Printf("- end withdraw %s \n", itemKey)
+	return shim.Success(nil)
+}
+
+// cancelItemLearnuplets cancels the learnuplets with status todo using a withdrawn item:
+// learnuplets of a withdrawn algo, and learnuplets training on withdrawn data.
+// Withdrawn test data are removed from the problem test data, and learnuplets not started yet
+// are evaluated without them, as well as without withdrawn data of their cross-validation fold.
+// It returns the number of cancelled learnuplets.
+func cancelItemLearnuplets(APIstub shim.ChaincodeStubInterface, itemKey string, item Item) (nbCancelled int, err error) {
+
+	var learnuplets []map[string]interface{}
+	if item.ObjectType == "algo" {
+		_, learnuplets, err = getCompositeLearnuplet(APIstub, "algo", itemKey)
+	} else {
+		_, learnuplets, err = getCompositeLearnuplet(APIstub, "status", "todo")
+	}
+	if err != nil {
+		return nbCancelled, err
+	}
+
+	// Withdrawn test data are removed from the problem
+	if item.ObjectType == "data" {
+		err = removeTestData(APIstub, item.Problem, itemKey)
+		if err != nil {
+			return nbCancelled, err
+		}
+	}
+
+	cancelled := make(map[string][]keyedLearnuplet)
+	for _, learnuplet := range learnuplets {
+		if status, _ := learnuplet["status"].(string); status != "todo" {
+			continue
+		}
+		if item.ObjectType == "data" {
+			trainData, _ := learnuplet["trainData"].(map[string]interface{})
+			testData, _ := learnuplet["testData"].(map[string]interface{})
+			if _, ok := testData[itemKey]; ok {
+				err = removeLearnupletTestData(APIstub, learnuplet["key"].(string), itemKey)
+				if err != nil {
+					return nbCancelled, err
+				}
+			}
+			if _, ok := trainData[itemKey]; !ok {
+				continue
+			}
+		}
+		learnupletKey, _ := learnuplet["key"].(string)
+		value, err := APIstub.GetState(learnupletKey)
+		if err != nil {
+			return nbCancelled, err
+		}
+		retrievedLearnuplet := Learnuplet{}
+		err = unmarshalRecord(APIstub, value, &retrievedLearnuplet)
+		if err != nil {
+			return nbCancelled, err
+		}
+		err = setLearnupletStatus(APIstub, learnupletKey, retrievedLearnuplet, "cancelled")
+		if err != nil {
+			return nbCancelled, err
+		}
+		nbCancelled++
+		retrievedLearnuplet.Status = "cancelled"
+		algoKey := getAlgoKey(retrievedLearnuplet)
+		cancelled[algoKey] = append(cancelled[algoKey], keyedLearnuplet{learnupletKey, retrievedLearnuplet})
+	}
+
+	// Learnuplets waiting for cancelled ones of a data start from a previous model instead
+	if item.ObjectType == "data" && len(cancelled) > 0 {
+		problem, err := getProblem(APIstub, item.Problem)
+		if err != nil {
+			return nbCancelled, err
+		}
+		var algoKeys []string
+		for algoKey := range cancelled {
+			algoKeys = append(algoKeys, algoKey)
+		}
+		sort.Strings(algoKeys)
+		for _, algoKey := range algoKeys {
+			err = propagateModel(APIstub, problem, algoKey, cancelled[algoKey]...)
+			if err != nil {
+				return nbCancelled, err
+			}
+		}
+	}
+	return nbCancelled, nil
+}
+
+// removeLearnupletTestData removes a data from the test data of a learnuplet
+func removeLearnupletTestData(APIstub shim.ChaincodeStubInterface, learnupletKey string, dataKey string) error {
+	value, err := APIstub.GetState(learnupletKey)
+	if err != nil {
+		return err
+	}
+	learnuplet := Learnuplet{}
+	err = unmarshalRecord(APIstub, value, &learnuplet)
+	if err != nil {
+		return err
+	}
+	delete(learnuplet.TestData, dataKey)
+	return storeLearnuplet(APIstub, learnupletKey, learnuplet)
+}
+
+// removeTestData removes data from the test data of a problem, if they are part of them.
+// Learnuplets not started yet are then evaluated without these data.
+func removeTestData(APIstub shim.ChaincodeStubInterface, problemKey string, dataKey string) error {
+	problem, err := getProblem(APIstub, problemKey)
+	if err != nil {
+		// data which are not associated with a registered problem are not test data
+		return nil
+	}
+	for i, testDataKey := range problem.TestData {
+		if testDataKey != dataKey {
+			continue
+		}
+		problem.TestData = append(problem.TestData[:i], problem.TestData[i+1:]...)
+		err = storeProblem(APIstub, problemKey, problem)
+		if err != nil || problem.isCrossValidated() {
+			return err
+		}
+		_, err = refreshLearnupletTestData(APIstub, problemKey, problem.TestData)
+		return err
 	}
-	fmt.Println("- end create " + item.ObjectType)
-	return shim.Success(nil)
+	return nil
 }
 
 // ================================================================================
@@ -369,6 +1223,7 @@ func (s *SmartContract) registerItem(APIstub shim.ChaincodeStubInterface, args [
 // ================================================================================
 
 // queryObject is a smart contract to query an object (algo/problem/data/learnuplet)
+// Private fields of data and learnuplets are only returned to the organisations allowed to read them.
 // Arg (1 string): object key on Orchestrator
 func (s *SmartContract) queryObject(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
 
@@ -382,20 +1237,34 @@ func (s *SmartContract) queryObject(APIstub shim.ChaincodeStubInterface, args []
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	reader, err := newPrivateReader(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	payload, err = reader.revealObject(key, payload)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	fmt.Println("- end looking for object with key ", key)
 	return shim.Success(payload)
 }
 
 // queryObjects is a smart contract to query all objects of a object type
-// Arg (1 string): object type
+// Archived problems are only returned if "all" is given as second argument.
+// Args (1 or 2 strings): object type, optionally "all"
 func (s *SmartContract) queryObjects(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1: object type")
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2: object type, optionally all")
 	}
 
 	objectType := args[0]
+	all := len(args) == 2 && args[1] == "all"
 	fmt.Printf("- start looking for elements of type %s\n", objectType)
+	reader, err := newPrivateReader(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	resultsIterator, _ := APIstub.GetStateByRange(objectType+"_", objectType+"_z")
 	var items []map[string]interface{}
 	for resultsIterator.HasNext() {
@@ -403,11 +1272,18 @@ func (s *SmartContract) queryObjects(APIstub shim.ChaincodeStubInterface, args [
 		if err != nil {
 			return shim.Error(err.Error())
 		}
+		value, err := reader.revealObject(queryResponse.GetKey(), queryResponse.GetValue())
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 		var item map[string]interface{}
-		err = json.Unmarshal(queryResponse.GetValue(), &item)
+		err = unmarshalRecord(APIstub, value, &item)
 		if err != nil {
 			return shim.Error(err.Error())
 		}
+		if !all && item["docType"] == "problem" && item["status"] == "archived" {
+			continue
+		}
 		item["key"] = queryResponse.GetKey()
 		items = append(items, item)
 	}
@@ -478,6 +1354,10 @@ func (s *SmartContract) queryProblemItems(APIstub shim.ChaincodeStubInterface, a
 
 	// Get slice with keys of items associated to the problem
 	itemKeys, _ := getProblemItems(APIstub, problemKey, itemType)
+	reader, err := newPrivateReader(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	// Iterate through result set
 	results := make(map[string]interface{})
@@ -488,8 +1368,12 @@ func (s *SmartContract) queryProblemItems(APIstub shim.ChaincodeStubInterface, a
 		if err != nil {
 			return shim.Error(err.Error())
 		}
+		value, err = reader.revealObject(key, value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 		var ivalue interface{}
-		err = json.Unmarshal(value, &ivalue)
+		err = unmarshalRecord(APIstub, value, &ivalue)
 		results[key] = ivalue
 	}
 	payload, err := json.Marshal(results)
@@ -535,7 +1419,7 @@ func getCompositeLearnuplet(APIstub shim.ChaincodeStubInterface, keyRequest stri
 		returnedKey := compositeKeyParts[2]
 		value, _ := APIstub.GetState(returnedKey)
 		var learnuplet map[string]interface{}
-		err = json.Unmarshal(value, &learnuplet)
+		err = unmarshalRecord(APIstub, value, &learnuplet)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -550,6 +1434,78 @@ func getCompositeLearnuplet(APIstub shim.ChaincodeStubInterface, keyRequest stri
 	return payload, learnuplets, nil
 }
 
+// getLearnupletsByIndex is a function to get all learnuplets having a given status
+// (keyRequest: status, keyValue: todo, ...) or being linked with a given algo
+// (keyRequest: algo, keyValue: algoKey), as learnuplet structures
+func getLearnupletsByIndex(APIstub shim.ChaincodeStubInterface, keyRequest string,
+	keyValue string) ([]keyedLearnuplet, error) {
+
+	compositeKeyIndex := "learnuplet~" + keyRequest + "~key"
+	learnupletIterator, err := APIstub.GetStateByPartialCompositeKey(compositeKeyIndex, []string{"learnuplet", keyValue})
+	if err != nil {
+		return nil, err
+	}
+	defer learnupletIterator.Close()
+
+	var learnuplets []keyedLearnuplet
+	for learnupletIterator.HasNext() {
+		responseRange, err := learnupletIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := APIstub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		returnedKey := compositeKeyParts[2]
+		value, err := APIstub.GetState(returnedKey)
+		if err != nil {
+			return nil, err
+		}
+		learnuplet := keyedLearnuplet{Key: returnedKey}
+		err = unmarshalRecord(APIstub, value, &learnuplet.Learnuplet)
+		if err != nil {
+			return nil, fmt.Errorf("Problem Unmarshal %s - %s", returnedKey, err)
+		}
+		learnuplets = append(learnuplets, learnuplet)
+	}
+	return learnuplets, nil
+}
+
+// getAlgoKey returns the key of the algo of a learnuplet
+func getAlgoKey(learnuplet Learnuplet) (algoKey string) {
+	for k := range learnuplet.Algo {
+		algoKey = k
+	}
+	return algoKey
+}
+
+// getProblemKey returns the key of the problem of a learnuplet
+func getProblemKey(learnuplet Learnuplet) (problemKey string) {
+	for k := range learnuplet.Problem {
+		problemKey = k
+	}
+	return problemKey
+}
+
+// queryLearnupletsByIndex returns the learnuplets found by getLearnupletsByIndex as JSON,
+// with their private fields if the caller is allowed to read them
+func queryLearnupletsByIndex(APIstub shim.ChaincodeStubInterface, keyRequest string, keyValue string) ([]byte, error) {
+	learnuplets, err := getLearnupletsByIndex(APIstub, keyRequest, keyValue)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := newPrivateReader(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	err = reader.revealLearnuplets(learnuplets)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(learnuplets)
+}
+
 // queryStatusLearnuplet is a smart contract to get all learnuplet with a specific status
 // Arg (1 string): "status" ("todo", "pending", "done", "failed")
 func (s *SmartContract) queryStatusLearnuplet(APIstub shim.ChaincodeStubInterface,
@@ -562,7 +1518,7 @@ func (s *SmartContract) queryStatusLearnuplet(APIstub shim.ChaincodeStubInterfac
 	status := args[0]
 	fmt.Println("- start looking for learnuplet with status ", status)
 
-	payload, _, err := getCompositeLearnuplet(APIstub, "status", status)
+	payload, err := queryLearnupletsByIndex(APIstub, "status", status)
 	if err != nil {
 		return shim.Error("Problem querying learnuplet depending on status " +
 			status + " - " + err.Error())
@@ -584,7 +1540,7 @@ func (s *SmartContract) queryAlgoLearnuplet(APIstub shim.ChaincodeStubInterface,
 	algo := args[0]
 	fmt.Println("- start looking for learnuplet of algo ", algo)
 
-	payload, _, err := getCompositeLearnuplet(APIstub, "algo", algo)
+	payload, err := queryLearnupletsByIndex(APIstub, "algo", algo)
 	if err != nil {
 		return shim.Error("Problem querying learnuplet associated with algo " +
 			algo + " - " + err.Error())
@@ -598,65 +1554,35 @@ func (s *SmartContract) queryAlgoLearnuplet(APIstub shim.ChaincodeStubInterface,
 // ====================================================================
 
 // getRankAlgoLearnuplet is a function to get the last defined rank of the learnuplets
-// associated to an algo, the algo address, and the address of the associated trained model
+// associated to an algo (-1 if there is none), the algo address, and the address of the model
+// from which following learnuplets start, empty if it is not known yet
 func getRankAlgoLearnuplet(APIstub shim.ChaincodeStubInterface, algoKey string) (rank int, algoAddress string, modelAddress string, err error) {
 	fmt.Printf("--- looking for last learnuplet rank of %s \n", algoKey)
 
-	modelAddress = ""
-	algoAddress = ""
-	// Query the learnuplet~algo~key index by algo
-	algoAssociatedLearnupletIterator, err := APIstub.GetStateByPartialCompositeKey("learnuplet~algo~key", []string{"learnuplet", algoKey})
+	value, err := APIstub.GetState(algoKey)
 	if err != nil {
-		return -1, algoAddress, modelAddress, err
+		return -1, "", "", err
 	}
-	defer algoAssociatedLearnupletIterator.Close()
-
-	// Iterate through result set and for each learnuplet found
-	var newRank int
-	var perf, newPerf float64
-	rank = 0
-	for i := 0; algoAssociatedLearnupletIterator.HasNext(); i++ {
-		responseRange, err := algoAssociatedLearnupletIterator.Next()
-		if err != nil {
-			return -1, algoAddress, modelAddress, err
-		}
-
-		// get the itemType, problem, and key from the composite key
-		_, compositeKeyParts, err := APIstub.SplitCompositeKey(responseRange.Key)
-		if err != nil {
-			return -1, algoAddress, modelAddress, err
-		}
-		returnedKey := compositeKeyParts[2]
-		value, _ := APIstub.GetState(returnedKey)
-		retrievedLearnuplet := Learnuplet{}
-		err = json.Unmarshal(value, &retrievedLearnuplet)
-		if err != nil {
-			fmt.Errorf("Problem Unmarshal %s", returnedKey)
-			return -1, algoAddress, modelAddress, err
-		}
-		if i == 0 {
-			perf = retrievedLearnuplet.Perf
-			algoAddress = retrievedLearnuplet.Algo[algoKey]
-		}
-		newRank = retrievedLearnuplet.Rank
-		newPerf = retrievedLearnuplet.Perf
-		// If better perf, update modelAddess
-		if retrievedLearnuplet.Status == "done" && newPerf >= perf {
-			perf = newPerf
-			modelAddress = retrievedLearnuplet.ModelEndAddress
-		}
-		// If greater rank, update rank
-		if newRank >= rank {
-			rank = newRank
-			if retrievedLearnuplet.Status != "done" {
-				modelAddress = ""
-			}
-		}
-
-		fmt.Printf("- for algo %s: found last rank %s and associated model %s \n", algoKey, rank, modelAddress)
+	algo := Item{}
+	err = unmarshalRecord(APIstub, value, &algo)
+	if err != nil {
+		return -1, "", "", fmt.Errorf("Problem Unmarshal %s - %s", algoKey, err)
 	}
-
-	return rank, algoAddress, modelAddress, nil
+	problem, err := getProblem(APIstub, algo.Problem)
+	if err != nil {
+		return -1, "", "", err
+	}
+	chain, err := getModelChain(APIstub, problem, algoKey)
+	if err != nil {
+		return -1, "", "", err
+	}
+	// Cancelled learnuplets are not part of the training anymore
+	rank = chain.lastRank()
+	if chain.isSettled(rank + 1) {
+		modelAddress = chain.startModel(rank + 1)
+	}
+	fmt.Printf("- for algo %s: found last rank %d and associated model %s \n", algoKey, rank, modelAddress)
+	return rank, algo.StorageAddress, modelAddress, nil
 }
 
 // getDataAddress is a function to get data addresses on Storage given their keys
@@ -665,14 +1591,12 @@ func getDataAddress(APIstub shim.ChaincodeStubInterface, data []string) (dataAdd
 	for _, idata := range data {
 		value, err := APIstub.GetState(idata)
 		if err != nil {
-			fmt.Errorf("%s not found", idata)
-			return dataAddresses, err
+			return dataAddresses, fmt.Errorf("%s not found - %s", idata, err)
 		}
 		retrievedData := Item{}
-		err = json.Unmarshal(value, &retrievedData)
+		err = unmarshalRecord(APIstub, value, &retrievedData)
 		if err != nil {
-			fmt.Errorf("Problem Unmarshal %s", idata)
-			return dataAddresses, err
+			return dataAddresses, fmt.Errorf("Problem Unmarshal %s - %s", idata, err)
 		}
 		dataAddresses[idata] = retrievedData.StorageAddress
 	}
@@ -680,35 +1604,40 @@ func getDataAddress(APIstub shim.ChaincodeStubInterface, data []string) (dataAdd
 }
 
 // createLearnuplet is a function to create learnuplets given a set of train data, an algo,
-// and parameter of the training related to the problem
+// and parameter of the training related to the problem.
+// Train data are grouped in mini-batches of size szBatch according to the batching strategy of the
+// problem, and the batch assignment is recorded on the ledger.
+// The first learnuplet starts from modelStartAddress, and the following ones too if they are
+// independent, that is if the problem restarts from the initial model for each mini-batch.
+// Learnuplets belong to the given cross-validation fold.
 func createLearnuplet(
-	APIstub shim.ChaincodeStubInterface, trainData []string, szBatch int,
+	APIstub shim.ChaincodeStubInterface, trainData []string, szBatch int, batching Batching,
 	testData []string, problem string, problemAddress string, algo string,
-	algoAddress string, modelStartAddress string, startRank int) (err error) {
+	algoAddress string, modelStartAddress string, startRank int, fold int, independent bool) (err error) {
 
 	err = nil
 	nbFailLearnuplet := 0
-	var batchData []string
 	// create empty maps for performances
 	var trainPerf, testPerf map[string]float64
 	trainPerf = make(map[string]float64)
 	testPerf = make(map[string]float64)
 	// get testData addresses on Storage
 	mapTestData, _ := getDataAddress(APIstub, testData)
+	// group train data in mini-batches
+	assignment, err := newBatchAssignment(APIstub, batching, problem, algo, trainData, szBatch)
+	if err != nil {
+		return err
+	}
+	assignmentKey := "batches_" + uuid.NewV4().String()
 	// For each mini-batch of data, create a learnuplet
-	for i, j := 0, 0; i < len(trainData); i, j = i+szBatch, j+1 {
-		if i+szBatch >= len(trainData) {
-			batchData = trainData[i:]
-
-		} else {
-			batchData = trainData[i : i+szBatch]
-		}
-		j = j + startRank
-		// if not first rank, modelStart is empty, will be filled once first rank has been computed
+	for j, batchData := range assignment.Batches {
+		rank := startRank + j
+		// if not first rank, modelStart is empty, will be filled once previous ranks have been computed,
+		// unless learnuplets are independent
 		// Generation of ModelEnd
 		modelEndAddress := uuid.NewV4().String()
 		learnupletModelStartAddress := ""
-		if j == startRank {
+		if j == 0 || independent {
 			learnupletModelStartAddress = modelStartAddress
 		}
 		// get testData addresses on Storage
@@ -716,6 +1645,7 @@ func createLearnuplet(
 		// Learnuplet definition
 		newLearnuplet := Learnuplet{
 			ObjectType:        "learnuplet",
+			Type:              "train",
 			Problem:           map[string]string{problem: problemAddress},
 			Algo:              map[string]string{algo: algoAddress},
 			ModelStartAddress: learnupletModelStartAddress,
@@ -724,37 +1654,35 @@ func createLearnuplet(
 			TestData:          mapTestData,
 			Worker:            "",
 			Status:            "todo",
-			Rank:              j,
+			Rank:              rank,
+			Fold:              fold,
+			BatchAssignment:   assignmentKey,
+			Batch:             j,
 			Perf:              0,
 			TrainPerf:         trainPerf,
 			TestPerf:          testPerf,
+			Perfs:             make(map[string]float64),
+			TrainPerfs:        make(map[string]map[string]float64),
+			TestPerfs:         make(map[string]map[string]float64),
 		}
 		// Append to ledger
 		learnupletKey := "learnuplet_" + uuid.NewV4().String()
-		newLearnupletAsBytes, errL := json.Marshal(newLearnuplet)
-		if errL != nil {
-			fmt.Errorf("Problem marshaling ", learnupletKey)
-			nbFailLearnuplet++
-			continue
-		}
-		err = APIstub.PutState(learnupletKey, newLearnupletAsBytes)
+		errL := putNewLearnuplet(APIstub, learnupletKey, newLearnuplet)
 		if errL != nil {
-			fmt.Errorf("Problem putting state of ", learnupletKey)
+			fmt.Printf("Problem storing %s - %s \n", learnupletKey, errL)
 			nbFailLearnuplet++
 			continue
 		}
-		// Create composite key learnuplet~algo~key
-		indexName := "learnuplet~algo~key"
-		learnupletAlgoIndexKey, _ := APIstub.CreateCompositeKey(indexName, []string{"learnuplet", algo, learnupletKey})
-		value := []byte{0x00}
-		APIstub.PutState(learnupletAlgoIndexKey, value)
-		// Create composite key learnuplet~status~key
-		indexName = "learnuplet~status~key"
-		learnupletStatusIndexKey, _ := APIstub.CreateCompositeKey(indexName, []string{"learnuplet", "todo", learnupletKey})
-		APIstub.PutState(learnupletStatusIndexKey, value)
+		assignment.Learnuplets = append(assignment.Learnuplets, learnupletKey)
 		fmt.Printf("-- creation of %s ok \n", learnupletKey)
 
 	}
+	if len(assignment.Batches) > 0 {
+		err = storeBatchAssignment(APIstub, assignmentKey, assignment)
+		if err != nil {
+			return err
+		}
+	}
 
 	if nbFailLearnuplet > 0 {
 		err = &errorUplet{nbFailLearnuplet, "failure in learnuplet creation"}
@@ -762,6 +1690,37 @@ func createLearnuplet(
 	return err
 }
 
+// putNewLearnuplet stores a new learnuplet and creates its composite keys
+// learnuplet~algo~key and learnuplet~status~key. The use of its train data is recorded.
+// Learnuplets of private problems are bound to the private data collection of the problem.
+func putNewLearnuplet(APIstub shim.ChaincodeStubInterface, learnupletKey string, learnuplet Learnuplet) error {
+	if problem, err := getProblem(APIstub, getProblemKey(learnuplet)); err == nil {
+		learnuplet.Collection = problem.Privacy.Collection
+	}
+	err := storeLearnuplet(APIstub, learnupletKey, learnuplet)
+	if err != nil {
+		return err
+	}
+	err = recordDataUsage(APIstub, learnupletKey, learnuplet)
+	if err != nil {
+		return err
+	}
+	value := []byte{0x00}
+	learnupletAlgoIndexKey, err := APIstub.CreateCompositeKey("learnuplet~algo~key", []string{"learnuplet", getAlgoKey(learnuplet), learnupletKey})
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(learnupletAlgoIndexKey, value)
+	if err != nil {
+		return err
+	}
+	learnupletStatusIndexKey, err := APIstub.CreateCompositeKey("learnuplet~status~key", []string{"learnuplet", learnuplet.Status, learnupletKey})
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(learnupletStatusIndexKey, value)
+}
+
 // algoLearnuplet is a function to create learnuplet when new algo is registered.
 // It calls the function createLearnuplet
 func algoLearnuplet(APIstub shim.ChaincodeStubInterface, algoKey string, algo Item) error {
@@ -770,15 +1729,8 @@ func algoLearnuplet(APIstub shim.ChaincodeStubInterface, algoKey string, algo It
 	algoAddress := algo.StorageAddress
 
 	// Find test data
-	value, err := APIstub.GetState(problem)
+	retrievedProblem, err := getProblem(APIstub, problem)
 	if err != nil {
-		fmt.Errorf("%s not found", problem)
-		return err
-	}
-	retrievedProblem := Problem{}
-	err = json.Unmarshal(value, &retrievedProblem)
-	if err != nil {
-		fmt.Errorf("Problem Unmarshal %s", problem)
 		return err
 	}
 	testData := retrievedProblem.TestData
@@ -797,31 +1749,42 @@ func algoLearnuplet(APIstub shim.ChaincodeStubInterface, algoKey string, algo It
 		}
 	}
 	sort.Strings(trainData)
+	// Only data whose usage policy allows the algo are trained on
+	trainData, err = filterAllowedData(APIstub, algoKey, trainData)
+	if err != nil {
+		return err
+	}
 	// Create learnuplets
+	if retrievedProblem.Federated {
+		return federatedLearnuplets(APIstub, problem, retrievedProblem, []string{algoKey})
+	}
+	if retrievedProblem.isParallel() {
+		return createParallelLearnuplets(APIstub, problem, retrievedProblem, algoKey, algoAddress, trainData)
+	}
+	if retrievedProblem.isCrossValidated() {
+		foldData, err := getFoldData(APIstub, problem, retrievedProblem, nil)
+		if err != nil {
+			return err
+		}
+		return createFoldLearnuplets(APIstub, problem, retrievedProblem, algoKey, algoAddress, trainData, foldData)
+	}
 	modelStartAddress := algo.StorageAddress
 	err = createLearnuplet(
-		APIstub, trainData, sizeTrainDataset, testData, problem, problemAddress,
-		algoKey, algoAddress, modelStartAddress, 0)
+		APIstub, trainData, sizeTrainDataset, retrievedProblem.Batching, testData, problem, problemAddress,
+		algoKey, algoAddress, modelStartAddress, 0, 0, retrievedProblem.isIndependent())
 	return err
 }
 
 // dataLearnuplet is a function to create learnuplet when new data is registered
 // It calls the function createLearnuplet
-func dataLearnuplet(APIstub shim.ChaincodeStubInterface, data []string, problem string) (err error) {
+func dataLearnuplet(APIstub shim.ChaincodeStubInterface, newData []string, problem string) (err error) {
 
 	nbFailLearnuplet := 0
 	err = nil
 
 	// Find test data
-	value, err := APIstub.GetState(problem)
-	if err != nil {
-		fmt.Errorf("%s not found", problem)
-		return err
-	}
-	retrievedProblem := Problem{}
-	err = json.Unmarshal(value, &retrievedProblem)
+	retrievedProblem, err := getProblem(APIstub, problem)
 	if err != nil {
-		fmt.Errorf("Problem Unmarshal %s", problem)
 		return err
 	}
 	testData := retrievedProblem.TestData
@@ -829,20 +1792,70 @@ func dataLearnuplet(APIstub shim.ChaincodeStubInterface, data []string, problem
 	problemAddress := retrievedProblem.StorageAddress
 	// Find all active algo associated to the same problem
 	algoKeys, _ := getProblemItems(APIstub, problem, "algo")
+	if retrievedProblem.Federated {
+		return federatedLearnuplets(APIstub, problem, retrievedProblem, algoKeys)
+	}
+	var foldData [][]string
+	if retrievedProblem.isCrossValidated() {
+		foldData, err = getFoldData(APIstub, problem, retrievedProblem, newData)
+		if err != nil {
+			return err
+		}
+	}
 	// For each algo, find the last rank and create learnuplet
 	var rank int
 	var algoAddress, modelAddress string
 	for _, algoKey := range algoKeys {
+		// Learnuplets not started yet are evaluated on the new data of their fold
+		if retrievedProblem.isCrossValidated() {
+			err = refreshFoldTestData(APIstub, retrievedProblem, algoKey, newData, foldData)
+			if err != nil {
+				fmt.Printf("Problem updating test data of learnuplets of %s - %s \n", algoKey, err)
+				nbFailLearnuplet++
+				continue
+			}
+		}
+		// Only data whose usage policy allows the algo are trained on
+		data, err := filterAllowedData(APIstub, algoKey, newData)
+		if err != nil {
+			fmt.Printf("Problem checking usage policies for %s - %s \n", algoKey, err)
+			nbFailLearnuplet++
+			continue
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if retrievedProblem.isParallel() || retrievedProblem.isCrossValidated() {
+			algo := Item{}
+			value, _ := APIstub.GetState(algoKey)
+			err = unmarshalRecord(APIstub, value, &algo)
+			if err == nil && retrievedProblem.isParallel() {
+				err = createParallelLearnuplets(APIstub, problem, retrievedProblem, algoKey, algo.StorageAddress, data)
+			} else if err == nil {
+				err = createFoldLearnuplets(APIstub, problem, retrievedProblem, algoKey, algo.StorageAddress, data, foldData)
+			}
+			if err != nil {
+				fmt.Printf("Problem creating learnuplets of %s - %s \n", algoKey, err)
+				nbFailLearnuplet++
+			}
+			continue
+		}
 		rank, algoAddress, modelAddress, err = getRankAlgoLearnuplet(APIstub, algoKey)
 		if err != nil {
-			nbFailLearnuplet = nbFailLearnuplet + err.(*errorUplet).number
+			fmt.Printf("Problem getting last rank of %s - %s \n", algoKey, err)
+			nbFailLearnuplet++
 			continue
 		}
 		err = createLearnuplet(
-			APIstub, data, sizeTrainDataset, testData, problem, problemAddress,
-			algoKey, algoAddress, modelAddress, rank+1)
+			APIstub, data, sizeTrainDataset, retrievedProblem.Batching, testData, problem, problemAddress,
+			algoKey, algoAddress, modelAddress, rank+1, 0, retrievedProblem.isIndependent())
 		if err != nil {
-			nbFailLearnuplet = nbFailLearnuplet + err.(*errorUplet).number
+			if errUplet, ok := err.(*errorUplet); ok {
+				nbFailLearnuplet = nbFailLearnuplet + errUplet.number
+			} else {
+				fmt.Printf("Problem creating learnuplets of %s - %s \n", algoKey, err)
+				nbFailLearnuplet++
+			}
 		}
 	}
 	if nbFailLearnuplet > 0 {
@@ -851,12 +1864,55 @@ func dataLearnuplet(APIstub shim.ChaincodeStubInterface, data []string, problem
 	return err
 }
 
+// storeLearnuplet stores an updated learnuplet, with the same status.
+// Per-data performances of learnuplets of private problems are stored in their private data collection.
+func storeLearnuplet(APIstub shim.ChaincodeStubInterface, learnupletKey string, learnuplet Learnuplet) error {
+	if learnuplet.Collection != "" {
+		err := storePrivateLearnuplet(APIstub, learnupletKey, &learnuplet)
+		if err != nil {
+			return err
+		}
+	}
+	learnupletAsBytes, err := marshalRecord(&learnuplet)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(learnupletKey, learnupletAsBytes)
+}
+
+// setLearnupletStatus stores a learnuplet with a new status,
+// and updates the associated composite key learnuplet~status~key
+func setLearnupletStatus(APIstub shim.ChaincodeStubInterface, learnupletKey string,
+	learnuplet Learnuplet, status string) error {
+
+	oldStatus := learnuplet.Status
+	learnuplet.Status = status
+	err := storeLearnuplet(APIstub, learnupletKey, learnuplet)
+	if err != nil {
+		return err
+	}
+	indexName := "learnuplet~status~key"
+	oldLearnupletStatusIndexKey, err := APIstub.CreateCompositeKey(indexName, []string{"learnuplet", oldStatus, learnupletKey})
+	if err != nil {
+		return err
+	}
+	err = APIstub.DelState(oldLearnupletStatusIndexKey)
+	if err != nil {
+		return err
+	}
+	learnupletStatusIndexKey, err := APIstub.CreateCompositeKey(indexName, []string{"learnuplet", status, learnupletKey})
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(learnupletStatusIndexKey, []byte{0x00})
+}
+
 // ================================================================================
 // 				Push from Compute: update learnuplets and preduplets
 // ================================================================================
 
 // setUpletWorker is a smart contract to set a worker for a learnuplet.
-// It should be callable by Compute only.
+// It should be callable by Compute only, for a worker registered with registerWorker.
 // Args (2 strings): "upletKey", "worker"
 // As for many other functions, this is for now a simple function, much more checks will be applied later...
 func (s *SmartContract) setUpletWorker(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
@@ -873,38 +1929,86 @@ func (s *SmartContract) setUpletWorker(APIstub shim.ChaincodeStubInterface, args
 	if value == nil {
 		return shim.Error("No learnuplet with key - " + upletKey)
 	}
-	err := json.Unmarshal(value, &retrievedLearnuplet)
+	err := unmarshalRecord(APIstub, value, &retrievedLearnuplet)
 	if err != nil {
 		return shim.Error("Problem Unmarshal uplet - " + err.Error())
 	}
-	if retrievedLearnuplet.Status == "pending" {
-		return shim.Error("Uplet status is already pending...")
-	} else {
-		retrievedLearnuplet.Status = "pending"
-		retrievedLearnuplet.Worker = worker
-		learnupletAsBytes, err := json.Marshal(retrievedLearnuplet)
-		if err != nil {
-			return shim.Error("Problem (re)marshaling uplet - " + err.Error())
-		}
-		err = APIstub.PutState(upletKey, learnupletAsBytes)
-		if err != nil {
-			return shim.Error("Problem storing uplet - " + err.Error())
-		}
-		// Update associated composite key learnuplet~status~key
-		indexName := "learnuplet~status~key"
-		emptyValue := []byte{0x00}
-		oldLearnupletStatusIndexKey, _ := APIstub.CreateCompositeKey(indexName, []string{"learnuplet", "todo", upletKey})
-		APIstub.DelState(oldLearnupletStatusIndexKey)
-		learnupletStatusIndexKey, _ := APIstub.CreateCompositeKey(indexName, []string{"learnuplet", "pending", upletKey})
-		APIstub.PutState(learnupletStatusIndexKey, emptyValue)
+	_, err = assignWorker(APIstub, upletKey, retrievedLearnuplet, worker)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 	fmt.Printf("- end set worker for %s \n", upletKey)
 	return shim.Success(nil)
 }
 
+// assignWorker sets the worker of a learnuplet and changes its status to pending.
+// Only learnuplets to do can be assigned, done and failed ones being final.
+// The worker must be registered by the organisation of the caller, be active, and
+// meet the requirements of the problem. The assignment is counted in the statistics
+// of the worker. It returns the updated learnuplet.
+func assignWorker(APIstub shim.ChaincodeStubInterface, upletKey string, learnuplet Learnuplet,
+	worker string) (Learnuplet, error) {
+
+	if learnuplet.Status == "pending" {
+		return learnuplet, fmt.Errorf("Uplet status is already pending...")
+	} else if learnuplet.Status == "cancelled" {
+		return learnuplet, fmt.Errorf("Uplet has been cancelled")
+	} else if learnuplet.Status != "todo" {
+		return learnuplet, fmt.Errorf("Uplet is already %s", learnuplet.Status)
+	}
+	err := checkAssignment(APIstub, worker, learnuplet)
+	if err != nil {
+		return learnuplet, err
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return learnuplet, err
+	}
+	learnuplet.Worker = worker
+	learnuplet.AssignedAt = txTime.Format(time.RFC3339)
+	learnuplet.Attempts++
+	err = setLearnupletStatus(APIstub, upletKey, learnuplet, "pending")
+	if err != nil {
+		return learnuplet, fmt.Errorf("Problem storing uplet - %s", err)
+	}
+	err = updateWorkerStats(APIstub, worker, func(stats *WorkerStats) {
+		stats.Claimed++
+	})
+	if err != nil {
+		return learnuplet, fmt.Errorf("Problem updating worker statistics - %s", err)
+	}
+	learnuplet.Status = "pending"
+	return learnuplet, nil
+}
+
+// failLearnuplet sets the status of a learnuplet to failed: the learnuplets waiting for it start from
+// a previous model instead, and the round of a federated problem is updated.
+func failLearnuplet(APIstub shim.ChaincodeStubInterface, problem Problem, upletKey string, learnuplet Learnuplet) error {
+	err := setLearnupletStatus(APIstub, upletKey, learnuplet, "failed")
+	if err != nil {
+		return fmt.Errorf("Problem storing learnuplet - %s", err)
+	}
+	learnuplet.Status = "failed"
+	err = propagateModel(APIstub, problem, getAlgoKey(learnuplet), keyedLearnuplet{upletKey, learnuplet})
+	if err != nil {
+		return fmt.Errorf("Problem updating next learnuplets - %s", err)
+	}
+	if problem.Federated {
+		err = updateRound(APIstub, problem, keyedLearnuplet{upletKey, learnuplet})
+		if err != nil {
+			return fmt.Errorf("Problem updating round - %s", err)
+		}
+	}
+	return nil
+}
+
 // reportLearn is a smart contract to set output of a learnuplet, updating the corresponding learnuplet.
 // Args (5 strings): "upletKey", "status", "perf", "trainPerf" ("{\"train_data_i\": perf_i, \"train_data_j\": perf_j, ...}"),
 // "testPerf" ("{\"test_data_i\": perf_j, \"test_data_j\": perf_j, ...}").
+// Each perf is either the value of the primary metric of the problem, or a map
+// of all its metrics to their values ("{\"auc\": 0.82, \"logloss\": 0.41}").
+// Only pending learnuplets can be reported, by the organisation owning their worker.
+// For private problems, trainPerf and testPerf must be empty and given in the transient map instead.
 // As for many other functions, this is for now a simple function, much more checks will be applied later...
 func (s *SmartContract) reportLearn(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
 
@@ -917,130 +2021,129 @@ func (s *SmartContract) reportLearn(APIstub shim.ChaincodeStubInterface, args []
 	// Get learnuplet
 	value, _ := APIstub.GetState(upletKey)
 	retrievedLearnuplet := Learnuplet{}
-	err := json.Unmarshal(value, &retrievedLearnuplet)
+	err := unmarshalRecord(APIstub, value, &retrievedLearnuplet)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Error Unmarshal uplet %s - %s", upletKey, err))
 	}
 
-	// Update learnuplet status and model end value
-	retrievedLearnuplet.Status = args[1]
+	if args[1] != "done" && args[1] != "failed" {
+		return shim.Error(fmt.Sprintf("Incorrect status %s, expecting done or failed", args[1]))
+	}
+	// Only pending learnuplets can be reported, done and failed ones are final
+	if retrievedLearnuplet.Status != "pending" {
+		return shim.Error(fmt.Sprintf("Uplet status is %s, not pending", retrievedLearnuplet.Status))
+	}
+
+	// Learnuplets are reported by the organisation owning their worker
+	worker, err := getWorker(APIstub, retrievedLearnuplet.Worker)
+	if err != nil {
+		return shim.Error("Problem getting worker of uplet - " + err.Error())
+	}
+	callerOrg, err := getCallerOrg(APIstub)
+	if err != nil {
+		return shim.Error("Problem getting organisation of the caller - " + err.Error())
+	}
+	if callerOrg != worker.Owner {
+		return shim.Error(fmt.Sprintf("Worker %s belongs to %s, not to %s", retrievedLearnuplet.Worker, worker.Owner, callerOrg))
+	}
 
-	// Deal with the status "failed" case
-	if retrievedLearnuplet.Status == "failed" {
-		// Store updated learnuplet
-		learnupletAsBytes, err := json.Marshal(retrievedLearnuplet)
+	// Get problem, defining the metrics of the performances
+	problem, err := getProblem(APIstub, getProblemKey(retrievedLearnuplet))
+	if err != nil {
+		return shim.Error("Problem getting problem of uplet - " + err.Error())
+	}
+
+	// performances on the data of private problems are not recorded in the transaction
+	trainPerfArg, testPerfArg := args[3], args[4]
+	if problem.isPrivate() {
+		trainPerfArg, err = getPrivateArgument(APIstub, "trainPerf", args[3])
 		if err != nil {
-			return shim.Error(fmt.Sprintf("Error re-Unmarshal uplet %s - %s", upletKey, err))
+			return shim.Error(err.Error())
 		}
-		err = APIstub.PutState(upletKey, learnupletAsBytes)
+		testPerfArg, err = getPrivateArgument(APIstub, "testPerf", args[4])
 		if err != nil {
-			return shim.Error("Problem storing learnuplet - " + err.Error())
+			return shim.Error(err.Error())
 		}
-		// Update associated composite key learnuplet~status~key
-		indexName := "learnuplet~status~key"
-		emptyValue := []byte{0x00}
-		oldLearnupletStatusIndexKey, _ := APIstub.CreateCompositeKey(indexName, []string{"learnuplet", "pending", upletKey})
-		APIstub.DelState(oldLearnupletStatusIndexKey)
-		learnupletStatusIndexKey, _ := APIstub.CreateCompositeKey(indexName, []string{"learnuplet", "failed", upletKey})
-		APIstub.PutState(learnupletStatusIndexKey, emptyValue)
-
-		fmt.Printf("- end Report learning phase of %s \n", upletKey)
-		return shim.Success(nil)
 	}
 
-	// Unmarhall perf data
-	var perf float64
-	var trainPerf, testPerf map[string]float64
-	if args[2] != "" {
-		perf, err = strconv.ParseFloat(args[2], 64)
-		if err != nil {
-			return shim.Error("Error parsing performance - " + err.Error())
-		}
-		// TODO check data addresses correspond to train and test data
-		fmt.Printf("before train")
-		fmt.Println(args[3])
-		err = json.Unmarshal([]byte(args[3]), &trainPerf)
+	// Deal with the status "failed" case
+	if args[1] == "failed" {
+		err = recordReport(APIstub, retrievedLearnuplet, "failed")
 		if err != nil {
-			return shim.Error("Error un-marshalling train perf - " + err.Error())
+			return shim.Error("Problem updating worker statistics - " + err.Error())
 		}
-		fmt.Printf("before train")
-		err = json.Unmarshal([]byte(args[4]), &testPerf)
+		err = failLearnuplet(APIstub, problem, upletKey, retrievedLearnuplet)
 		if err != nil {
-			return shim.Error("Error un-marshalling test perf - " + err.Error())
+			return shim.Error(err.Error())
 		}
+
+		fmt.Printf("- end Report learning phase of %s \n", upletKey)
+		return shim.Success(nil)
 	}
 
-	// Update Learnuplet Perf results
-	retrievedLearnuplet.Perf = perf
-	retrievedLearnuplet.TrainPerf = trainPerf
-	retrievedLearnuplet.TestPerf = testPerf
+	primaryMetric := problem.getPrimaryMetric()
 
-	// Store updated learnuplet
-	learnupletAsBytes, err := json.Marshal(retrievedLearnuplet)
+	// Unmarhall perf data, required for done learnuplets
+	if args[2] == "" {
+		return shim.Error("perf of a done learnuplet is required")
+	}
+	perfs, err := parsePerfs(problem, args[2])
 	if err != nil {
-		return shim.Error("Problem (re)marshaling learnuplet - " + err.Error())
+		return shim.Error("Error parsing performance - " + err.Error())
 	}
-	err = APIstub.PutState(upletKey, learnupletAsBytes)
+	trainPerfs, err := parseDataPerfs(problem, trainPerfArg)
 	if err != nil {
-		return shim.Error("Problem storing learnuplet - " + err.Error())
+		return shim.Error("Error un-marshalling train perf - " + err.Error())
+	}
+	testPerfs, err := parseDataPerfs(problem, testPerfArg)
+	if err != nil {
+		return shim.Error("Error un-marshalling test perf - " + err.Error())
+	}
+	// Check data keys correspond to train and test data, and values to the metrics
+	err = validateReportedPerfs(problem, retrievedLearnuplet, perfs, trainPerfs, testPerfs)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
+	perf := perfs[primaryMetric.Name]
 
-	// Update associated composite key learnuplet~status~key
-	indexName := "learnuplet~status~key"
-	emptyValue := []byte{0x00}
-	oldLearnupletStatusIndexKey, _ := APIstub.CreateCompositeKey(indexName, []string{"learnuplet", "pending", upletKey})
-	APIstub.DelState(oldLearnupletStatusIndexKey)
-	learnupletStatusIndexKey, _ := APIstub.CreateCompositeKey(indexName, []string{"learnuplet", "done", upletKey})
-	APIstub.PutState(learnupletStatusIndexKey, emptyValue)
-
-	// Update model start of learnuplet of next rank
-	var algoKey string
-	for k := range retrievedLearnuplet.Algo {
-		algoKey = k
+	// Update Learnuplet Perf results
+	retrievedLearnuplet.Perf = perf
+	retrievedLearnuplet.TrainPerf = primaryPerfs(problem, trainPerfs)
+	retrievedLearnuplet.TestPerf = primaryPerfs(problem, testPerfs)
+	retrievedLearnuplet.Perfs = perfs
+	retrievedLearnuplet.TrainPerfs = trainPerfs
+	retrievedLearnuplet.TestPerfs = testPerfs
+
+	// Store updated learnuplet and update associated composite key learnuplet~status~key
+	err = setLearnupletStatus(APIstub, upletKey, retrievedLearnuplet, "done")
+	if err != nil {
+		return shim.Error("Problem storing learnuplet - " + err.Error())
+	}
+	err = recordReport(APIstub, retrievedLearnuplet, "done")
+	if err != nil {
+		return shim.Error("Problem updating worker statistics - " + err.Error())
+	}
+	err = creditContributors(APIstub, problem, upletKey, retrievedLearnuplet)
+	if err != nil {
+		return shim.Error("Problem crediting contributors - " + err.Error())
+	}
+	err = updateDataValues(APIstub, problem, retrievedLearnuplet)
+	if err != nil {
+		return shim.Error("Problem updating data values - " + err.Error())
 	}
-	_, algoLearnuplet, err := getCompositeLearnuplet(APIstub, "algo", algoKey)
-	if err != nil {
-		return shim.Error("Problem getting learnuplets of same algo - " + err.Error())
-	}
-	if len(algoLearnuplet) > 1 {
-		var nextLearnupletKey string
-		bestPerf := perf
-		newModelStart := retrievedLearnuplet.ModelEndAddress
-		for _, learnuplet := range algoLearnuplet {
-			// Type conversion
-			// TOFIX: bad practice here
-			rank, _ := learnuplet["rank"].(float64)
-			key, _ := learnuplet["key"].(string)
-			perf, _ := learnuplet["perf"].(float64)
-			status, _ := learnuplet["status"].(string)
-			modelEnd, _ := learnuplet["modelEnd"].(string)
-
-			if int(rank) == retrievedLearnuplet.Rank+1 {
-				nextLearnupletKey = key
-			}
-			if perf > bestPerf && status == "done" {
-				newModelStart = modelEnd
-				bestPerf = perf
-			}
-		}
-		value, _ := APIstub.GetState(nextLearnupletKey)
-		nextUplet := Learnuplet{}
-		err := json.Unmarshal(value, &nextUplet)
-		if err != nil {
-			return shim.Error(fmt.Sprintf("Error Unmarshal next uplet - %s", err))
-		}
-		nextUplet.ModelStartAddress = newModelStart
 
-		// Store updated learnuplet
-		nextUpletAsBytes, err := json.Marshal(nextUplet)
-		if err != nil {
-			return shim.Error("Problem (re)marshaling next learnuplet - " + err.Error())
-		}
-		err = APIstub.PutState(nextLearnupletKey, nextUpletAsBytes)
+	// Update model start of learnuplets waiting for this one
+	retrievedLearnuplet.Status = "done"
+	err = propagateModel(APIstub, problem, getAlgoKey(retrievedLearnuplet),
+		keyedLearnuplet{upletKey, retrievedLearnuplet})
+	if err != nil {
+		return shim.Error("Problem updating next learnuplets - " + err.Error())
+	}
+	if problem.Federated {
+		err = updateRound(APIstub, problem, keyedLearnuplet{upletKey, retrievedLearnuplet})
 		if err != nil {
-			return shim.Error("Problem storing next learnuplet - " + err.Error())
+			return shim.Error("Problem updating round - " + err.Error())
 		}
-
 	}
 	fmt.Printf("- end Report learning phase of %s \n", upletKey)
 	return shim.Success(nil)
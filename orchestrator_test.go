@@ -1,375 +1,687 @@
 package main
 
-import	(
-	"testing"
-	"fmt"
-	// "bytes"
+import (
 	"encoding/json"
-	"strconv"
 	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
-	)
+	"github.com/hyperledger/fabric/protos/msp"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// testStub is a MockStub supporting the creator and the transient map of transactions, and the
+// deletion of private data, which the MockStub of Fabric 1.4 does not support
+type testStub struct {
+	*shim.MockStub
+	Creator      []byte
+	TransientMap map[string][]byte
+}
+
+// newTestStub returns a testStub of a chaincode
+func newTestStub(name string, cc shim.Chaincode) *testStub {
+	return &testStub{MockStub: shim.NewMockStub(name, cc)}
+}
+
+// GetCreator returns the creator set with setCreator
+func (stub *testStub) GetCreator() ([]byte, error) {
+	return stub.Creator, nil
+}
+
+// GetTransient returns the transient map of the next transactions
+func (stub *testStub) GetTransient() (map[string][]byte, error) {
+	return stub.TransientMap, nil
+}
+
+// DelPrivateData deletes a key from a private data collection
+func (stub *testStub) DelPrivateData(collection string, key string) error {
+	delete(stub.PvtState[collection], key)
+	return nil
+}
+
+// setCreator sets the organisation of the submitter of the next transactions
+func setCreator(t *testing.T, mockStub *testStub, org string) {
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: org, IdBytes: []byte(org + "-user")})
+	if err != nil {
+		t.Fatalf("Marshal of creator did not work - %s", err)
+	}
+	mockStub.Creator = creator
+}
+
+// getKeys returns the sorted keys of the objects of a given type in the ledger
+func getKeys(t *testing.T, mockStub *testStub, objectType string) (keys []string) {
+	resultsIterator, err := mockStub.GetStateByRange(objectType+"_", objectType+"_z")
+	if err != nil {
+		t.Fatalf("Get state by range did not work - %s", err)
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			t.Fatalf("Get state by range did not work - %s", err)
+		}
+		keys = append(keys, queryResponse.GetKey())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// registerTestProblem registers a problem with a given size of mini-batches and two test data,
+// and returns its key. Metadata and settings of the problem can be given as optional arguments.
+func registerTestProblem(t *testing.T, smartContract *SmartContract, mockStub *testStub,
+	sizeTrainDataset string, optionalArgs ...string) string {
+
+	args := []string{
+		"dda81bfc-b5f4-5ba2-b81a-b464248f02d2", // problem storage address
+		sizeTrainDataset,                       // size of learnuplets
+		"data_0, data_1",                       // test dataset
+	}
+	args = append(args, optionalArgs...)
+	response := smartContract.registerProblem(mockStub, args)
+	if s := response.GetStatus(); s != 200 {
+		t.Fatalf("the status of registerProblem is %d, instead of 200 - %s", s, response.Message)
+	}
+	problemKeys := getKeys(t, mockStub, "problem")
+	if len(problemKeys) != 1 {
+		t.Fatalf("%d problems registered instead of 1", len(problemKeys))
+	}
+	return problemKeys[0]
+}
 
 func TestRegisterItem(t *testing.T) {
 	// ARRANGE
-   	smartContract := new(SmartContract)
-   	mockStub := shim.NewMockStub("mockstub", smartContract)
-   	txId := "mockTxID"
-    itemKey := "8fa81bfc-b5f4-4ba2-b81a-b46424800000"
-   	args := []string{"data", itemKey, "problem_1",}
-
-   	// ACT
-   	mockStub.MockTransactionStart(txId)
-   	response := smartContract.registerItem(mockStub, args)
-   	mockStub.MockTransactionEnd(txId)
-
-  	// ASSERT
-   	// response
-   	if s := response.GetStatus(); s != 200 {
-      	t.Errorf("the status is %d, instead of 200", s)
-      	t.Errorf("message: %s", response.Message)
-   	}
-   	// storing in db
-   	itemAsBytes, err := mockStub.GetState("data_" + itemKey)
-   	if err != nil {
-		t.Errorf("Get state did not work")
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+	storageAddress := "8fa81bfc-b5f4-4ba2-b81a-b46424800000"
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1")
+	response := smartContract.registerItem(mockStub, []string{"data", storageAddress, problemKey, "mydata"})
+	unknownProblem := smartContract.registerItem(mockStub, []string{"data", storageAddress, "problem_unknown", "otherdata"})
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	if unknownProblem.GetStatus() == 200 {
+		t.Errorf("Item registered for an unknown problem")
 	}
+	// response
+	if s := response.GetStatus(); s != 200 {
+		t.Errorf("the status is %d, instead of 200", s)
+		t.Errorf("message: %s", response.Message)
+	}
+	// storing in db, along with the test data of the problem
 	item := Item{}
-	err = json.Unmarshal(itemAsBytes, &item)
-    if item.ObjectType != "data" || item.Problem != "problem_1" {
+	for _, key := range getKeys(t, mockStub, "data") {
+		stored := Item{}
+		json.Unmarshal(mockStub.State[key], &stored)
+		if stored.Name == "mydata" {
+			item = stored
+		}
+	}
+	if item.ObjectType != "data" || item.Problem != problemKey || item.StorageAddress != storageAddress {
 		t.Errorf("Registration of item fails")
 	}
 }
 
-func TestQueryItem(t *testing.T) {
+func TestQueryObject(t *testing.T) {
 	// ARRANGE
-    smartContract := new(SmartContract)
-    mockStub := shim.NewMockStub("mockstub", smartContract)
-    txId := "mockTxID"
-    itemKey := "8fa81bfc-b5f4-4ba2-b81a-b46424800400"
-    args := []string{"algo", itemKey, "problem_1",}
-
-    // ACT
-    mockStub.MockTransactionStart(txId)
-    // add data item
-    smartContract.registerItem(mockStub, args)
-    response := smartContract.queryItem(mockStub, []string{"algo_"+ itemKey,})
-    mockStub.MockTransactionEnd(txId)
-    // format item
-    itemQueried := Item{}
-    err := json.Unmarshal(response.GetPayload(), &itemQueried)
-    if err != nil {
-        t.Errorf("Problem wih json.Unmarshal")
-    }
-
-    // ASSERT
-    // response
-    if s := response.GetStatus(); s != 200 {
-    	t.Errorf("the status is %d, instead of 200", s)
-    	t.Errorf("message: %s", response.Message)
-    }
-    // collecting in db
-    itemAsBytes, err := mockStub.GetState("algo_" + itemKey)
-    if err != nil {
-        t.Errorf("Get state did not work")
-    }
-    item := Item{}
-    err = json.Unmarshal(itemAsBytes, &item)
-    if item.Problem != itemQueried.Problem || item.ObjectType != itemQueried.ObjectType {
-        t.Errorf("Query of item fails")
-    }
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	// add algo item
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1")
+	smartContract.registerItem(mockStub, []string{"algo", "8fa81bfc-b5f4-4ba2-b81a-b46424800400", problemKey, "myalgo"})
+	itemKey := getKeys(t, mockStub, "algo")[0]
+	response := smartContract.queryObject(mockStub, []string{itemKey})
+	mockStub.MockTransactionEnd(txId)
+	// format item
+	itemQueried := Item{}
+	err := json.Unmarshal(response.GetPayload(), &itemQueried)
+	if err != nil {
+		t.Errorf("Problem wih json.Unmarshal")
+	}
+
+	// ASSERT
+	// response
+	if s := response.GetStatus(); s != 200 {
+		t.Errorf("the status is %d, instead of 200", s)
+		t.Errorf("message: %s", response.Message)
+	}
+	// collecting in db
+	itemAsBytes, err := mockStub.GetState(itemKey)
+	if err != nil {
+		t.Errorf("Get state did not work")
+	}
+	item := Item{}
+	err = json.Unmarshal(itemAsBytes, &item)
+	if item.Problem != itemQueried.Problem || item.ObjectType != itemQueried.ObjectType {
+		t.Errorf("Query of item fails")
+	}
 }
 
 func TestRegisterProblem(t *testing.T) {
 	// ARRANGE
-   	smartContract := new(SmartContract)
-   	mockStub := shim.NewMockStub("mockstub", smartContract)
-   	txId := "mockTxID"
-    problemId := "dda81bfc-b5f4-5ba2-b81a-b464248f02d2"
-   	args := []string{
-   					problemId, // suffix of problem problemKey
-   					"2", // size of learnuplets
-   					"data_0, data_1", // test dataset
-   				}
-
-   	// ACT
-   	mockStub.MockTransactionStart(txId)
-   	// add problem
-   	response := smartContract.registerProblem(mockStub, args)
-   	mockStub.MockTransactionEnd(txId)
-
-  	// ASSERT
-   	// response
-   	if s := response.GetStatus(); s != 200 {
-      	t.Errorf("the status is %d, instead of 200", s)
-      	t.Errorf("message: %s", response.Message)
-   	}
-   	// storing in db
-   	problemAsBytes, err := mockStub.GetState("problem_"+problemId)
-   	if err != nil {
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+	problemAddress := "dda81bfc-b5f4-5ba2-b81a-b464248f02d2"
+	args := []string{
+		problemAddress,   // problem storage address
+		"2",              // size of learnuplets
+		"data_0, data_1", // test dataset
+	}
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	// add problem
+	response := smartContract.registerProblem(mockStub, args)
+	zeroSize := smartContract.registerProblem(mockStub, []string{problemAddress, "0", "data_2"})
+	negativeSize := smartContract.registerProblem(mockStub, []string{problemAddress, "-1", "data_2"})
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	// response
+	if s := response.GetStatus(); s != 200 {
+		t.Errorf("the status is %d, instead of 200", s)
+		t.Errorf("message: %s", response.Message)
+	}
+	if zeroSize.GetStatus() == 200 || negativeSize.GetStatus() == 200 {
+		t.Errorf("Problem registered with a size of learnuplets which is not strictly positive")
+	}
+	// storing in db
+	problemKeys := getKeys(t, mockStub, "problem")
+	if len(problemKeys) != 1 {
+		t.Fatalf("%d problems registered instead of 1", len(problemKeys))
+	}
+	problemAsBytes, err := mockStub.GetState(problemKeys[0])
+	if err != nil {
 		t.Errorf("Get state did not work")
 	}
 	problem := Problem{}
 	err = json.Unmarshal(problemAsBytes, &problem)
-	if problem.SizeTrainDataset != 2 || problem.TestDataset[1] != "data_1"{
+	if problem.SizeTrainDataset != 2 || len(problem.TestData) != 2 || problem.Status != "open" ||
+		problem.StorageAddress != problemAddress {
 		t.Errorf("Registration of problem fails")
 	}
+	// test data are registered as data of the problem
+	testData, _ := getProblemItems(mockStub, problemKeys[0], "data")
+	sort.Strings(problem.TestData)
+	if !reflect.DeepEqual(testData, problem.TestData) {
+		t.Errorf("Registration of test data fails")
+	}
 }
 
 func TestInitLedger(t *testing.T) {
-   // ARRANGE
-      smartContract := new(SmartContract)
-   mockStub := shim.NewMockStub("mockstub", smartContract)
-   txId := "mockTxID"
-
-    // ACT
-   mockStub.MockTransactionStart(txId)
-   response := smartContract.initLedger(mockStub)
-   mockStub.MockTransactionEnd(txId)
-
-      // ASSERT
-      // response
-      if s := response.GetStatus(); s != 200 {
-         t.Errorf("the status is %d, instead of 200", s)
-         t.Errorf("message: %s", response.Message)
-      }
-      // storing in db
-      // data
-      itemAsBytes, err := mockStub.GetState("data_0")
-      if err != nil {
-      t.Errorf("Get state did not work")
-   }
-   item := Item{}
-   err = json.Unmarshal(itemAsBytes, &item)
-   if item.Problem != "problem_0"{
-      t.Errorf("Registration/query fails")
-   }
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	response := smartContract.initLedger(mockStub)
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	// response
+	if s := response.GetStatus(); s != 200 {
+		t.Errorf("the status is %d, instead of 200", s)
+		t.Errorf("message: %s", response.Message)
+	}
+	// storing in db
+	// data
+	itemAsBytes, err := mockStub.GetState("data_0")
+	if err != nil {
+		t.Errorf("Get state did not work")
+	}
+	item := Item{}
+	err = json.Unmarshal(itemAsBytes, &item)
+	if item.Problem != "problem_0" {
+		t.Errorf("Registration/query fails")
+	}
 }
 
 func TestGetProblemItems(t *testing.T) {
 	// ARRANGE
-   	smartContract := new(SmartContract)
-   	mockStub := shim.NewMockStub("mockstub", smartContract)
-   	txId := "mockTxID"
-   	// prepare variables
-   	pbl := "problem_0"
-   	itTyp := "data"
-    id1 := "8fa81bfc-b5f4-4ba2-b81a-b46424800000"
-    id3 := "8fa81bfc-b5f4-4ba2-b81a-b46424800002"
-   	args_1 := []string{"data",
-					id1,
-   					"problem_0",}
-   	args_2 := []string{"data",
-					"8fa81bfc-b5f4-4ba2-b81a-b46424800001",
-   					"problem_1",}
-   	args_3 := []string{"data",
-					"8fa81bfc-b5f4-4ba2-b81a-b46424800002",
-   					"problem_0",}
-   	// ACT
-   	mockStub.MockTransactionStart(txId)
-   // add data items
-   	smartContract.registerItem(mockStub, args_1)
-   	smartContract.registerItem(mockStub, args_2)
-   	smartContract.registerItem(mockStub, args_3)
-   	// call the function to be tested
-   	res := getProblemItems(mockStub, pbl, itTyp)
-   	mockStub.MockTransactionEnd(txId)
-
-  	// ASSERT
-   	// response
-   	eq := reflect.DeepEqual(res, []string{id1, id3})
-   	if !eq {
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+	// prepare variables
+	pbl := "problem_0"
+	itTyp := "data"
+	args_1 := []string{"data", "8fa81bfc-b5f4-4ba2-b81a-b46424800000", "problem_0", ""}
+	args_2 := []string{"data", "8fa81bfc-b5f4-4ba2-b81a-b46424800001", "problem_1", ""}
+	args_3 := []string{"data", "8fa81bfc-b5f4-4ba2-b81a-b46424800002", "problem_0", ""}
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	// problems without test data, so that only the data registered here are associated with them
+	for _, problemKey := range []string{"problem_0", "problem_1"} {
+		storeProblem(mockStub, problemKey, Problem{ObjectType: "problem", Status: "open", SizeTrainDataset: 1})
+	}
+	// add data items
+	smartContract.registerItem(mockStub, args_1)
+	smartContract.registerItem(mockStub, args_2)
+	smartContract.registerItem(mockStub, args_3)
+	// call the function to be tested
+	res, err := getProblemItems(mockStub, pbl, itTyp)
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	if err != nil {
+		t.Errorf("getProblemItems returned an error - %s", err)
+	}
+	var addresses []string
+	for _, key := range res {
+		item := Item{}
+		itemAsBytes, _ := mockStub.GetState(key)
+		json.Unmarshal(itemAsBytes, &item)
+		addresses = append(addresses, item.StorageAddress)
+	}
+	sort.Strings(addresses)
+	eq := reflect.DeepEqual(addresses, []string{args_1[1], args_3[1]})
+	if !eq {
 		t.Errorf("getProblemItems did not work")
 	}
 }
 
-
 func TestQueryProblemItems(t *testing.T) {
 	// ARRANGE
-   	smartContract := new(SmartContract)
-   	mockStub := shim.NewMockStub("mockstub", smartContract)
-   	txId := "mockTxID"
-   	// prepqre variables
-    dataKey := "8fa81bfc-b5f4-4ba2-b81a-b46424800001"
-   	args := []string{"data", "problem_1"}
-   	args_1 := []string{"data",
-   					"8fa81bfc-b5f4-4ba2-b81a-b46424800000",
-   					"problem_0",}
-   	args_2 := []string{"data",
-                    dataKey,
-   					"problem_1",}
-
-   	// ACT
-   	mockStub.MockTransactionStart(txId)
-   	// add data items
-   	smartContract.registerItem(mockStub, args_1)
-   	smartContract.registerItem(mockStub, args_2)
-   	// call the function to be tested
-   	response := smartContract.queryProblemItems(mockStub, args)
-   	mockStub.MockTransactionEnd(txId)
-    mapQueried := map[string]string{}
-    err := json.Unmarshal(response.GetPayload(), &mapQueried)
-    if err != nil {
-        t.Errorf("Unmarshal did not work")
-    }
-    // itemQueriedAsBytes := mapQueried[dataKey]
-
-    // manually collecting in db for comparison
-    itemAsBytes, err := mockStub.GetState("data_" + dataKey)
-    if err != nil {
-        t.Errorf("Get state did not work")
-    }
-    itemDb := Item{}
-    err = json.Unmarshal(itemAsBytes, &itemDb)
-    
-  	// ASSERT
-   	if s := response.GetStatus(); s != 200 {
-      	t.Errorf("the status is %d, instead of 200", s)
-      	t.Errorf("message: %s", response.Message)
-   	}
-    fmt.Println("######################")
-    fmt.Println(itemDb)
-    fmt.Println(mapQueried)
-    fmt.Println("######################")
- //   	if data_key != itemQueried{
-	// 	t.Errorf("QueryProblemItems did not work")
-	// }
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+	// prepare variables
+	dataAddress := "8fa81bfc-b5f4-4ba2-b81a-b46424800001"
+	args := []string{"data", "problem_1"}
+	args_1 := []string{"data", "8fa81bfc-b5f4-4ba2-b81a-b46424800000", "problem_0", ""}
+	args_2 := []string{"data", dataAddress, "problem_1", ""}
 
-}
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	// problems without test data, so that only the data registered here are associated with them
+	for _, problemKey := range []string{"problem_0", "problem_1"} {
+		storeProblem(mockStub, problemKey, Problem{ObjectType: "problem", Status: "open", SizeTrainDataset: 1})
+	}
+	// add data items
+	smartContract.registerItem(mockStub, args_1)
+	smartContract.registerItem(mockStub, args_2)
+	// call the function to be tested
+	response := smartContract.queryProblemItems(mockStub, args)
+	mockStub.MockTransactionEnd(txId)
+	mapQueried := map[string]Item{}
+	err := json.Unmarshal(response.GetPayload(), &mapQueried)
+	if err != nil {
+		t.Errorf("Unmarshal did not work")
+	}
 
-func TestCreateLearnpulet(t *testing.T) {
-	// TODO check what happens when learnuplet is created without the data existing in the db
+	// ASSERT
+	if s := response.GetStatus(); s != 200 {
+		t.Errorf("the status is %d, instead of 200", s)
+		t.Errorf("message: %s", response.Message)
+	}
+	if len(mapQueried) != 1 {
+		t.Fatalf("%d items returned instead of 1", len(mapQueried))
+	}
+	for _, itemQueried := range mapQueried {
+		if itemQueried.StorageAddress != dataAddress {
+			t.Errorf("QueryProblemItems did not work")
+		}
+	}
+}
 
+func TestCreateLearnuplet(t *testing.T) {
 	// ARRANGE
-   	smartContract := new(SmartContract)
-   	mockStub := shim.NewMockStub("mockstub", smartContract)
-   	txId := "mockTxID"
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
 	// preparing variables
-   	trDataAdd := []string{"8fa81bfc-b5f4-4ba2-b81a-b46424800000",
-                          "8fa81bfc-b5f4-4ba2-b81a-b46424800001",
-	                      "8fa81bfc-b5f4-4ba2-b81a-b46424800003",
-	                      "8fa81bfc-b5f4-4ba2-b81a-b46424800005",}
-   	sz_batch := 2
-   	teDataAdd := []string{"8fa81bfc-b5f4-4ba2-b81a-b46424800002",
-	                      "8fa81bfc-b5f4-4ba2-b81a-b46424800004",}
 	pbl := "problem_dda81bfc-b5f4-5ba2-b81a-b464248f0000"
 	alg := "algo_0"
-	mdlStart := ""
+	mdlStart := "8fa81bfc-b5f4-4ba2-b81a-b464248f0001"
+	sz_batch := 2
 	strtRk := 0
-	// args_1 := []string{"data",
- //   					"bobobor",
-	// 				"https://storage.morpheo.io/data/8fa81bfc-b5f4-4ba2-b81a-b46424800000",
- //   					"problem_0",
- //   					"14991"}
- //   	args_2 := []string{"data",
- //   					"bobobor",
-	// 				"https://storage.morpheo.io/data/8fa81bfc-b5f4-4ba2-b81a-b46424800001",
- //   					"problem_0",
- //   					"14992"}
-
-   	// ACT
-   	mockStub.MockTransactionStart(txId)
-   	// initialize datatop
-   	// mockStub.PutState("datatop", []byte(strconv.Itoa(-1)))
-   	// add data item
-   	// smartContract.registerItem(mockStub, args_1)
-   	// smartContract.registerItem(mockStub, args_2)
-   	
-   	// add data item
-   	nbLearnuplets := createLearnuplet(mockStub, trDataAdd, sz_batch, teDataAdd, pbl, alg, mdlStart, strtRk)
-   	mockStub.MockTransactionEnd(txId)
-
-  	// ASSERT
-   	// check number of created learnuplets
-   	if nbLearnuplets != 2 {
-   		t.Errorf("Wrong number of created learnuplets")
-   	}
-   	// checks learnuplet_0 created in db
-   	learnupletAsBytes, err := mockStub.GetState("learnuplet_0")
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	// add data items
+	for _, address := range []string{"8fa81bfc-b5f4-4ba2-b81a-b46424800000",
+		"8fa81bfc-b5f4-4ba2-b81a-b46424800001",
+		"8fa81bfc-b5f4-4ba2-b81a-b46424800002",
+		"8fa81bfc-b5f4-4ba2-b81a-b46424800003",
+		"8fa81bfc-b5f4-4ba2-b81a-b46424800004"} {
+		storeItem(mockStub, "data_"+address, Item{ObjectType: "data", StorageAddress: address, Problem: pbl})
+	}
+	dataKeys := getKeys(t, mockStub, "data")
+	trData := dataKeys[:3]
+	teData := dataKeys[3:]
+	err := createLearnuplet(mockStub, trData, sz_batch, Batching{}, teData, pbl, "", alg, "", mdlStart, strtRk, 0, false)
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
 	if err != nil {
-		t.Errorf("Get state did not work")
+		t.Errorf("createLearnuplet returned an error - %s", err)
 	}
-	learnuplet := Learnuplet{}
-	err = json.Unmarshal(learnupletAsBytes, &learnuplet)
-	m := map[string]string{
-	"data_0": "8fa81bfc-b5f4-4ba2-b81a-b46424800000",
-	"data_1": "8fa81bfc-b5f4-4ba2-b81a-b46424800001",}
-	eq := reflect.DeepEqual(learnuplet.TrainData, m)
-   	if !eq {
-		t.Errorf("Creation of learnuplet fails")
-	}   	
-	// checks learnuplet_1 created in db
-   	learnupletAsBytes, err = mockStub.GetState("learnuplet_1")
-   	if err != nil {
-		t.Errorf("Get state did not work")
+	// check number of created learnuplets
+	learnupletKeys := getKeys(t, mockStub, "learnuplet")
+	if len(learnupletKeys) != 2 {
+		t.Fatalf("Wrong number of created learnuplets: %d", len(learnupletKeys))
 	}
-	learnuplet = Learnuplet{}
-	err = json.Unmarshal(learnupletAsBytes, &learnuplet)
-	if learnuplet.Problem != pbl {
-		t.Errorf("Creation of learnuplet fails")
+	for _, learnupletKey := range learnupletKeys {
+		learnupletAsBytes, err := mockStub.GetState(learnupletKey)
+		if err != nil {
+			t.Errorf("Get state did not work")
+		}
+		learnuplet := Learnuplet{}
+		err = json.Unmarshal(learnupletAsBytes, &learnuplet)
+		if _, ok := learnuplet.Problem[pbl]; !ok || len(learnuplet.TestData) != 2 {
+			t.Errorf("Creation of learnuplet fails")
+		}
+		// first rank starts from the given model and is trained on the first mini-batch
+		if learnuplet.Rank == 0 {
+			m := map[string]string{
+				trData[0]: "8fa81bfc-b5f4-4ba2-b81a-b46424800000",
+				trData[1]: "8fa81bfc-b5f4-4ba2-b81a-b46424800001"}
+			if !reflect.DeepEqual(learnuplet.TrainData, m) || learnuplet.ModelStartAddress != mdlStart {
+				t.Errorf("Creation of learnuplet of rank 0 fails")
+			}
+		} else if len(learnuplet.TrainData) != 1 || learnuplet.ModelStartAddress != "" {
+			t.Errorf("Creation of learnuplet of rank %d fails", learnuplet.Rank)
+		}
 	}
 }
 
 func TestAlgoLearnuplet(t *testing.T) {
 	// ARRANGE
-   	smartContract := new(SmartContract)
-   	mockStub := shim.NewMockStub("mockstub", smartContract)
-   	txId := "mockTxID"
-	// preparing variables
-	alg := Item{ObjectType: "algo", Problem: "problem_0"}
-	args_pbl := []string{
-   					"dda81bfc-b5f4-5ba2-b81a-b464248f02d2",
-   					"2", // size of learnuplets
-   					"data_0, data_1", // test dataset
-   				}
-	args_1 := []string{"data",
-					"8fa81bfc-b5f4-4ba2-b81a-b46424800000",
-   					"problem_0",}
-   	args_2 := []string{"data",
-					"8fa81bfc-b5f4-4ba2-b81a-b46424800001",
-   					"problem_0",}
-   	args_3 := []string{"data",
-					"8fa81bfc-b5f4-4ba2-b81a-b46424800002",
-   					"problem_0",}
-	args_4 := []string{"data",
-					"8fa81bfc-b5f4-4ba2-b81a-b46424800003",
-   					"problem_0",}
-
-   	// ACT
-   	mockStub.MockTransactionStart(txId)
-   	// initialize tops
-   	mockStub.PutState("problemtop", []byte(strconv.Itoa(-1)))
-   	mockStub.PutState("datatop", []byte(strconv.Itoa(-1)))
-   	// add problem and data
-   	smartContract.registerProblem(mockStub, args_pbl)
-   	smartContract.registerItem(mockStub, args_1)
-   	smartContract.registerItem(mockStub, args_2)
-   	smartContract.registerItem(mockStub, args_3)
-   	smartContract.registerItem(mockStub, args_4)
-   	// initialize learnuplettop
-   	mockStub.PutState("learnuplettop", []byte(strconv.Itoa(-1)))
-   	// add data item
-   	nbLearnuplets := algoLearnuplet(mockStub, "algo_8fa81bfc-b5f4-4ba2-b81a-b464248f02d1", alg)
-   	mockStub.MockTransactionEnd(txId)
-
-  	// ASSERT
-   	// check number of created learnuplets
-   	if nbLearnuplets != 1 {
-   		t.Errorf("Wrong number of created learnuplets")
-   	}
-   	// checks learnuplet_0 created in db
-   	learnupletAsBytes, err := mockStub.GetState("learnuplet_0")
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	// add problem and data
+	problemKey := registerTestProblem(t, smartContract, mockStub, "2")
+	for _, address := range []string{"8fa81bfc-b5f4-4ba2-b81a-b46424800000",
+		"8fa81bfc-b5f4-4ba2-b81a-b46424800001",
+		"8fa81bfc-b5f4-4ba2-b81a-b46424800002",
+		"8fa81bfc-b5f4-4ba2-b81a-b46424800003"} {
+		smartContract.registerItem(mockStub, []string{"data", address, problemKey, ""})
+	}
+	// add algo
+	algoKey := "algo_8fa81bfc-b5f4-4ba2-b81a-b464248f02d1"
+	alg := Item{ObjectType: "algo", StorageAddress: "8fa81bfc-b5f4-4ba2-b81a-b464248f02d1", Problem: problemKey}
+	storeItem(mockStub, algoKey, alg)
+	err := algoLearnuplet(mockStub, algoKey, alg)
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
 	if err != nil {
-		t.Errorf("Get state did not work")
+		t.Errorf("algoLearnuplet returned an error - %s", err)
+	}
+	// check number of created learnuplets: 4 train data in mini-batches of 2
+	_, learnuplets, _ := getCompositeLearnuplet(mockStub, "algo", algoKey)
+	if len(learnuplets) != 2 {
+		t.Fatalf("Wrong number of created learnuplets: %d", len(learnuplets))
+	}
+	for _, learnuplet := range learnuplets {
+		if trainData, _ := learnuplet["trainData"].(map[string]interface{}); len(trainData) != 2 {
+			t.Errorf("Creation of learnuplet fails")
+		}
+		if testData, _ := learnuplet["testData"].(map[string]interface{}); len(testData) != 2 {
+			t.Errorf("Creation of learnuplet fails")
+		}
+	}
+}
+
+func TestUpdateProblem(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	setCreator(t, mockStub, "OrgA")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "2")
+	for _, address := range []string{"8fa81bfc-b5f4-4ba2-b81a-b46424800000",
+		"8fa81bfc-b5f4-4ba2-b81a-b46424800001"} {
+		smartContract.registerItem(mockStub, []string{"data", address, problemKey, ""})
+	}
+	smartContract.registerItem(mockStub, []string{"algo", "8fa81bfc-b5f4-4ba2-b81a-b464248f02d1", problemKey, "myalgo"})
+	problem, _ := getProblem(mockStub, problemKey)
+	removedData := problem.TestData[0]
+	setCreator(t, mockStub, "OrgB")
+	notOwnerResponse := smartContract.updateProblem(mockStub, []string{problemKey, "1", "", removedData})
+	setCreator(t, mockStub, "OrgA")
+	response := smartContract.updateProblem(mockStub, []string{problemKey, "1", "data_2, data_3", removedData})
+	wrongResponse := smartContract.updateProblem(mockStub, []string{problemKey, "", "", "data_unknown"})
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	if s := response.GetStatus(); s != 200 {
+		t.Fatalf("the status is %d, instead of 200 - %s", s, response.Message)
+	}
+	if s := wrongResponse.GetStatus(); s == 200 {
+		t.Errorf("removing unknown test data should fail")
+	}
+	if s := notOwnerResponse.GetStatus(); s == 200 {
+		t.Errorf("problem should only be updated by its owner")
+	}
+	problem, _ = getProblem(mockStub, problemKey)
+	if problem.SizeTrainDataset != 1 || len(problem.TestData) != 3 {
+		t.Errorf("Update of problem fails")
+	}
+	for _, testData := range problem.TestData {
+		if testData == removedData {
+			t.Errorf("Removal of test data fails")
+		}
+	}
+	problemData, _ := getProblemItems(mockStub, problemKey, "data")
+	for _, data := range problemData {
+		if data == removedData {
+			t.Errorf("Removed test data is still associated with the problem")
+		}
+	}
+	if data, _ := getItem(mockStub, removedData); data.Status != "removed" {
+		t.Errorf("Removed test data has status %q instead of removed", data.Status)
+	}
+	// todo learnuplets are re-evaluated on the new test data
+	_, learnuplets, _ := getCompositeLearnuplet(mockStub, "status", "todo")
+	if len(learnuplets) == 0 {
+		t.Fatalf("No learnuplet created")
+	}
+	for _, learnuplet := range learnuplets {
+		testData, _ := learnuplet["testData"].(map[string]interface{})
+		if len(testData) != 3 {
+			t.Errorf("Test data of todo learnuplets not updated")
+		}
+	}
+}
+
+func TestSetProblemStatus(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+	algoArgs := []string{"algo", "8fa81bfc-b5f4-4ba2-b81a-b464248f02d1", "", "myalgo"}
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	setCreator(t, mockStub, "OrgA")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1")
+	smartContract.registerItem(mockStub, []string{"data", "8fa81bfc-b5f4-4ba2-b81a-b46424800000", problemKey, ""})
+	algoArgs[2] = problemKey
+	setCreator(t, mockStub, "OrgB")
+	notOwnerResponse := smartContract.setProblemStatus(mockStub, []string{problemKey, "frozen"})
+	setCreator(t, mockStub, "OrgA")
+	// frozen problems accept items but do not create learnuplets
+	frozenResponse := smartContract.setProblemStatus(mockStub, []string{problemKey, "frozen"})
+	frozenItemResponse := smartContract.registerItem(mockStub, algoArgs)
+	frozenLearnuplets := getKeys(t, mockStub, "learnuplet")
+	// problems are only archived with closeProblem
+	setArchivedResponse := smartContract.setProblemStatus(mockStub, []string{problemKey, "archived"})
+	// archived problems do not accept items and are hidden from default queries
+	archivedResponse := smartContract.closeProblem(mockStub, []string{problemKey})
+	archivedItemResponse := smartContract.registerItem(mockStub, algoArgs)
+	reopenResponse := smartContract.setProblemStatus(mockStub, []string{problemKey, "open"})
+	defaultQuery := smartContract.queryObjects(mockStub, []string{"problem"})
+	allQuery := smartContract.queryObjects(mockStub, []string{"problem", "all"})
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	if frozenResponse.GetStatus() != 200 || archivedResponse.GetStatus() != 200 {
+		t.Fatalf("Problem status update fails")
+	}
+	if setArchivedResponse.GetStatus() == 200 {
+		t.Errorf("Problem should only be archived with closeProblem")
+	}
+	if notOwnerResponse.GetStatus() == 200 {
+		t.Errorf("Problem status should only be set by its owner")
+	}
+	if frozenItemResponse.GetStatus() != 200 || len(frozenLearnuplets) != 0 {
+		t.Errorf("Frozen problem should register items without creating learnuplets")
+	}
+	if archivedItemResponse.GetStatus() == 200 {
+		t.Errorf("Archived problem should not accept new items")
+	}
+	if reopenResponse.GetStatus() == 200 {
+		t.Errorf("Archived problem should not be reopened")
+	}
+	var defaultProblems, allProblems []map[string]interface{}
+	json.Unmarshal(defaultQuery.GetPayload(), &defaultProblems)
+	json.Unmarshal(allQuery.GetPayload(), &allProblems)
+	if len(defaultProblems) != 0 || len(allProblems) != 1 {
+		t.Errorf("Archived problem should only be returned when querying all problems")
+	}
+}
+
+func TestWithdrawItem(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	setCreator(t, mockStub, "Org1MSP")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "2")
+	problem, _ := getProblem(mockStub, problemKey)
+	for _, address := range []string{"8fa81bfc-b5f4-4ba2-b81a-b46424800000",
+		"8fa81bfc-b5f4-4ba2-b81a-b46424800001",
+		"8fa81bfc-b5f4-4ba2-b81a-b46424800002",
+		"8fa81bfc-b5f4-4ba2-b81a-b46424800003"} {
+		smartContract.registerItem(mockStub, []string{"data", address, problemKey, ""})
+	}
+	smartContract.registerItem(mockStub, []string{"algo", "8fa81bfc-b5f4-4ba2-b81a-b464248f02d1", problemKey, "myalgo"})
+	algoKey := getKeys(t, mockStub, "algo")[0]
+	_, learnuplets, _ := getCompositeLearnuplet(mockStub, "algo", algoKey)
+	var dataKey string
+	for k := range learnuplets[0]["trainData"].(map[string]interface{}) {
+		dataKey = k
+	}
+	// only the owner can withdraw an item
+	setCreator(t, mockStub, "Org2MSP")
+	otherOrgResponse := smartContract.withdrawItem(mockStub, []string{dataKey, "consent revoked"})
+	setCreator(t, mockStub, "Org1MSP")
+	dataResponse := smartContract.withdrawItem(mockStub, []string{dataKey, "consent revoked"})
+	_, cancelledAfterData, _ := getCompositeLearnuplet(mockStub, "status", "cancelled")
+	testDataResponse := smartContract.withdrawItem(mockStub, []string{problem.TestData[0], "wrong labels"})
+	algoResponse := smartContract.withdrawItem(mockStub, []string{algoKey, "bug in preprocessing"})
+	againResponse := smartContract.withdrawItem(mockStub, []string{algoKey, "bug in preprocessing"})
+	_, cancelledAfterAlgo, _ := getCompositeLearnuplet(mockStub, "status", "cancelled")
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	if otherOrgResponse.GetStatus() == 200 {
+		t.Errorf("Item withdrawn by another organisation than its owner")
+	}
+	for _, response := range []sc.Response{dataResponse, testDataResponse, algoResponse} {
+		if s := response.GetStatus(); s != 200 {
+			t.Fatalf("the status is %d, instead of 200 - %s", s, response.Message)
+		}
+	}
+	if againResponse.GetStatus() == 200 {
+		t.Errorf("Item withdrawn twice")
+	}
+	// withdrawn item is kept with the reason of the withdrawal
+	itemAsBytes, _ := mockStub.GetState(dataKey)
+	item := Item{}
+	json.Unmarshal(itemAsBytes, &item)
+	if item.Status != "withdrawn" || item.WithdrawalReason != "consent revoked" || item.WithdrawalTxID != txId {
+		t.Errorf("Withdrawal of item not recorded")
+	}
+	problemData, _ := getProblemItems(mockStub, problemKey, "data")
+	for _, key := range problemData {
+		if key == dataKey || key == problem.TestData[0] {
+			t.Errorf("Withdrawn data is still associated with the problem")
+		}
+	}
+	problem, _ = getProblem(mockStub, problemKey)
+	if len(problem.TestData) != 1 {
+		t.Errorf("Withdrawn test data is still a test data of the problem")
+	}
+	// learnuplets using the withdrawn items are cancelled
+	if len(cancelledAfterData) != 1 || cancelledAfterData[0]["key"] != learnuplets[0]["key"] {
+		t.Errorf("Learnuplet training on withdrawn data not cancelled")
+	}
+	if len(cancelledAfterAlgo) != 2 {
+		t.Errorf("Learnuplets of withdrawn algo not cancelled")
+	}
+}
+
+func TestReportLearn(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	setCreator(t, mockStub, "OrgA")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1")
+	registerTestItems(t, smartContract, mockStub, problemKey, 3, "algo1")
+	registerTestWorkers(t, smartContract, mockStub, "Arbeiter_12")
+	learnupletKey := getKeys(t, mockStub, "learnuplet")[0]
+	notPending := smartContract.reportLearn(mockStub, testReport(t, mockStub, learnupletKey, "done"))
+	assignTestWorker(t, smartContract, mockStub, learnupletKey, "Arbeiter_12")
+	wrongStatus := smartContract.reportLearn(mockStub, testReport(t, mockStub, learnupletKey, "todo"))
+	setCreator(t, mockStub, "OrgB")
+	otherOrg := smartContract.reportLearn(mockStub, testReport(t, mockStub, learnupletKey, "done"))
+	setCreator(t, mockStub, "OrgA")
+	done := smartContract.reportLearn(mockStub, testReport(t, mockStub, learnupletKey, "done"))
+	// done and failed are final
+	doneAgain := smartContract.reportLearn(mockStub, testReport(t, mockStub, learnupletKey, "done"))
+	failedAfterDone := smartContract.reportLearn(mockStub, testReport(t, mockStub, learnupletKey, "failed"))
+	stats, _ := getWorkerStats(mockStub, "Arbeiter_12")
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	if notPending.GetStatus() == 200 {
+		t.Errorf("A learnuplet not assigned to a worker should not be reported")
+	}
+	if wrongStatus.GetStatus() == 200 {
+		t.Errorf("A learnuplet should only be reported done or failed")
+	}
+	if otherOrg.GetStatus() == 200 {
+		t.Errorf("A learnuplet should only be reported by the organisation of its worker")
+	}
+	if done.GetStatus() != 200 {
+		t.Fatalf("reportLearn fails - %s", done.Message)
 	}
 	learnuplet := Learnuplet{}
-	err = json.Unmarshal(learnupletAsBytes, &learnuplet)
-	m := []string{
-        "8fa81bfc-b5f4-4ba2-b81a-b46424800002", 
-        "8fa81bfc-b5f4-4ba2-b81a-b46424800003",
-    }
-	eq := reflect.DeepEqual(learnuplet.TrainData, m)
-   	if !eq {
-		t.Errorf("Creation of learnuplet fails")
-	}
-}
\ No newline at end of file
+	json.Unmarshal(mockStub.State[learnupletKey], &learnuplet)
+	if learnuplet.Status != "done" {
+		t.Errorf("Learnuplet status is %s instead of done", learnuplet.Status)
+	}
+	if doneAgain.GetStatus() == 200 || failedAfterDone.GetStatus() == 200 {
+		t.Errorf("A learnuplet should only be reported once")
+	}
+	if stats.Done != 1 || stats.Failed != 0 {
+		t.Errorf("Report counted more than once in worker statistics: %+v", stats)
+	}
+}
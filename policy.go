@@ -0,0 +1,326 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// maxPurposeLength is the maximal length of the purpose of a usage policy
+const maxPurposeLength = 256
+
+// UsagePolicy is the policy under which the owner of a data allows it to be used for training.
+// Organisations are the organisations (MSP ID) whose workers may train on the data, and AlgoOwners
+// the organisations whose algos may be trained on it, any if empty. The owner of the data is always allowed.
+// Expiry is the time (RFC 3339) after which the data is not used in new learnuplets anymore, never if empty.
+// Purpose is the use the data is shared for, recorded with each use of the data.
+type UsagePolicy struct {
+	Organisations []string `json:"organisations"`
+	AlgoOwners    []string `json:"algoOwners"`
+	Expiry        string   `json:"expiry"`
+	Purpose       string   `json:"purpose"`
+}
+
+// DataUsage records that a data is trained on by a learnuplet, and the policy of the data at that time.
+type DataUsage struct {
+	Record
+	Data       string      `json:"data"`
+	Learnuplet string      `json:"learnuplet"`
+	Algo       string      `json:"algo"`
+	Policy     UsagePolicy `json:"policy"`
+	UsedAt     string      `json:"usedAt"`
+}
+
+// parseUsagePolicy decodes and validates a usage policy given as a JSON string
+func parseUsagePolicy(policyAsString string) (policy UsagePolicy, err error) {
+	if strings.TrimSpace(policyAsString) == "" {
+		return policy, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(policyAsString)))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&policy)
+	if err != nil {
+		return policy, fmt.Errorf("invalid usage policy - %s", err)
+	}
+	for _, org := range append(append([]string{}, policy.Organisations...), policy.AlgoOwners...) {
+		if org == "" {
+			return policy, fmt.Errorf("invalid usage policy - organisations cannot be empty")
+		}
+	}
+	if policy.Expiry != "" {
+		if _, err = time.Parse(time.RFC3339, policy.Expiry); err != nil {
+			return policy, fmt.Errorf("invalid usage policy - expiry must be a RFC 3339 time - %s", err)
+		}
+	}
+	if len(policy.Purpose) > maxPurposeLength {
+		return policy, fmt.Errorf("invalid usage policy - purpose longer than %d characters", maxPurposeLength)
+	}
+	return policy, nil
+}
+
+// allows returns true if an organisation is in a list of allowed organisations,
+// an empty list allowing any organisation
+func allows(allowed []string, org string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, allowedOrg := range allowed {
+		if allowedOrg == org {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAlgo returns true if a data can be used in a new learnuplet of an algo at a given time
+func (data Item) allowsAlgo(algo Item, at time.Time) bool {
+	if data.Owner == algo.Owner {
+		return true
+	}
+	if data.Policy.Expiry != "" {
+		expiry, err := time.Parse(time.RFC3339, data.Policy.Expiry)
+		if err != nil || !at.Before(expiry) {
+			return false
+		}
+	}
+	return allows(data.Policy.AlgoOwners, algo.Owner)
+}
+
+// allowsWorker returns true if the workers of an organisation can train on a data
+func (data Item) allowsWorker(org string) bool {
+	return data.Owner == org || allows(data.Policy.Organisations, org)
+}
+
+// getItem retrieves an item (data or algo) from the ledger given its key
+func getItem(APIstub shim.ChaincodeStubInterface, itemKey string) (item Item, err error) {
+	value, err := APIstub.GetState(itemKey)
+	if err != nil {
+		return item, err
+	}
+	if value == nil {
+		return item, fmt.Errorf("no item with key %s", itemKey)
+	}
+	err = unmarshalRecord(APIstub, value, &item)
+	if err != nil {
+		return item, fmt.Errorf("Problem Unmarshal %s - %s", itemKey, err)
+	}
+	return item, nil
+}
+
+// filterAllowedData keeps the data whose usage policy allows them to be trained on by an algo,
+// in the same order
+func filterAllowedData(APIstub shim.ChaincodeStubInterface, algoKey string, data []string) ([]string, error) {
+	algo, err := getItem(APIstub, algoKey)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	var allowed []string
+	for _, dataKey := range data {
+		item, err := getItem(APIstub, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		if item.allowsAlgo(algo, txTime) {
+			allowed = append(allowed, dataKey)
+		} else {
+			fmt.Printf("-- %s not used by %s, as per its usage policy \n", dataKey, algoKey)
+		}
+	}
+	return allowed, nil
+}
+
+// checkDataPolicies checks that the workers of an organisation can train on the train data of a learnuplet.
+// Data already checked are kept in the given cache.
+func checkDataPolicies(APIstub shim.ChaincodeStubInterface, learnuplet Learnuplet, org string,
+	cache map[string]Item) error {
+
+	var dataKeys []string
+	for dataKey := range learnuplet.TrainData {
+		dataKeys = append(dataKeys, dataKey)
+	}
+	sort.Strings(dataKeys)
+	for _, dataKey := range dataKeys {
+		item, ok := cache[dataKey]
+		if !ok {
+			var err error
+			item, err = getItem(APIstub, dataKey)
+			if err != nil {
+				return err
+			}
+			cache[dataKey] = item
+		}
+		if !item.allowsWorker(org) {
+			return fmt.Errorf("usage policy of %s does not allow workers of %s", dataKey, org)
+		}
+	}
+	return nil
+}
+
+// recordDataUsage records the use of the train data of a new learnuplet, under their current usage policy,
+// with the composite key usage~data~learnuplet
+func recordDataUsage(APIstub shim.ChaincodeStubInterface, learnupletKey string, learnuplet Learnuplet) error {
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	for dataKey := range learnuplet.TrainData {
+		item, err := getItem(APIstub, dataKey)
+		if err != nil {
+			return err
+		}
+		usage := DataUsage{Data: dataKey, Learnuplet: learnupletKey, Algo: getAlgoKey(learnuplet),
+			Policy: item.Policy, UsedAt: txTime.Format(time.RFC3339)}
+		usageAsBytes, err := marshalRecord(&usage)
+		if err != nil {
+			return err
+		}
+		usageIndexKey, err := APIstub.CreateCompositeKey("usage~data~learnuplet", []string{"usage", dataKey, learnupletKey})
+		if err != nil {
+			return err
+		}
+		err = APIstub.PutState(usageIndexKey, usageAsBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setDataPolicy is the smart contract to set the usage policy of a data.
+// The policy applies to learnuplets created from now on, and to the assignment of workers.
+// Only the organisation which registered the data can set its policy.
+// Args (2 strings): dataKey, policy ("{\"organisations\": [\"OrgA\"], \"algoOwners\": [\"OrgB\"],
+// \"expiry\": \"2019-01-01T00:00:00Z\", \"purpose\": \"sleep research\"}")
+func (s *SmartContract) setDataPolicy(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2: dataKey, policy")
+	}
+	dataKey := args[0]
+	fmt.Printf("- start set usage policy of %s \n", dataKey)
+
+	policy, err := parseUsagePolicy(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	item, err := getItem(APIstub, dataKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if item.ObjectType != "data" {
+		return shim.Error(dataKey + " is not a data")
+	}
+	caller, err := getCallerOrg(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if item.Owner != "" && item.Owner != caller {
+		return shim.Error("Usage policy of " + dataKey + " can only be set by " + item.Owner)
+	}
+	item.Policy = policy
+	itemAsBytes, err := marshalRecord(&item)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(dataKey, itemAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end set usage policy of %s \n", dataKey)
+	return shim.Success(nil)
+}
+
+// queryDataUsage is the smart contract to get the learnuplets which trained on a data, with the policy
+// of the data when they were created and their current status
+// Args (1 string): dataKey
+func (s *SmartContract) queryDataUsage(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1: dataKey")
+	}
+	dataKey := args[0]
+	fmt.Printf("- start looking for usage of %s \n", dataKey)
+
+	usageIterator, err := APIstub.GetStateByPartialCompositeKey("usage~data~learnuplet", []string{"usage", dataKey})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer usageIterator.Close()
+	type statusUsage struct {
+		DataUsage
+		Status string `json:"status"`
+	}
+	var usages []statusUsage
+	for usageIterator.HasNext() {
+		responseRange, err := usageIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		usage := statusUsage{}
+		err = unmarshalRecord(APIstub, responseRange.GetValue(), &usage.DataUsage)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		value, err := APIstub.GetState(usage.Learnuplet)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		learnuplet := Learnuplet{}
+		err = unmarshalRecord(APIstub, value, &learnuplet)
+		if err != nil {
+			return shim.Error("Problem Unmarshal " + usage.Learnuplet + " - " + err.Error())
+		}
+		usage.Status = learnuplet.Status
+		usages = append(usages, usage)
+	}
+	payload, err := json.Marshal(usages)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end looking for usage of %s \n", dataKey)
+	return shim.Success(payload)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+func TestParseUsagePolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		valid  bool
+	}{
+		{``, true},
+		{`{"organisations": ["OrgA"], "algoOwners": ["OrgB"], "expiry": "2030-01-01T00:00:00Z", "purpose": "research"}`, true},
+		{`{"organisations": [""]}`, false},
+		{`{"expiry": "tomorrow"}`, false},
+		{`{"owner": "OrgA"}`, false},
+	}
+	for _, test := range tests {
+		_, err := parseUsagePolicy(test.policy)
+		if (err == nil) != test.valid {
+			t.Errorf("Validation of %s should be %t - %v", test.policy, test.valid, err)
+		}
+	}
+}
+
+func TestDataPolicies(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	tx := 0
+	// invoke calls a smart contract as an organisation in its own transaction
+	invoke := func(org string, function func(shim.ChaincodeStubInterface, []string) sc.Response, args ...string) sc.Response {
+		tx++
+		txId := fmt.Sprintf("mockTxID_%d", tx)
+		mockStub.MockTransactionStart(txId)
+		defer mockStub.MockTransactionEnd(txId)
+		setCreator(t, mockStub, org)
+		return function(mockStub, args)
+	}
+	// registerData registers a data as OrgB with a usage policy, and returns its key
+	registerData := func(address string, policy string) string {
+		before := make(map[string]bool)
+		for _, key := range getKeys(t, mockStub, "data") {
+			before[key] = true
+		}
+		response := invoke("OrgB", smartContract.registerItem, "data", address, getKeys(t, mockStub, "problem")[0], "", "", policy)
+		if response.Status != 200 {
+			t.Fatalf("Registration of data fails - %s", response.Message)
+		}
+		for _, key := range getKeys(t, mockStub, "data") {
+			if !before[key] {
+				return key
+			}
+		}
+		return ""
+	}
+	// algoData returns the train data of the learnuplets of an algo
+	algoData := func(algoKey string) map[string]string {
+		learnuplets, _ := getLearnupletsByIndex(mockStub, "algo", algoKey)
+		trainData := make(map[string]string)
+		for _, learnuplet := range learnuplets {
+			for dataKey := range learnuplet.TrainData {
+				trainData[dataKey] = learnuplet.Key
+			}
+		}
+		return trainData
+	}
+
+	// ACT
+	mockStub.MockTransactionStart("mockTxID")
+	setCreator(t, mockStub, "OrgA")
+	registerTestProblem(t, smartContract, mockStub, "10")
+	mockStub.MockTransactionEnd("mockTxID")
+	problemKey := getKeys(t, mockStub, "problem")[0]
+	invoke("OrgA", smartContract.registerItem, "algo", "8fa81bfc-b5f4-4ba2-b81a-algo1", problemKey, "algo1")
+	invoke("OrgC", smartContract.registerItem, "algo", "8fa81bfc-b5f4-4ba2-b81a-algo2", problemKey, "algo2")
+	var algoA, algoC string
+	for _, algoKey := range getKeys(t, mockStub, "algo") {
+		if algo, _ := getItem(mockStub, algoKey); algo.Owner == "OrgA" {
+			algoA = algoKey
+		} else {
+			algoC = algoKey
+		}
+	}
+	restricted := registerData("8fa81bfc-b5f4-4ba2-b81a-b46424800000",
+		`{"organisations": ["OrgA"], "algoOwners": ["OrgA"], "purpose": "sleep research"}`)
+	expired := registerData("8fa81bfc-b5f4-4ba2-b81a-b46424800001", `{"expiry": "2000-01-01T00:00:00Z"}`)
+	open := registerData("8fa81bfc-b5f4-4ba2-b81a-b46424800002", "")
+	invalidPolicy := invoke("OrgA", smartContract.registerItem, "algo", "8fa81bfc-b5f4-4ba2-b81a-algo3", problemKey, "algo3",
+		"", `{"purpose": "research"}`)
+	dataA, dataC := algoData(algoA), algoData(algoC)
+	invoke("OrgA", smartContract.registerWorker, "worker_a", `{"cpu": 4, "memory": 8000}`)
+	invoke("OrgC", smartContract.registerWorker, "worker_c", `{"cpu": 4, "memory": 8000}`)
+	wrongWorker := invoke("OrgC", smartContract.setUpletWorker, dataA[restricted], "worker_c")
+	allowedWorker := invoke("OrgA", smartContract.setUpletWorker, dataA[restricted], "worker_a")
+	wrongOwner := invoke("OrgA", smartContract.setDataPolicy, restricted, "")
+	updated := invoke("OrgB", smartContract.setDataPolicy, expired, "")
+	var usages []DataUsage
+	json.Unmarshal(invoke("OrgC", smartContract.queryDataUsage, restricted).Payload, &usages)
+
+	// ASSERT
+	if _, ok := dataA[restricted]; !ok {
+		t.Errorf("Data not used by an algo its usage policy allows")
+	}
+	if _, ok := dataC[restricted]; ok {
+		t.Errorf("Data used by an algo its usage policy does not allow")
+	}
+	if _, ok := dataA[expired]; ok {
+		t.Errorf("Data used after the expiry of its usage policy")
+	}
+	if _, ok := dataA[open]; !ok {
+		t.Errorf("Data without usage policy not used by all algos")
+	}
+	if _, ok := dataC[open]; !ok {
+		t.Errorf("Data without usage policy not used by all algos")
+	}
+	if invalidPolicy.Status == 200 {
+		t.Errorf("Algos should not have a usage policy")
+	}
+	if wrongWorker.Status == 200 || allowedWorker.Status != 200 {
+		t.Errorf("Workers not restricted by the usage policy - %s", allowedWorker.Message)
+	}
+	if wrongOwner.Status == 200 || updated.Status != 200 {
+		t.Errorf("Usage policy should only be set by the owner of the data - %s", updated.Message)
+	}
+	if item, _ := getItem(mockStub, expired); item.Policy.Expiry != "" {
+		t.Errorf("Usage policy not updated: %+v", item.Policy)
+	}
+	if len(usages) != 1 || usages[0].Learnuplet != dataA[restricted] || usages[0].Algo != algoA ||
+		usages[0].Policy.Purpose != "sleep research" || usages[0].Data != restricted {
+		t.Errorf("Usage of data not recorded as expected: %+v", usages)
+	}
+}
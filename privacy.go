@@ -0,0 +1,311 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Privacy settings of a problem.
+// Collection is the Fabric private data collection in which the storage addresses of the data of the
+// problem and the performances of models on each data are stored, the public ledger only keeping their
+// SHA-256 hash. It must be defined in the collection configuration of the chaincode.
+// Organisations are the organisations allowed to read these private fields, along with the owner of each data.
+type Privacy struct {
+	Collection    string   `json:"collection"`
+	Organisations []string `json:"organisations"`
+}
+
+// privateItem holds the private fields of a data, stored in the collection of its problem
+type privateItem struct {
+	StorageAddress string `json:"storageAddress"`
+}
+
+// privateLearnuplet holds the private fields of a learnuplet, stored in the collection of its problem
+type privateLearnuplet struct {
+	TrainPerf  map[string]float64            `json:"trainPerf"`
+	TestPerf   map[string]float64            `json:"testPerf"`
+	TrainPerfs map[string]map[string]float64 `json:"trainPerfs"`
+	TestPerfs  map[string]map[string]float64 `json:"testPerfs"`
+}
+
+var collectionRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// validatePrivacy checks the privacy settings of a problem
+func validatePrivacy(settings *ProblemSettings) error {
+	privacy := settings.Privacy
+	if privacy.Collection == "" {
+		if len(privacy.Organisations) > 0 {
+			return fmt.Errorf("organisations can only be given with a collection")
+		}
+		return nil
+	}
+	if !collectionRegexp.MatchString(privacy.Collection) {
+		return fmt.Errorf("collection must match %s", collectionRegexp.String())
+	}
+	for _, org := range privacy.Organisations {
+		if org == "" {
+			return fmt.Errorf("organisations cannot be empty")
+		}
+	}
+	return nil
+}
+
+// isPrivate returns true if data addresses and per-data performances of the problem are private
+func (problem Problem) isPrivate() bool {
+	return problem.Privacy.Collection != ""
+}
+
+// hashPrivate returns the hexadecimal SHA-256 hash of a private value, kept on the public ledger
+func hashPrivate(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+// getPrivateArgument returns an argument of a private problem, given in the transient map of the
+// transaction under a name so that it is not recorded in the transaction, or an empty string if it is
+// not given. The corresponding argument of the transaction, which is public, must be empty.
+func getPrivateArgument(APIstub shim.ChaincodeStubInterface, name string, publicArgument string) (string, error) {
+	if publicArgument != "" {
+		return "", fmt.Errorf("%s of a private problem must be given in the transient map, not as an argument", name)
+	}
+	transient, err := APIstub.GetTransient()
+	if err != nil {
+		return "", err
+	}
+	return string(transient[name]), nil
+}
+
+// storePrivateItem stores the storage address of a data of a private problem in its collection, and
+// only keeps its hash in the public item
+func storePrivateItem(APIstub shim.ChaincodeStubInterface, collection string, itemKey string, item *Item) error {
+	privateAsBytes, err := json.Marshal(privateItem{StorageAddress: item.StorageAddress})
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutPrivateData(collection, itemKey, privateAsBytes)
+	if err != nil {
+		return err
+	}
+	item.Collection = collection
+	item.StorageHash = hashPrivate([]byte(item.StorageAddress))
+	item.StorageAddress = ""
+	return nil
+}
+
+// storePrivateLearnuplet stores the per-data performances of a learnuplet of a private problem in its
+// collection, and only keeps their hash in the public learnuplet.
+// The private record is left unchanged if the learnuplet has no per-data performance.
+func storePrivateLearnuplet(APIstub shim.ChaincodeStubInterface, learnupletKey string, learnuplet *Learnuplet) error {
+	if len(learnuplet.TrainPerf) == 0 && len(learnuplet.TestPerf) == 0 &&
+		len(learnuplet.TrainPerfs) == 0 && len(learnuplet.TestPerfs) == 0 {
+		return nil
+	}
+	privateAsBytes, err := json.Marshal(privateLearnuplet{learnuplet.TrainPerf, learnuplet.TestPerf,
+		learnuplet.TrainPerfs, learnuplet.TestPerfs})
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutPrivateData(learnuplet.Collection, learnupletKey, privateAsBytes)
+	if err != nil {
+		return err
+	}
+	learnuplet.PrivateHash = hashPrivate(privateAsBytes)
+	learnuplet.TrainPerf = make(map[string]float64)
+	learnuplet.TestPerf = make(map[string]float64)
+	learnuplet.TrainPerfs = make(map[string]map[string]float64)
+	learnuplet.TestPerfs = make(map[string]map[string]float64)
+	return nil
+}
+
+// privateReader reveals the private fields of items and learnuplets to the organisations allowed to read them
+type privateReader struct {
+	APIstub  shim.ChaincodeStubInterface
+	caller   string
+	problems map[string]Problem
+}
+
+// newPrivateReader returns a reader of private fields for the caller of the transaction
+func newPrivateReader(APIstub shim.ChaincodeStubInterface) (*privateReader, error) {
+	caller, err := getCallerOrg(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	return &privateReader{APIstub, caller, make(map[string]Problem)}, nil
+}
+
+// isAllowed returns true if the caller can read private fields of a problem, or of a data it owns
+func (reader *privateReader) isAllowed(problemKey string, owner string) (bool, error) {
+	if owner != "" && owner == reader.caller {
+		return true, nil
+	}
+	problem, ok := reader.problems[problemKey]
+	if !ok {
+		var err error
+		problem, err = getProblem(reader.APIstub, problemKey)
+		if err != nil {
+			return false, err
+		}
+		reader.problems[problemKey] = problem
+	}
+	for _, org := range problem.Privacy.Organisations {
+		if org == reader.caller {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getPrivateItem returns the private fields of a data
+func (reader *privateReader) getPrivateItem(collection string, itemKey string) (private privateItem, err error) {
+	value, err := reader.APIstub.GetPrivateData(collection, itemKey)
+	if err != nil || value == nil {
+		return private, err
+	}
+	err = json.Unmarshal(value, &private)
+	return private, err
+}
+
+// revealItem sets the storage address of a private data, if the caller is allowed to read it
+func (reader *privateReader) revealItem(itemKey string, item *Item) error {
+	if item.Collection == "" {
+		return nil
+	}
+	allowed, err := reader.isAllowed(item.Problem, item.Owner)
+	if err != nil || !allowed {
+		return err
+	}
+	private, err := reader.getPrivateItem(item.Collection, itemKey)
+	if err != nil {
+		return err
+	}
+	item.StorageAddress = private.StorageAddress
+	return nil
+}
+
+// revealLearnuplet sets the addresses of the private data and the per-data performances of a learnuplet,
+// if the caller is allowed to read them
+func (reader *privateReader) revealLearnuplet(learnupletKey string, learnuplet *Learnuplet) error {
+	if learnuplet.Collection == "" {
+		return nil
+	}
+	allowed, err := reader.isAllowed(getProblemKey(*learnuplet), "")
+	if err != nil || !allowed {
+		return err
+	}
+	for _, data := range []map[string]string{learnuplet.TrainData, learnuplet.TestData} {
+		for dataKey := range data {
+			private, err := reader.getPrivateItem(learnuplet.Collection, dataKey)
+			if err != nil {
+				return err
+			}
+			if private.StorageAddress != "" {
+				data[dataKey] = private.StorageAddress
+			}
+		}
+	}
+	value, err := reader.APIstub.GetPrivateData(learnuplet.Collection, learnupletKey)
+	if err != nil || value == nil {
+		return err
+	}
+	private := privateLearnuplet{}
+	err = json.Unmarshal(value, &private)
+	if err != nil {
+		return err
+	}
+	learnuplet.TrainPerf, learnuplet.TestPerf = private.TrainPerf, private.TestPerf
+	learnuplet.TrainPerfs, learnuplet.TestPerfs = private.TrainPerfs, private.TestPerfs
+	return nil
+}
+
+// revealObject returns an object stored on the ledger, upgraded to the latest schema version, with its
+// private fields if the caller is allowed to read them.
+func (reader *privateReader) revealObject(key string, value []byte) ([]byte, error) {
+	if isRecord(value) {
+		upgraded, err := upgradeRecord(reader.APIstub, value)
+		if err != nil {
+			return nil, err
+		}
+		if upgraded != nil {
+			value = upgraded
+		}
+	}
+	var header struct {
+		ObjectType string `json:"docType"`
+		Collection string `json:"collection"`
+	}
+	if json.Unmarshal(value, &header) != nil || header.Collection == "" {
+		return value, nil
+	}
+	var err error
+	switch header.ObjectType {
+	case "data":
+		item := Item{}
+		err = json.Unmarshal(value, &item)
+		if err == nil {
+			err = reader.revealItem(key, &item)
+		}
+		if err == nil {
+			value, err = json.Marshal(item)
+		}
+	case "learnuplet":
+		learnuplet := Learnuplet{}
+		err = json.Unmarshal(value, &learnuplet)
+		if err == nil {
+			err = reader.revealLearnuplet(key, &learnuplet)
+		}
+		if err == nil {
+			value, err = json.Marshal(learnuplet)
+		}
+	}
+	return value, err
+}
+
+// revealLearnuplets reveals the private fields of a list of learnuplets
+func (reader *privateReader) revealLearnuplets(learnuplets []keyedLearnuplet) error {
+	for i := range learnuplets {
+		err := reader.revealLearnuplet(learnuplets[i].Key, &learnuplets[i].Learnuplet)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+func TestValidatePrivacy(t *testing.T) {
+	tests := []struct {
+		privacy Privacy
+		valid   bool
+	}{
+		{Privacy{}, true},
+		{Privacy{Collection: "morpheoData"}, true},
+		{Privacy{Collection: "morpheoData", Organisations: []string{"OrgA"}}, true},
+		{Privacy{Organisations: []string{"OrgA"}}, false},
+		{Privacy{Collection: "morpheo data"}, false},
+		{Privacy{Collection: "morpheoData", Organisations: []string{""}}, false},
+	}
+	for _, test := range tests {
+		settings := ProblemSettings{Privacy: test.privacy}
+		err := validatePrivacy(&settings)
+		if (err == nil) != test.valid {
+			t.Errorf("Validation of %+v should be %t - %v", test.privacy, test.valid, err)
+		}
+	}
+}
+
+func TestPrivateData(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	collection := "morpheoData"
+	tx := 0
+	// invoke calls a smart contract as an organisation in its own transaction, with a transient map
+	invoke := func(org string, transient map[string]string, function func(shim.ChaincodeStubInterface, []string) sc.Response,
+		args ...string) sc.Response {
+		tx++
+		txId := fmt.Sprintf("mockTxID_%d", tx)
+		mockStub.MockTransactionStart(txId)
+		defer mockStub.MockTransactionEnd(txId)
+		setCreator(t, mockStub, org)
+		mockStub.TransientMap = make(map[string][]byte)
+		for name, value := range transient {
+			mockStub.TransientMap[name] = []byte(value)
+		}
+		return function(mockStub, args)
+	}
+	queryItem := func(org string, key string) Item {
+		item := Item{}
+		json.Unmarshal(invoke(org, nil, smartContract.queryObject, key).Payload, &item)
+		return item
+	}
+	queryLearnuplet := func(org string, key string) Learnuplet {
+		learnuplet := Learnuplet{}
+		json.Unmarshal(invoke(org, nil, smartContract.queryObject, key).Payload, &learnuplet)
+		return learnuplet
+	}
+	settings := `{"privacy": {"collection": "` + collection + `", "organisations": ["OrgA"]}}`
+	testAddress := "8fa81bfc-b5f4-4ba2-b81a-b46424800100"
+	dataAddress := "8fa81bfc-b5f4-4ba2-b81a-b46424800000"
+
+	// ACT
+	publicProblem := invoke("OrgA", map[string]string{"testDataAddresses": testAddress}, smartContract.registerProblem,
+		"dda81bfc-b5f4-5ba2-b81a-b464248f02d2", "1", testAddress, "", settings)
+	registered := invoke("OrgA", map[string]string{"testDataAddresses": testAddress}, smartContract.registerProblem,
+		"dda81bfc-b5f4-5ba2-b81a-b464248f02d2", "1", "", "", settings)
+	problemKey := getKeys(t, mockStub, "problem")[0]
+	publicData := invoke("OrgB", nil, smartContract.registerItem, "data", dataAddress, problemKey, "")
+	missingData := invoke("OrgB", nil, smartContract.registerItem, "data", "", problemKey, "")
+	privateData := invoke("OrgB", map[string]string{"storageAddress": dataAddress}, smartContract.registerItem,
+		"data", "", problemKey, "")
+	invoke("OrgA", nil, smartContract.registerItem, "algo", "8fa81bfc-b5f4-4ba2-b81a-algo", problemKey, "algo")
+	learnupletKey := getKeys(t, mockStub, "learnuplet")[0]
+	var trainKey, testKey string
+	for dataKey := range queryLearnuplet("OrgC", learnupletKey).TrainData {
+		trainKey = dataKey
+	}
+	for dataKey := range queryLearnuplet("OrgC", learnupletKey).TestData {
+		testKey = dataKey
+	}
+	invoke("OrgA", nil, smartContract.registerWorker, "worker_a", `{"cpu": 4, "memory": 8000}`)
+	claimed := keyedLearnuplet{}
+	json.Unmarshal(invoke("OrgA", nil, smartContract.claimNextLearnuplet, "worker_a").Payload, &claimed)
+	dataPerfs := map[string]string{"trainPerf": `{"` + trainKey + `": 0.8}`, "testPerf": `{"` + testKey + `": 0.9}`}
+	publicPerfs := invoke("OrgA", dataPerfs, smartContract.reportLearn, learnupletKey, "done", "0.9",
+		dataPerfs["trainPerf"], dataPerfs["testPerf"])
+	reported := invoke("OrgA", dataPerfs, smartContract.reportLearn, learnupletKey, "done", "0.9", "", "")
+	withdrawn := invoke("OrgB", nil, smartContract.withdrawItem, trainKey, "consent revoked")
+
+	// ASSERT
+	if publicProblem.Status == 200 || publicData.Status == 200 || missingData.Status == 200 {
+		t.Errorf("Addresses of data of a private problem should only be given in the transient map")
+	}
+	if publicPerfs.Status == 200 {
+		t.Errorf("Performances on data of a private problem should only be given in the transient map")
+	}
+	if registered.Status != 200 || privateData.Status != 200 || reported.Status != 200 || withdrawn.Status != 200 {
+		t.Fatalf("Private problem fails - %s%s%s%s", registered.Message, privateData.Message, reported.Message, withdrawn.Message)
+	}
+	// only hashes are on the public ledger
+	public := Item{}
+	json.Unmarshal(mockStub.State[testKey], &public)
+	if public.StorageAddress != "" || public.StorageHash != hashPrivate([]byte(testAddress)) || public.Collection != collection {
+		t.Errorf("Test data stored publicly: %+v", public)
+	}
+	if string(mockStub.PvtState[collection][testKey]) != `{"storageAddress":"`+testAddress+`"}` {
+		t.Errorf("Address of test data not stored in the collection: %s", mockStub.PvtState[collection][testKey])
+	}
+	stored := Learnuplet{}
+	json.Unmarshal(mockStub.State[learnupletKey], &stored)
+	if stored.TrainData[trainKey] != "" || len(stored.TrainPerf) != 0 || len(stored.TestPerfs) != 0 || stored.Perf != 0.9 ||
+		stored.PrivateHash != hashPrivate(mockStub.PvtState[collection][learnupletKey]) {
+		t.Errorf("Per-data performances stored publicly: %+v", stored)
+	}
+	// claims are recorded in the block, and only return public fields
+	if claimed.Key != learnupletKey || claimed.TestData[testKey] != "" {
+		t.Errorf("Private fields of learnuplet returned by a claim: %+v", claimed)
+	}
+	// private fields are only returned to allowed organisations and owners of data
+	if item := queryItem("OrgA", testKey); item.StorageAddress != testAddress {
+		t.Errorf("Address of test data not returned to an allowed organisation: %+v", item)
+	}
+	if item := queryItem("OrgC", testKey); item.StorageAddress != "" {
+		t.Errorf("Address of test data returned to another organisation: %+v", item)
+	}
+	if learnuplet := queryLearnuplet("OrgA", learnupletKey); learnuplet.TestData[testKey] != testAddress ||
+		learnuplet.TrainPerf[trainKey] != 0.8 || learnuplet.TestPerf[testKey] != 0.9 {
+		t.Errorf("Private fields of learnuplet not returned to an allowed organisation: %+v", learnuplet)
+	}
+	if learnuplet := queryLearnuplet("OrgB", learnupletKey); learnuplet.TestData[testKey] != "" || len(learnuplet.TestPerf) != 0 {
+		t.Errorf("Private fields of learnuplet returned to another organisation: %+v", learnuplet)
+	}
+	// the address of withdrawn data is erased from the collection
+	if _, ok := mockStub.PvtState[collection][trainKey]; ok {
+		t.Errorf("Address of withdrawn data not erased from the collection")
+	}
+}
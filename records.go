@@ -0,0 +1,266 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Record is embedded in the structures stored in the ledger: SchemaVersion is the schema version
+// (see Schema) of the chaincode which wrote the record, 0 for records written before records were versioned.
+// Records are upgraded to the latest schema version when read (see unmarshalRecord).
+type Record struct {
+	SchemaVersion int `json:"schemaVersion"`
+}
+
+// setSchemaVersion sets the schema version of a record
+func (record *Record) setSchemaVersion(version int) {
+	record.SchemaVersion = version
+}
+
+// versionedRecord is a structure stored in the ledger, embedding Record
+type versionedRecord interface {
+	setSchemaVersion(version int)
+}
+
+// compositeKeyNamespace is the first character of composite keys
+const compositeKeyNamespace = "\x00"
+
+// recordIndexes are the composite key indexes whose values are records, and not only markers
+var recordIndexes = []string{"contribution~owner~key", "credit~owner", "datavalue~problem~data", "usage~data~learnuplet"}
+
+// marshalRecord encodes a record stamped with the latest schema version
+func marshalRecord(record versionedRecord) ([]byte, error) {
+	record.setSchemaVersion(latestSchemaVersion())
+	return json.Marshal(record)
+}
+
+// unmarshalRecord decodes a record, upgraded to the latest schema version, into a structure or a map
+func unmarshalRecord(APIstub shim.ChaincodeStubInterface, value []byte, record interface{}) error {
+	upgraded, err := upgradeRecord(APIstub, value)
+	if err != nil {
+		return err
+	}
+	if upgraded != nil {
+		value = upgraded
+	}
+	return json.Unmarshal(value, record)
+}
+
+// upgradeRecord applies to a record the migrations of its type released after its schema version, and
+// stamps it with the latest schema version. Returns nil if the record is up to date.
+func upgradeRecord(APIstub shim.ChaincodeStubInterface, value []byte) ([]byte, error) {
+	header := struct {
+		ObjectType    string `json:"docType"`
+		SchemaVersion int    `json:"schemaVersion"`
+	}{}
+	err := json.Unmarshal(value, &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.SchemaVersion < 0 {
+		return nil, fmt.Errorf("invalid schema version %d", header.SchemaVersion)
+	}
+	if header.SchemaVersion >= latestSchemaVersion() {
+		return nil, nil
+	}
+	for _, m := range migrations[header.SchemaVersion:] {
+		if m.objectType != header.ObjectType {
+			continue
+		}
+		migrated, err := m.migrate(APIstub, value)
+		if err != nil {
+			return nil, err
+		}
+		if migrated != nil {
+			value = migrated
+		}
+	}
+	fields := make(map[string]json.RawMessage)
+	err = json.Unmarshal(value, &fields)
+	if err != nil {
+		return nil, err
+	}
+	fields["schemaVersion"] = json.RawMessage(strconv.Itoa(latestSchemaVersion()))
+	return json.Marshal(fields)
+}
+
+// isRecord returns true if a value stored in the ledger is a record (a JSON object)
+func isRecord(value []byte) bool {
+	return len(value) > 0 && value[0] == '{'
+}
+
+// migrationProgress is the result of migrateObjects: the numbers of records scanned and rewritten,
+// and the bookmark to give to the next call, empty once all records are up to date
+type migrationProgress struct {
+	Scanned  int    `json:"scanned"`
+	Migrated int    `json:"migrated"`
+	Bookmark string `json:"bookmark"`
+}
+
+// migrateObjects is the smart contract to rewrite the records of the ledger written with an older schema
+// version, in chunks of at most limit records so that each transaction stays small. Records with a simple
+// key are scanned first in key order, then the records of each index of recordIndexes. The returned
+// bookmark is given to the next call to resume the migration, until it is empty.
+// Only callable by admin organisations (see Config)
+// Args (1 or 2 strings): limit, optionally bookmark
+func (s *SmartContract) migrateObjects(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	limit, bookmark, err := parseChunkArgs(args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- start migrating %d objects from %q \n", limit, bookmark)
+
+	if _, _, err = getAdminConfig(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+	progress, err := migrateChunk(APIstub, limit, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	payload, err := json.Marshal(progress)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end migrating objects, %d migrated \n", progress.Migrated)
+	return shim.Success(payload)
+}
+
+// migrateChunk upgrades at most limit records from a bookmark (see scanLedger)
+func migrateChunk(APIstub shim.ChaincodeStubInterface, limit int, bookmark string) (progress migrationProgress, err error) {
+	progress.Scanned, progress.Bookmark, err = scanLedger(APIstub, recordIndexes, limit, bookmark,
+		func(key string, value []byte) error {
+			if !isRecord(value) {
+				return nil
+			}
+			upgraded, err := upgradeRecord(APIstub, value)
+			if err != nil {
+				return fmt.Errorf("Problem upgrading %q - %s", key, err)
+			}
+			if upgraded == nil {
+				return nil
+			}
+			progress.Migrated++
+			return APIstub.PutState(key, upgraded)
+		})
+	return progress, err
+}
+
+// parseChunkArgs parses the arguments of a contract processing the ledger in chunks: limit, optionally bookmark
+func parseChunkArgs(args []string) (limit int, bookmark string, err error) {
+	if len(args) != 1 && len(args) != 2 {
+		return limit, bookmark, fmt.Errorf("Incorrect number of arguments. Expecting 1 or 2: limit, optionally bookmark")
+	}
+	limit, err = strconv.Atoi(args[0])
+	if err != nil || limit <= 0 {
+		return limit, bookmark, fmt.Errorf("limit must be a strictly positive integer")
+	}
+	if len(args) == 2 {
+		bookmark = args[1]
+	}
+	return limit, bookmark, nil
+}
+
+// scanLedger visits at most limit entries of the ledger from a bookmark, the key of the first entry to
+// visit: entries with a simple key in key order, then the entries of each of the composite key indexes.
+// Returns the number of entries visited, and the bookmark of the next entry, empty once all are visited.
+func scanLedger(APIstub shim.ChaincodeStubInterface, indexes []string, limit int, bookmark string,
+	visit func(key string, value []byte) error) (scanned int, next string, err error) {
+
+	// scan visits the entries of an iterator from a key, and returns false once limit entries are visited
+	scan := func(resultsIterator shim.StateQueryIteratorInterface, from string) (bool, error) {
+		defer resultsIterator.Close()
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				return false, err
+			}
+			key := queryResponse.GetKey()
+			if key < from {
+				continue
+			}
+			if scanned == limit {
+				next = key
+				return false, nil
+			}
+			scanned++
+			if err = visit(key, queryResponse.GetValue()); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	firstIndex := 0
+	if strings.HasPrefix(bookmark, compositeKeyNamespace) {
+		index, _, err := APIstub.SplitCompositeKey(bookmark)
+		if err != nil {
+			return scanned, next, err
+		}
+		for firstIndex < len(indexes) && indexes[firstIndex] != index {
+			firstIndex++
+		}
+		if firstIndex == len(indexes) {
+			return scanned, next, fmt.Errorf("invalid bookmark, %s is not a scanned index", index)
+		}
+	} else {
+		resultsIterator, err := APIstub.GetStateByRange(bookmark, "")
+		if err != nil {
+			return scanned, next, err
+		}
+		if done, err := scan(resultsIterator, bookmark); err != nil || !done {
+			return scanned, next, err
+		}
+		bookmark = ""
+	}
+	for _, index := range indexes[firstIndex:] {
+		resultsIterator, err := APIstub.GetStateByPartialCompositeKey(index, []string{})
+		if err != nil {
+			return scanned, next, err
+		}
+		if done, err := scan(resultsIterator, bookmark); err != nil || !done {
+			return scanned, next, err
+		}
+		bookmark = ""
+	}
+	return scanned, next, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestUpgradeRecord(t *testing.T) {
+	mockStub := newTestStub("mockstub", new(SmartContract))
+	upgraded, err := upgradeRecord(mockStub, []byte(`{"docType": "problem", "sizeTrainDataset": 2}`))
+	problem := Problem{}
+	json.Unmarshal(upgraded, &problem)
+	if err != nil || problem.Status != "open" || problem.SizeTrainDataset != 2 || problem.SchemaVersion != latestSchemaVersion() {
+		t.Errorf("Problem not upgraded as expected: %+v - %v", problem, err)
+	}
+	worker := Worker{}
+	err = unmarshalRecord(mockStub, []byte(`{"docType": "worker", "owner": "OrgA"}`), &worker)
+	if err != nil || worker.Owner != "OrgA" || worker.SchemaVersion != latestSchemaVersion() {
+		t.Errorf("Record without migration not stamped: %+v - %v", worker, err)
+	}
+	config := Config{}
+	err = unmarshalRecord(mockStub, []byte(`{"docType": "config", "claimWindow": 4}`), &config)
+	if err != nil || config.MaxRetries != 3 || config.ClaimWindow != 4 {
+		t.Errorf("Configuration not upgraded as expected: %+v - %v", config, err)
+	}
+	upToDate, _ := marshalRecord(&problem)
+	if upgraded, err := upgradeRecord(mockStub, upToDate); upgraded != nil || err != nil {
+		t.Errorf("Up to date record upgraded: %s - %v", upgraded, err)
+	}
+	if _, err := upgradeRecord(mockStub, []byte{0x00}); err == nil {
+		t.Errorf("Values which are not records should not be upgraded")
+	}
+	if _, err := upgradeRecord(mockStub, []byte(`{"docType": "problem", "schemaVersion": -1}`)); err == nil {
+		t.Errorf("Records with a negative schema version should not be upgraded")
+	}
+}
+
+func TestMigrateObjects(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	mockStub.MockInit("mockTxID_init", [][]byte{[]byte("init"), []byte("fresh"), []byte("OrgA")})
+	// records written before records were versioned, and index markers
+	mockStub.MockTransactionStart("mockTxID")
+	for i := 0; i < 3; i++ {
+		mockStub.PutState(fmt.Sprintf("problem_%d", i), []byte(`{"docType": "problem", "sizeTrainDataset": 2}`))
+	}
+	mockStub.PutState("worker_a", []byte(`{"docType": "worker", "owner": "OrgA"}`))
+	balanceKey, _ := getBalanceKey(mockStub, "OrgB")
+	mockStub.PutState(balanceKey, []byte(`{"docType": "balance", "owner": "OrgB", "total": 3}`))
+	contributionKey, _ := mockStub.CreateCompositeKey("contribution~owner~key", []string{"contribution", "OrgB", "learnuplet_0", "data", "data_0"})
+	mockStub.PutState(contributionKey, []byte(`{"owner": "OrgB", "credits": 3}`))
+	indexKey, _ := mockStub.CreateCompositeKey("data~problem~key", []string{"data", "problem_0", "data_0"})
+	mockStub.PutState(indexKey, []byte{0x00})
+	mockStub.MockTransactionEnd("mockTxID")
+	tx := 0
+	migrate := func(org string, args ...string) (progress migrationProgress, status int32) {
+		tx++
+		txId := fmt.Sprintf("mockTxID_%d", tx)
+		mockStub.MockTransactionStart(txId)
+		defer mockStub.MockTransactionEnd(txId)
+		setCreator(t, mockStub, org)
+		response := smartContract.migrateObjects(mockStub, args)
+		json.Unmarshal(response.Payload, &progress)
+		return progress, response.Status
+	}
+
+	// ACT
+	_, notAdmin := migrate("OrgB", "2")
+	_, invalidLimit := migrate("OrgA", "0")
+	var chunks []migrationProgress
+	progress, status := migrate("OrgA", "2")
+	for ; status == shim.OK && len(chunks) < 10; progress, status = migrate("OrgA", "2", progress.Bookmark) {
+		chunks = append(chunks, progress)
+		if progress.Bookmark == "" {
+			break
+		}
+	}
+	again, _ := migrate("OrgA", "100")
+
+	// ASSERT
+	if notAdmin == shim.OK || invalidLimit == shim.OK {
+		t.Errorf("Objects should only be migrated by admins, in chunks of a positive size")
+	}
+	if status != shim.OK || len(chunks) == 0 || chunks[len(chunks)-1].Bookmark != "" {
+		t.Fatalf("Migration did not complete: %+v", chunks)
+	}
+	migrated := 0
+	for _, chunk := range chunks {
+		if chunk.Scanned > 2 {
+			t.Errorf("Chunk larger than its limit: %+v", chunk)
+		}
+		migrated += chunk.Migrated
+	}
+	// 3 problems, the worker, the balance and the contribution
+	if migrated != 6 || again.Migrated != 0 {
+		t.Errorf("%d objects migrated instead of 6, then %d instead of 0", migrated, again.Migrated)
+	}
+	for _, key := range []string{"problem_0", "problem_2", "worker_a", balanceKey, contributionKey} {
+		var record map[string]interface{}
+		json.Unmarshal(mockStub.State[key], &record)
+		if record["schemaVersion"] != float64(latestSchemaVersion()) {
+			t.Errorf("%q not stamped with the latest schema version: %s", key, mockStub.State[key])
+		}
+	}
+	if problem := (Problem{}); json.Unmarshal(mockStub.State["problem_1"], &problem) != nil || problem.Status != "open" {
+		t.Errorf("Problem not migrated: %s", mockStub.State["problem_1"])
+	}
+	if string(mockStub.State[indexKey]) != "\x00" {
+		t.Errorf("Index marker changed by the migration")
+	}
+}
@@ -0,0 +1,321 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Roles of the contributors to a learnuplet
+const (
+	dataRole   = "data"
+	algoRole   = "algo"
+	workerRole = "worker"
+)
+
+// RewardRule defines the credits earned by the contributors of each done learnuplet of a problem:
+// Data credits for the owner of each train data, Algo credits for the owner of the algo, and
+// Worker credits for the organisation of the worker. By default, each contribution earns 1 credit.
+type RewardRule struct {
+	Data   int `json:"data"`
+	Algo   int `json:"algo"`
+	Worker int `json:"worker"`
+}
+
+// defaultRewardRule is the reward rule of problems which do not define one
+var defaultRewardRule = RewardRule{Data: 1, Algo: 1, Worker: 1}
+
+// Contribution records the credits earned by an organisation for a done learnuplet, or paid out from
+// the bounty of a problem (see Escrow).
+// Role is data, algo or worker, and Item the key of the data or algo, or the worker identifier.
+// For bounties, Learnuplet is empty, Role is bounty or bountyData for payouts, escrow for the negative
+// credits of the owner of the problem held in escrow, or refund, and Item is the key of the escrow.
+type Contribution struct {
+	Record
+	Owner      string `json:"owner"`
+	Problem    string `json:"problem"`
+	Learnuplet string `json:"learnuplet"`
+	Role       string `json:"role"`
+	Item       string `json:"item"`
+	Credits    int    `json:"credits"`
+	CreditedAt string `json:"creditedAt"`
+}
+
+// Balance is the total of the credits earned by an organisation, and their split by problem.
+type Balance struct {
+	Record
+	ObjectType string         `json:"docType"`
+	Owner      string         `json:"owner"`
+	Total      int            `json:"total"`
+	Problems   map[string]int `json:"problems"`
+}
+
+// validateRewards checks the reward rule of problem settings, and sets the default one
+func validateRewards(settings *ProblemSettings) error {
+	rule := settings.Rewards
+	if rule.Data < 0 || rule.Algo < 0 || rule.Worker < 0 {
+		return fmt.Errorf("rewards cannot be negative")
+	}
+	if rule == (RewardRule{}) {
+		settings.Rewards = defaultRewardRule
+	}
+	return nil
+}
+
+// getRewardRule returns the reward rule of a problem, the default one if it does not define one
+func (problem Problem) getRewardRule() RewardRule {
+	if problem.Rewards == (RewardRule{}) {
+		return defaultRewardRule
+	}
+	return problem.Rewards
+}
+
+// getContributions returns the contributions to a done learnuplet according to the reward rule of its problem:
+// the owners of its train data, of its algo, and of its worker, if they are known
+func getContributions(APIstub shim.ChaincodeStubInterface, problem Problem, learnupletKey string,
+	learnuplet Learnuplet) ([]Contribution, error) {
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	rule := problem.getRewardRule()
+	problemKey := getProblemKey(learnuplet)
+	contribution := func(owner string, role string, item string, credits int) Contribution {
+		return Contribution{Owner: owner, Problem: problemKey, Learnuplet: learnupletKey, Role: role,
+			Item: item, Credits: credits, CreditedAt: txTime.Format(time.RFC3339)}
+	}
+	var contributions []Contribution
+	var dataKeys []string
+	for dataKey := range learnuplet.TrainData {
+		dataKeys = append(dataKeys, dataKey)
+	}
+	sort.Strings(dataKeys)
+	for _, dataKey := range dataKeys {
+		data, err := getItem(APIstub, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		contributions = append(contributions, contribution(data.Owner, dataRole, dataKey, rule.Data))
+	}
+	algoKey := getAlgoKey(learnuplet)
+	algo, err := getItem(APIstub, algoKey)
+	if err != nil {
+		return nil, err
+	}
+	contributions = append(contributions, contribution(algo.Owner, algoRole, algoKey, rule.Algo))
+	// learnuplets reported without a registered worker do not credit it
+	if worker, err := getWorker(APIstub, learnuplet.Worker); err == nil {
+		contributions = append(contributions, contribution(worker.Owner, workerRole, learnuplet.Worker, rule.Worker))
+	}
+	return contributions, nil
+}
+
+// creditContributors credits the contributors of a done learnuplet
+func creditContributors(APIstub shim.ChaincodeStubInterface, problem Problem, learnupletKey string,
+	learnuplet Learnuplet) error {
+
+	contributions, err := getContributions(APIstub, problem, learnupletKey, learnuplet)
+	if err != nil {
+		return err
+	}
+	return recordContributions(APIstub, learnupletKey, contributions)
+}
+
+// recordContributions records contributions earning credits from a source (a learnuplet or an escrow)
+// under the composite key contribution~owner~key, and updates the balances of their owners under the
+// composite key credit~owner
+func recordContributions(APIstub shim.ChaincodeStubInterface, source string, contributions []Contribution) error {
+	// balances are updated once per owner, since the ledger does not read its own writes
+	balances := make(map[string]Balance)
+	var owners []string
+	for _, contribution := range contributions {
+		balance, ok := balances[contribution.Owner]
+		if !ok {
+			var err error
+			balance, err = getBalance(APIstub, contribution.Owner)
+			if err != nil {
+				return err
+			}
+			owners = append(owners, contribution.Owner)
+		}
+		balance.Total += contribution.Credits
+		balance.Problems[contribution.Problem] += contribution.Credits
+		balances[contribution.Owner] = balance
+		contributionKey, err := APIstub.CreateCompositeKey("contribution~owner~key",
+			[]string{"contribution", contribution.Owner, source, contribution.Role, contribution.Item})
+		if err != nil {
+			return err
+		}
+		contributionAsBytes, err := marshalRecord(&contribution)
+		if err != nil {
+			return err
+		}
+		err = APIstub.PutState(contributionKey, contributionAsBytes)
+		if err != nil {
+			return err
+		}
+	}
+	sort.Strings(owners)
+	for _, owner := range owners {
+		err := storeBalance(APIstub, balances[owner])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getBalanceKey returns the key of the balance of an organisation
+func getBalanceKey(APIstub shim.ChaincodeStubInterface, owner string) (string, error) {
+	return APIstub.CreateCompositeKey("credit~owner", []string{"credit", owner})
+}
+
+// getBalance retrieves the balance of an organisation, empty if it has not earned credits yet
+func getBalance(APIstub shim.ChaincodeStubInterface, owner string) (Balance, error) {
+	balance := Balance{ObjectType: "balance", Owner: owner, Problems: make(map[string]int)}
+	balanceKey, err := getBalanceKey(APIstub, owner)
+	if err != nil {
+		return balance, err
+	}
+	value, err := APIstub.GetState(balanceKey)
+	if err != nil || value == nil {
+		return balance, err
+	}
+	err = unmarshalRecord(APIstub, value, &balance)
+	if balance.Problems == nil {
+		balance.Problems = make(map[string]int)
+	}
+	return balance, err
+}
+
+// storeBalance stores the balance of an organisation
+func storeBalance(APIstub shim.ChaincodeStubInterface, balance Balance) error {
+	balanceKey, err := getBalanceKey(APIstub, balance.Owner)
+	if err != nil {
+		return err
+	}
+	balanceAsBytes, err := marshalRecord(&balance)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(balanceKey, balanceAsBytes)
+}
+
+// queryBalance is the smart contract to get the credits earned by an organisation
+// Args (0 or 1 string): optionally the organisation (MSP ID), the one of the caller by default
+func (s *SmartContract) queryBalance(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) > 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 0 or 1: optionally organisation")
+	}
+	owner, err := getOwnerArgument(APIstub, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- start looking for balance of %s \n", owner)
+
+	balance, err := getBalance(APIstub, owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	payload, err := json.Marshal(balance)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end looking for balance of %s \n", owner)
+	return shim.Success(payload)
+}
+
+// queryContributions is the smart contract to get the contributions for which an organisation was credited
+// Args (0 to 2 strings): optionally the organisation (MSP ID), the one of the caller by default,
+// optionally a problem key to only get the contributions to this problem
+func (s *SmartContract) queryContributions(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 0 to 2: optionally organisation and problemKey")
+	}
+	owner, err := getOwnerArgument(APIstub, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	problemKey := ""
+	if len(args) == 2 {
+		problemKey = args[1]
+	}
+	fmt.Printf("- start looking for contributions of %s \n", owner)
+
+	contributionIterator, err := APIstub.GetStateByPartialCompositeKey("contribution~owner~key", []string{"contribution", owner})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer contributionIterator.Close()
+	var contributions []Contribution
+	for contributionIterator.HasNext() {
+		responseRange, err := contributionIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		contribution := Contribution{}
+		err = unmarshalRecord(APIstub, responseRange.GetValue(), &contribution)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if problemKey != "" && contribution.Problem != problemKey {
+			continue
+		}
+		contributions = append(contributions, contribution)
+	}
+	payload, err := json.Marshal(contributions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end looking for contributions of %s \n", owner)
+	return shim.Success(payload)
+}
+
+// getOwnerArgument returns the organisation given as first argument, or the one of the caller if it is empty
+func getOwnerArgument(APIstub shim.ChaincodeStubInterface, args []string) (string, error) {
+	if len(args) > 0 && args[0] != "" {
+		return args[0], nil
+	}
+	return getCallerOrg(APIstub)
+}
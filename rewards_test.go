@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+func TestValidateRewards(t *testing.T) {
+	settings := ProblemSettings{}
+	if err := validateRewards(&settings); err != nil || settings.Rewards != defaultRewardRule {
+		t.Errorf("Default reward rule not set: %+v - %v", settings.Rewards, err)
+	}
+	settings = ProblemSettings{Rewards: RewardRule{Data: 2}}
+	if err := validateRewards(&settings); err != nil || settings.Rewards != (RewardRule{Data: 2}) {
+		t.Errorf("Reward rule changed: %+v - %v", settings.Rewards, err)
+	}
+	settings = ProblemSettings{Rewards: RewardRule{Algo: -1}}
+	if err := validateRewards(&settings); err == nil {
+		t.Errorf("Negative rewards should be rejected")
+	}
+	if rule := (Problem{}).getRewardRule(); rule != defaultRewardRule {
+		t.Errorf("Problems without reward rule should use the default one, not %+v", rule)
+	}
+}
+
+func TestCreditContributors(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	tx := 0
+	// invoke calls a smart contract as an organisation in its own transaction
+	invoke := func(org string, function func(shim.ChaincodeStubInterface, []string) sc.Response, args ...string) sc.Response {
+		tx++
+		txId := fmt.Sprintf("mockTxID_%d", tx)
+		mockStub.MockTransactionStart(txId)
+		defer mockStub.MockTransactionEnd(txId)
+		setCreator(t, mockStub, org)
+		return function(mockStub, args)
+	}
+	balance := func(org string) Balance {
+		balance := Balance{}
+		json.Unmarshal(invoke(org, smartContract.queryBalance).Payload, &balance)
+		return balance
+	}
+
+	// ACT
+	mockStub.MockTransactionStart("mockTxID")
+	setCreator(t, mockStub, "OrgA")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "2", "", `{"rewards": {"data": 2, "algo": 5, "worker": 1}}`)
+	registerTestWorkers(t, smartContract, mockStub, "worker_a")
+	setCreator(t, mockStub, "OrgB")
+	registerTestItems(t, smartContract, mockStub, problemKey, 2)
+	setCreator(t, mockStub, "OrgC")
+	registerTestItems(t, smartContract, mockStub, problemKey, 0, "algo")
+	mockStub.MockTransactionEnd("mockTxID")
+	learnupletKey := getKeys(t, mockStub, "learnuplet")[0]
+	invoke("OrgA", smartContract.setUpletWorker, learnupletKey, "worker_a")
+	reported := invoke("OrgA", smartContract.reportLearn, testReport(t, mockStub, learnupletKey, "done")...)
+	reportedAgain := invoke("OrgA", smartContract.reportLearn, testReport(t, mockStub, learnupletKey, "done")...)
+	// a done learnuplet cannot be assigned again to be reported a second time
+	reassigned := invoke("OrgA", smartContract.setUpletWorker, learnupletKey, "worker_a")
+	reportedAfterReassign := invoke("OrgA", smartContract.reportLearn, testReport(t, mockStub, learnupletKey, "done")...)
+	var contributions, problemContributions, otherContributions []Contribution
+	json.Unmarshal(invoke("OrgA", smartContract.queryContributions, "OrgB").Payload, &contributions)
+	json.Unmarshal(invoke("OrgA", smartContract.queryContributions, "OrgC", problemKey).Payload, &problemContributions)
+	json.Unmarshal(invoke("OrgA", smartContract.queryContributions, "OrgC", "problem_other").Payload, &otherContributions)
+
+	// ASSERT
+	if reported.Status != 200 {
+		t.Fatalf("Report of learnuplet fails - %s", reported.Message)
+	}
+	if reportedAgain.Status == 200 {
+		t.Errorf("A done learnuplet should not be reported again")
+	}
+	if reassigned.Status == 200 || reportedAfterReassign.Status == 200 {
+		t.Errorf("A done learnuplet should not be assigned and reported again")
+	}
+	// each of the 2 train data earns 2 credits, the algo 5 and the worker 1
+	if b := balance("OrgB"); b.Total != 4 || b.Problems[problemKey] != 4 {
+		t.Errorf("Data owner not credited as expected: %+v", b)
+	}
+	if b := balance("OrgC"); b.Total != 5 {
+		t.Errorf("Algo owner not credited as expected: %+v", b)
+	}
+	if b := balance("OrgA"); b.Total != 1 {
+		t.Errorf("Worker owner not credited as expected: %+v", b)
+	}
+	if b := balance("OrgD"); b.Total != 0 || b.Owner != "OrgD" {
+		t.Errorf("Organisation without contribution should have an empty balance: %+v", b)
+	}
+	if len(contributions) != 2 || contributions[0].Role != dataRole || contributions[0].Credits != 2 ||
+		contributions[0].Learnuplet != learnupletKey {
+		t.Errorf("Contributions of data owner not recorded as expected: %+v", contributions)
+	}
+	if len(problemContributions) != 1 || problemContributions[0].Role != algoRole || len(otherContributions) != 0 {
+		t.Errorf("Contributions not filtered by problem: %+v %+v", problemContributions, otherContributions)
+	}
+}
@@ -0,0 +1,373 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// readyLearnuplet is a learnuplet ready to be trained, with its scheduling priority.
+// Priority is the number of learnuplets waiting after it in its chain: the longer
+// the remaining chain, the sooner the learnuplet should be trained.
+type readyLearnuplet struct {
+	keyedLearnuplet
+	Priority int `json:"priority"`
+}
+
+// isFinished returns true if a learnuplet will not be trained anymore
+func isFinished(learnuplet Learnuplet) bool {
+	return learnuplet.Status == "done" || learnuplet.Status == "failed" || learnuplet.Status == "cancelled"
+}
+
+// isReady returns true if a learnuplet can be trained: its status is todo, its start model
+// is known and all learnuplets of lower rank of the same algo and fold are finished, unless learnuplets
+// of the problem are independent.
+// chain holds all learnuplets of the algo of the learnuplet.
+func isReady(learnuplet Learnuplet, chain []keyedLearnuplet, independent bool) bool {
+	if learnuplet.Status != "todo" || learnuplet.ModelStartAddress == "" {
+		return false
+	}
+	if independent {
+		return true
+	}
+	for _, predecessor := range chain {
+		if predecessor.Fold == learnuplet.Fold && predecessor.Rank < learnuplet.Rank && !isFinished(predecessor.Learnuplet) {
+			return false
+		}
+	}
+	return true
+}
+
+// getReadyLearnuplets returns the learnuplets ready to be trained, optionally restricted to a
+// problem (problemKey empty otherwise), ordered by decreasing priority, then by rank and key.
+func getReadyLearnuplets(APIstub shim.ChaincodeStubInterface, problemKey string) ([]readyLearnuplet, error) {
+
+	todoLearnuplets, err := getLearnupletsByIndex(APIstub, "status", "todo")
+	if err != nil {
+		return nil, err
+	}
+
+	chains := make(map[string][]keyedLearnuplet)
+	problems := make(map[string]Problem)
+	var readyLearnuplets []readyLearnuplet
+	for _, learnuplet := range todoLearnuplets {
+		if _, ok := learnuplet.Problem[problemKey]; problemKey != "" && !ok {
+			continue
+		}
+		// all learnuplets of the algo are needed to check the predecessors
+		algoKey := getAlgoKey(learnuplet.Learnuplet)
+		chain, ok := chains[algoKey]
+		if !ok {
+			chain, err = getLearnupletsByIndex(APIstub, "algo", algoKey)
+			if err != nil {
+				return nil, err
+			}
+			chains[algoKey] = chain
+		}
+		learnupletProblemKey := getProblemKey(learnuplet.Learnuplet)
+		problem, ok := problems[learnupletProblemKey]
+		if !ok {
+			problem, err = getProblem(APIstub, learnupletProblemKey)
+			if err != nil {
+				return nil, err
+			}
+			problems[learnupletProblemKey] = problem
+		}
+		if !isReady(learnuplet.Learnuplet, chain, problem.isIndependent()) {
+			continue
+		}
+		// independent learnuplets do not hold back any other one
+		priority := 0
+		for _, successor := range chain {
+			if !problem.isIndependent() && successor.Fold == learnuplet.Fold && successor.Rank > learnuplet.Rank &&
+				successor.Status == "todo" {
+				priority++
+			}
+		}
+		readyLearnuplets = append(readyLearnuplets, readyLearnuplet{learnuplet, priority})
+	}
+
+	sort.Slice(readyLearnuplets, func(i, j int) bool {
+		a, b := readyLearnuplets[i], readyLearnuplets[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if a.Rank != b.Rank {
+			return a.Rank < b.Rank
+		}
+		return a.Key < b.Key
+	})
+	return readyLearnuplets, nil
+}
+
+// queryReadyLearnuplets is a smart contract to get the learnuplets which can be trained right now,
+// ordered by scheduling priority.
+// Args (0 to 2 strings): optionally "problemKey" ("" for all problems), optionally "limit" (maximum
+// number of learnuplets returned)
+func (s *SmartContract) queryReadyLearnuplets(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 0 to 2: optionally problemKey and limit")
+	}
+	problemKey := ""
+	if len(args) >= 1 {
+		problemKey = args[0]
+	}
+	limit := 0
+	if len(args) == 2 {
+		var err error
+		limit, err = strconv.Atoi(args[1])
+		if err != nil || limit < 0 {
+			return shim.Error("limit must be a positive integer")
+		}
+	}
+	fmt.Printf("- start looking for ready learnuplets of problem %s \n", problemKey)
+
+	readyLearnuplets, err := getReadyLearnuplets(APIstub, problemKey)
+	if err != nil {
+		return shim.Error("Problem looking for ready learnuplets - " + err.Error())
+	}
+	if limit > 0 && len(readyLearnuplets) > limit {
+		readyLearnuplets = readyLearnuplets[:limit]
+	}
+	reader, err := newPrivateReader(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for i := range readyLearnuplets {
+		err = reader.revealLearnuplet(readyLearnuplets[i].Key, &readyLearnuplets[i].Learnuplet)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	payload, err := json.Marshal(readyLearnuplets)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("- end looking for ready learnuplets of problem %s \n", problemKey)
+	return shim.Success(payload)
+}
+
+// selectCandidate deterministically selects, among the first ready learnuplets (claimWindow of the
+// configuration), the one given to a worker in a transaction, so that all endorsing peers select the same one.
+// Spreading workers claiming at the same time over several learnuplets lets claims of candidates given by
+// the workers (see claimCandidate) not conflict.
+// Unreliable workers are given learnuplets among the last ready ones instead, so that the
+// learnuplets with the highest priority go to reliable workers.
+func selectCandidate(readyLearnuplets []readyLearnuplet, worker string, txID string, reliable bool,
+	claimWindow int) readyLearnuplet {
+	return readyLearnuplets[selectCandidateIndex(len(readyLearnuplets), worker, txID, reliable, claimWindow)]
+}
+
+// selectCandidateIndex returns the index of the candidate selected by selectCandidate among nbReady ones
+func selectCandidateIndex(nbReady int, worker string, txID string, reliable bool, claimWindow int) int {
+	nbCandidates := nbReady
+	if nbCandidates > claimWindow {
+		nbCandidates = claimWindow
+	}
+	first := 0
+	if !reliable {
+		first = nbReady - nbCandidates
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(worker + "|" + txID))
+	return first + int(hash.Sum64()%uint64(nbCandidates))
+}
+
+// isClaimable returns true if a learnuplet read on its own can be claimed: its status is todo and its start
+// model is known. The start model of a learnuplet is only set once all learnuplets of lower rank of its chain
+// are finished (see propagateModel), which they stay, so that it is the same as isReady without the chain.
+func isClaimable(learnuplet Learnuplet) bool {
+	return learnuplet.Status == "todo" && learnuplet.ModelStartAddress != ""
+}
+
+// claimCandidate assigns to a worker one of the candidates given by the caller, keys of ready learnuplets
+// in the order returned by queryReadyLearnuplets, optionally restricted to a problem (problemKey empty
+// otherwise). Starting from the candidate selected for the worker and the transaction (see selectCandidate),
+// candidates are read one at a time until one is claimable and trainable by the worker. The claim thus
+// neither range-scans the ledger nor reads the other candidates, so that claims committed in the same
+// block only conflict when they select the same learnuplet.
+func claimCandidate(APIstub shim.ChaincodeStubInterface, worker Worker, workerID string, problemKey string,
+	candidates []string, reliable bool, claimWindow int) (keyedLearnuplet, error) {
+
+	first := selectCandidateIndex(len(candidates), workerID, APIstub.GetTxID(), reliable, claimWindow)
+	for i := range candidates {
+		key := candidates[(first+i)%len(candidates)]
+		value, err := APIstub.GetState(key)
+		if err != nil {
+			return keyedLearnuplet{}, err
+		}
+		if value == nil || !strings.HasPrefix(key, "learnuplet_") {
+			continue
+		}
+		learnuplet := Learnuplet{}
+		err = unmarshalRecord(APIstub, value, &learnuplet)
+		if err != nil {
+			return keyedLearnuplet{}, fmt.Errorf("Problem Unmarshal uplet %s - %s", key, err)
+		}
+		if _, ok := learnuplet.Problem[problemKey]; !isClaimable(learnuplet) || (problemKey != "" && !ok) {
+			continue
+		}
+		trainable, err := filterTrainable(APIstub, []readyLearnuplet{{keyedLearnuplet{key, learnuplet}, 0}}, worker)
+		if err != nil {
+			return keyedLearnuplet{}, err
+		}
+		if len(trainable) == 0 {
+			continue
+		}
+		learnuplet, err = assignWorker(APIstub, key, learnuplet, workerID)
+		if err != nil {
+			return keyedLearnuplet{}, err
+		}
+		return keyedLearnuplet{key, learnuplet}, nil
+	}
+	return keyedLearnuplet{}, fmt.Errorf("No candidate learnuplet can be trained by worker %s", workerID)
+}
+
+// filterTrainable keeps the ready learnuplets a worker can train, in the same order,
+// including the usage policies of their train data
+func filterTrainable(APIstub shim.ChaincodeStubInterface, readyLearnuplets []readyLearnuplet,
+	worker Worker) ([]readyLearnuplet, error) {
+
+	trainable := make(map[string]bool)
+	data := make(map[string]Item)
+	var filtered []readyLearnuplet
+	for _, learnuplet := range readyLearnuplets {
+		problemKey := getProblemKey(learnuplet.Learnuplet)
+		ok, checked := trainable[problemKey]
+		if !checked {
+			problem, err := getProblem(APIstub, problemKey)
+			if err != nil {
+				return nil, err
+			}
+			ok = worker.canTrain(problemKey, problem) == nil
+			trainable[problemKey] = ok
+		}
+		if !ok || !worker.canTrainLearnuplet(learnuplet.Learnuplet) {
+			continue
+		}
+		if checkDataPolicies(APIstub, learnuplet.Learnuplet, worker.Owner, data) == nil {
+			filtered = append(filtered, learnuplet)
+		}
+	}
+	return filtered, nil
+}
+
+// claimNextLearnuplet is a smart contract for a worker to get a learnuplet to train.
+// A ready learnuplet is selected and set pending for the worker in the same transaction,
+// and its public description is returned: as the response of an invoke is recorded in the block,
+// the addresses of the private data of the learnuplet are then queried with queryObject.
+// Only learnuplets of problems the worker can train are considered (see registerWorker).
+// Candidates are the keys of ready learnuplets, as returned by queryReadyLearnuplets, among which the
+// learnuplet is selected without reading the others, so that concurrent claims do not conflict (see
+// claimCandidate). Without candidates, the ready learnuplets are read from the index of learnuplets to do,
+// which each claim updates: claims committed in the same block then conflict, only the first one being valid.
+// It should be callable by Compute only.
+// Args (1 to 3 strings): "worker", optionally "problemKey" ("" for all problems), optionally "candidates"
+// ("[\"learnuplet_xxx\", ...]")
+func (s *SmartContract) claimNextLearnuplet(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) < 1 || len(args) > 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 to 3: worker, optionally problemKey and candidates")
+	}
+	worker := args[0]
+	problemKey := ""
+	if len(args) >= 2 {
+		problemKey = args[1]
+	}
+	var candidates []string
+	if len(args) == 3 {
+		err := json.Unmarshal([]byte(args[2]), &candidates)
+		if err != nil || len(candidates) == 0 {
+			return shim.Error("candidates must be a non empty list of learnuplet keys")
+		}
+	}
+	fmt.Printf("- start claim of a learnuplet by %s \n", worker)
+
+	workerRecord, err := getWorker(APIstub, worker)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	stats, err := getWorkerStats(APIstub, worker)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config, err := getConfig(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var claimed keyedLearnuplet
+	if len(candidates) > 0 {
+		claimed, err = claimCandidate(APIstub, workerRecord, worker, problemKey, candidates,
+			stats.isReliable(config), config.ClaimWindow)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		readyLearnuplets, err := getReadyLearnuplets(APIstub, problemKey)
+		if err != nil {
+			return shim.Error("Problem looking for ready learnuplets - " + err.Error())
+		}
+		readyLearnuplets, err = filterTrainable(APIstub, readyLearnuplets, workerRecord)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if len(readyLearnuplets) == 0 {
+			return shim.Error("No learnuplet ready to be trained by worker " + worker)
+		}
+		claimed = selectCandidate(readyLearnuplets, worker, APIstub.GetTxID(), stats.isReliable(config),
+			config.ClaimWindow).keyedLearnuplet
+		claimed.Learnuplet, err = assignWorker(APIstub, claimed.Key, claimed.Learnuplet, worker)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	payload, err := json.Marshal(claimed)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end claim of %s by %s \n", claimed.Key, worker)
+	return shim.Success(payload)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// registerTestItems registers n data and the given algos on a problem
+func registerTestItems(t *testing.T, smartContract *SmartContract, mockStub *testStub,
+	problemKey string, nbData int, algoNames ...string) {
+
+	for i := 0; i < nbData; i++ {
+		response := smartContract.registerItem(mockStub, []string{"data", fmt.Sprintf("8fa81bfc-b5f4-4ba2-b81a-b464248000%02d", i), problemKey, ""})
+		if response.GetStatus() != 200 {
+			t.Fatalf("Registration of data fails - %s", response.Message)
+		}
+	}
+	for _, algoName := range algoNames {
+		response := smartContract.registerItem(mockStub, []string{"algo", "8fa81bfc-b5f4-4ba2-b81a-" + algoName, problemKey, algoName})
+		if response.GetStatus() != 200 {
+			t.Fatalf("Registration of algo fails - %s", response.Message)
+		}
+	}
+}
+
+func TestQueryReadyLearnuplets(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1")
+	// 3 data in mini-batches of 1 and 2 algos: 2 chains of 3 learnuplets
+	registerTestItems(t, smartContract, mockStub, problemKey, 3, "algo1", "algo2")
+	allReady := smartContract.queryReadyLearnuplets(mockStub, []string{})
+	problemReady := smartContract.queryReadyLearnuplets(mockStub, []string{problemKey, "1"})
+	otherProblemReady := smartContract.queryReadyLearnuplets(mockStub, []string{"problem_other"})
+	wrongLimit := smartContract.queryReadyLearnuplets(mockStub, []string{problemKey, "-1"})
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	if allReady.GetStatus() != 200 || problemReady.GetStatus() != 200 || otherProblemReady.GetStatus() != 200 {
+		t.Fatalf("queryReadyLearnuplets fails - %s", allReady.Message)
+	}
+	if wrongLimit.GetStatus() == 200 {
+		t.Errorf("queryReadyLearnuplets should fail with a negative limit")
+	}
+	var ready, limited, other []readyLearnuplet
+	json.Unmarshal(allReady.GetPayload(), &ready)
+	json.Unmarshal(problemReady.GetPayload(), &limited)
+	json.Unmarshal(otherProblemReady.GetPayload(), &other)
+	// only learnuplets of rank 0 have their start model and no predecessor
+	if len(ready) != 2 {
+		t.Fatalf("%d learnuplets ready instead of 2", len(ready))
+	}
+	for _, learnuplet := range ready {
+		if learnuplet.Rank != 0 || learnuplet.ModelStartAddress == "" || learnuplet.Priority != 2 || learnuplet.Key == "" {
+			t.Errorf("Learnuplet %s of rank %d should not be ready", learnuplet.Key, learnuplet.Rank)
+		}
+	}
+	if len(limited) != 1 || limited[0].Key != ready[0].Key {
+		t.Errorf("Limit of ready learnuplets fails")
+	}
+	if len(other) != 0 {
+		t.Errorf("Filter on problem fails")
+	}
+}
+
+func TestIsReady(t *testing.T) {
+	chain := []keyedLearnuplet{
+		{"learnuplet_0", Learnuplet{Rank: 0, Status: "done", ModelStartAddress: "model_0", ModelEndAddress: "model_1"}},
+		{"learnuplet_1", Learnuplet{Rank: 1, Status: "failed", ModelStartAddress: "model_1"}},
+		{"learnuplet_2", Learnuplet{Rank: 2, Status: "todo", ModelStartAddress: "model_1"}},
+		{"learnuplet_3", Learnuplet{Rank: 3, Status: "todo", ModelStartAddress: "model_1"}},
+		{"learnuplet_4", Learnuplet{Rank: 4, Status: "todo"}},
+	}
+	expected := []bool{false, false, true, false, false}
+	// independent learnuplets do not wait for the previous ones
+	expectedIndependent := []bool{false, false, true, true, false}
+	for i, learnuplet := range chain {
+		if isReady(learnuplet.Learnuplet, chain, false) != expected[i] {
+			t.Errorf("isReady of %s should be %t", learnuplet.Key, expected[i])
+		}
+		if isReady(learnuplet.Learnuplet, chain, true) != expectedIndependent[i] {
+			t.Errorf("isReady of independent %s should be %t", learnuplet.Key, expectedIndependent[i])
+		}
+	}
+}
+
+func TestClaimNextLearnuplet(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+
+	// ACT
+	mockStub.MockTransactionStart("mockTxID")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1")
+	registerTestItems(t, smartContract, mockStub, problemKey, 2, "algo1", "algo2")
+	registerTestWorkers(t, smartContract, mockStub, "Arbeiter_12", "Arbeiter_13", "Arbeiter_14")
+	mockStub.MockTransactionEnd("mockTxID")
+	var responses []sc.Response
+	for i, worker := range []string{"Arbeiter_12", "Arbeiter_13", "Arbeiter_14"} {
+		txId := fmt.Sprintf("mockTxID_%d", i)
+		mockStub.MockTransactionStart(txId)
+		responses = append(responses, smartContract.claimNextLearnuplet(mockStub, []string{worker, problemKey}))
+		mockStub.MockTransactionEnd(txId)
+	}
+
+	// ASSERT
+	// two learnuplets of rank 0 are ready, one for each algo
+	claimedKeys := make(map[string]bool)
+	for i, response := range responses[:2] {
+		if response.GetStatus() != 200 {
+			t.Fatalf("Claim %d fails - %s", i, response.Message)
+		}
+		claimed := keyedLearnuplet{}
+		json.Unmarshal(response.GetPayload(), &claimed)
+		stored := Learnuplet{}
+		learnupletAsBytes, _ := mockStub.GetState(claimed.Key)
+		json.Unmarshal(learnupletAsBytes, &stored)
+		if stored.Status != "pending" || stored.Worker != claimed.Worker || claimed.Status != "pending" || claimed.Rank != 0 {
+			t.Errorf("Claimed learnuplet %s not set pending", claimed.Key)
+		}
+		claimedKeys[claimed.Key] = true
+	}
+	if len(claimedKeys) != 2 {
+		t.Errorf("Same learnuplet claimed twice")
+	}
+	if responses[2].GetStatus() == 200 {
+		t.Errorf("Claim should fail when no learnuplet is ready")
+	}
+}
+
+func TestSelectCandidate(t *testing.T) {
+	var readyLearnuplets []readyLearnuplet
+	positions := make(map[string]int)
+	claimWindow := defaultConfig.ClaimWindow
+	for i := 0; i < 3*claimWindow; i++ {
+		key := fmt.Sprintf("learnuplet_%d", i)
+		readyLearnuplets = append(readyLearnuplets, readyLearnuplet{keyedLearnuplet: keyedLearnuplet{Key: key}})
+		positions[key] = i
+	}
+	for _, reliable := range []bool{true, false} {
+		selected := make(map[string]bool)
+		for i := 0; i < 100; i++ {
+			worker := fmt.Sprintf("Arbeiter_%d", i)
+			candidate := selectCandidate(readyLearnuplets, worker, "mockTxID", reliable, claimWindow)
+			// selection is deterministic
+			if selectCandidate(readyLearnuplets, worker, "mockTxID", reliable, claimWindow).Key != candidate.Key {
+				t.Fatalf("Selection of candidate is not deterministic")
+			}
+			selected[candidate.Key] = true
+		}
+		// workers are spread over the claim window, which is made of the candidates with the highest
+		// priority for reliable workers, and of the candidates with the lowest priority otherwise
+		if len(selected) < 2 || len(selected) > claimWindow {
+			t.Errorf("Workers spread over %d candidates", len(selected))
+		}
+		for key := range selected {
+			if reliable && positions[key] >= claimWindow {
+				t.Errorf("%s is out of the claim window of reliable workers", key)
+			} else if !reliable && positions[key] < len(readyLearnuplets)-claimWindow {
+				t.Errorf("%s is out of the claim window of unreliable workers", key)
+			}
+		}
+	}
+}
+
+// recordingStub records the keys a transaction reads and writes, and the key ranges it scans, to check
+// whether transactions endorsed on the same state conflict once committed in the same block
+type recordingStub struct {
+	*testStub
+	reads  map[string]bool
+	writes map[string]bool
+	ranges [][2]string
+}
+
+// newRecordingStub returns a recording stub on a copy of the state of a stub
+func newRecordingStub(name string, smartContract *SmartContract, mockStub *testStub) *recordingStub {
+	stub := &recordingStub{newTestStub(name, smartContract), make(map[string]bool), make(map[string]bool), nil}
+	stub.MockTransactionStart("mockTxID_copy")
+	for key, value := range mockStub.State {
+		stub.testStub.PutState(key, value)
+	}
+	stub.MockTransactionEnd("mockTxID_copy")
+	return stub
+}
+
+func (stub *recordingStub) GetState(key string) ([]byte, error) {
+	stub.reads[key] = true
+	return stub.testStub.GetState(key)
+}
+
+func (stub *recordingStub) PutState(key string, value []byte) error {
+	stub.writes[key] = true
+	return stub.testStub.PutState(key, value)
+}
+
+func (stub *recordingStub) DelState(key string) error {
+	stub.writes[key] = true
+	return stub.testStub.DelState(key)
+}
+
+func (stub *recordingStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	stub.ranges = append(stub.ranges, [2]string{startKey, endKey})
+	return stub.testStub.GetStateByRange(startKey, endKey)
+}
+
+func (stub *recordingStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	partialKey, _ := stub.CreateCompositeKey(objectType, attributes)
+	stub.ranges = append(stub.ranges, [2]string{partialKey, partialKey + string(utf8.MaxRune)})
+	return stub.testStub.GetStateByPartialCompositeKey(objectType, attributes)
+}
+
+// conflictsWith returns true if the transaction is invalid when committed after another one in the same
+// block: it read a key written by the other one, or scanned a range containing such a key
+func (stub *recordingStub) conflictsWith(other *recordingStub) bool {
+	for key := range other.writes {
+		if stub.reads[key] {
+			return true
+		}
+		for _, keyRange := range stub.ranges {
+			if key >= keyRange[0] && (keyRange[1] == "" || key < keyRange[1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestConcurrentClaims(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	// claim claims a learnuplet on a copy of the ledger, as transactions endorsed for the same block
+	claim := func(worker string, args ...string) (*recordingStub, keyedLearnuplet) {
+		stub := newRecordingStub(worker, smartContract, mockStub)
+		stub.MockTransactionStart("mockTxID_" + worker)
+		defer stub.MockTransactionEnd("mockTxID_" + worker)
+		response := smartContract.claimNextLearnuplet(stub, append([]string{worker}, args...))
+		if response.GetStatus() != 200 {
+			t.Fatalf("claimNextLearnuplet by %s fails - %s", worker, response.Message)
+		}
+		claimed := keyedLearnuplet{}
+		json.Unmarshal(response.GetPayload(), &claimed)
+		return stub, claimed
+	}
+
+	// ACT
+	mockStub.MockTransactionStart("mockTxID")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1", "", `{"chainingPolicy": "restart"}`)
+	registerTestItems(t, smartContract, mockStub, problemKey, 4, "algo1")
+	registerTestWorkers(t, smartContract, mockStub, "Arbeiter_12", "Arbeiter_13")
+	var ready []readyLearnuplet
+	json.Unmarshal(smartContract.queryReadyLearnuplets(mockStub, []string{problemKey}).Payload, &ready)
+	mockStub.MockTransactionEnd("mockTxID")
+	var keys []string
+	for _, learnuplet := range ready {
+		keys = append(keys, learnuplet.Key)
+	}
+	candidates, _ := json.Marshal(keys)
+	stubA, claimedA := claim("Arbeiter_12", "", string(candidates))
+	stubB, claimedB := claim("Arbeiter_13", "", string(candidates))
+	// without candidates, claims read the index of learnuplets to do, which they update
+	scanA, _ := claim("Arbeiter_12")
+	scanB, _ := claim("Arbeiter_13")
+
+	// ASSERT
+	if len(ready) != 4 {
+		t.Fatalf("%d learnuplets ready instead of 4", len(ready))
+	}
+	if claimedA.Key == claimedB.Key || claimedA.Status != "pending" || claimedB.Status != "pending" {
+		t.Errorf("Workers did not claim distinct learnuplets: %s, %s", claimedA.Key, claimedB.Key)
+	}
+	if stubA.conflictsWith(stubB) || stubB.conflictsWith(stubA) {
+		t.Errorf("Claims of distinct candidates conflict in the same block")
+	}
+	if !scanB.conflictsWith(scanA) {
+		t.Errorf("Claims without candidates should conflict in the same block")
+	}
+}
@@ -0,0 +1,304 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// snapshotFormat is the version of the format of snapshots, changed when it is not backward compatible
+const snapshotFormat = 1
+
+// importKey is the key of the progress of the import of a snapshot
+const importKey = "import"
+
+// ledgerIndexes are the composite key indexes of the ledger, exported after the entries with a simple key
+var ledgerIndexes = []string{
+	"algo~problem~key", "data~problem~key", "learnuplet~algo~key", "learnuplet~status~key", "tag~type~key",
+	"round~algo~key", "usage~data~learnuplet", "contribution~owner~key", "credit~owner", "datavalue~problem~data",
+}
+
+// SnapshotEntry is an entry of the ledger: a simple Key, or the Index and Attributes of a composite key.
+// Value is the record stored under the key, empty for index entries which only mark the key.
+type SnapshotEntry struct {
+	Key        string          `json:"key,omitempty"`
+	Index      string          `json:"index,omitempty"`
+	Attributes []string        `json:"attributes,omitempty"`
+	Value      json.RawMessage `json:"value,omitempty"`
+}
+
+// Snapshot is a page of the dump of the ledger, exported from the bookmark From. Bookmark is the one
+// of the next page, empty for the last page. SchemaVersion is the schema version of the exported ledger.
+type Snapshot struct {
+	Format        int             `json:"format"`
+	SchemaVersion int             `json:"schemaVersion"`
+	From          string          `json:"from"`
+	Bookmark      string          `json:"bookmark"`
+	Entries       []SnapshotEntry `json:"entries"`
+}
+
+// ImportProgress structure, stored with key import, recording the pages of a snapshot imported so far.
+// Next is the bookmark of the next page to import, and Status is importing until the last page is imported, then done.
+type ImportProgress struct {
+	Record
+	ObjectType    string `json:"docType"`
+	Status        string `json:"status"`
+	SchemaVersion int    `json:"schemaVersion"`
+	Next          string `json:"next"`
+	Pages         int    `json:"pages"`
+	Entries       int    `json:"entries"`
+	UpdatedAt     string `json:"updatedAt"`
+}
+
+// exportState is the smart contract to export a page of the dump of the ledger: problems, items, learnuplets
+// and all other records, and the entries of the indexes. Private data collections are not exported.
+// Args (1 or 2 strings): limit (number of entries of the page), optionally bookmark returned with the previous page
+func (s *SmartContract) exportState(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	limit, bookmark, err := parseChunkArgs(args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	snapshot := Snapshot{Format: snapshotFormat, From: bookmark, Entries: []SnapshotEntry{}}
+	fmt.Printf("- start exporting %d entries from %q \n", limit, snapshot.From)
+
+	schema, _, err := getSchema(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	snapshot.SchemaVersion = schema.Version
+	_, snapshot.Bookmark, err = scanLedger(APIstub, ledgerIndexes, limit, snapshot.From, func(key string, value []byte) error {
+		entry, err := newSnapshotEntry(APIstub, key, value)
+		snapshot.Entries = append(snapshot.Entries, entry)
+		return err
+	})
+	if err != nil {
+		return shim.Error("Problem exporting the ledger - " + err.Error())
+	}
+	payload, err := json.Marshal(snapshot)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end exporting %d entries \n", len(snapshot.Entries))
+	return shim.Success(payload)
+}
+
+// newSnapshotEntry returns the snapshot entry of a key and its value
+func newSnapshotEntry(APIstub shim.ChaincodeStubInterface, key string, value []byte) (entry SnapshotEntry, err error) {
+	if strings.HasPrefix(key, compositeKeyNamespace) {
+		entry.Index, entry.Attributes, err = APIstub.SplitCompositeKey(key)
+		if err != nil {
+			return entry, err
+		}
+	} else {
+		entry.Key = key
+	}
+	if isRecord(value) {
+		entry.Value = json.RawMessage(value)
+	} else if !bytes.Equal(value, []byte{0x00}) {
+		return entry, fmt.Errorf("value of %q is neither a record nor an index marker", key)
+	}
+	return entry, nil
+}
+
+// validate checks an entry of a snapshot, and returns the key it is stored under
+func (entry SnapshotEntry) validate(APIstub shim.ChaincodeStubInterface) (string, error) {
+	if entry.Value != nil && (!isRecord(entry.Value) || !json.Valid(entry.Value)) {
+		return "", fmt.Errorf("value must be a JSON object")
+	}
+	if entry.Value != nil {
+		header := struct {
+			SchemaVersion int `json:"schemaVersion"`
+		}{}
+		if err := json.Unmarshal(entry.Value, &header); err != nil {
+			return "", fmt.Errorf("invalid schema version - %s", err)
+		}
+		if header.SchemaVersion < 0 || header.SchemaVersion > latestSchemaVersion() {
+			return "", fmt.Errorf("schema version %d must be between 0 and %d", header.SchemaVersion, latestSchemaVersion())
+		}
+	}
+	if entry.Key != "" {
+		if entry.Index != "" || len(entry.Attributes) > 0 {
+			return "", fmt.Errorf("entry cannot have both a key and an index")
+		}
+		if strings.HasPrefix(entry.Key, compositeKeyNamespace) {
+			return "", fmt.Errorf("key %q is a composite key", entry.Key)
+		}
+		if entry.Value == nil {
+			return "", fmt.Errorf("entry %s has no value", entry.Key)
+		}
+		return entry.Key, nil
+	}
+	known := false
+	for _, index := range ledgerIndexes {
+		known = known || index == entry.Index
+	}
+	if !known || len(entry.Attributes) == 0 {
+		return "", fmt.Errorf("unknown index %q", entry.Index)
+	}
+	isRecordIndex := false
+	for _, index := range recordIndexes {
+		isRecordIndex = isRecordIndex || index == entry.Index
+	}
+	if isRecordIndex != (entry.Value != nil) {
+		return "", fmt.Errorf("entries of index %s must have a value if and only if it is an index of records", entry.Index)
+	}
+	return APIstub.CreateCompositeKey(entry.Index, entry.Attributes)
+}
+
+// importState is the smart contract to replay a dump of a ledger, exported with exportState, into a fresh
+// channel. Pages are imported in order, the first one in a ledger without problems: the progress of
+// the import is stored with key import. The configuration and the schema of the channel are kept:
+// imported records are upgraded on read, and can be migrated with migrateObjects.
+// Only callable by admin organisations (see Config)
+// Args (1 string): snapshot page, as returned by exportState
+func (s *SmartContract) importState(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1: snapshot")
+	}
+	fmt.Println("- start importing snapshot")
+
+	if _, _, err := getAdminConfig(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+	snapshot := Snapshot{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&snapshot); err != nil {
+		return shim.Error("Invalid snapshot - " + err.Error())
+	}
+	if snapshot.Format != snapshotFormat {
+		return shim.Error(fmt.Sprintf("Invalid snapshot - format %d instead of %d", snapshot.Format, snapshotFormat))
+	}
+	if snapshot.SchemaVersion < 0 {
+		return shim.Error(fmt.Sprintf("Invalid snapshot - negative schema version %d", snapshot.SchemaVersion))
+	}
+	if snapshot.SchemaVersion > latestSchemaVersion() {
+		return shim.Error(fmt.Sprintf("Invalid snapshot - schema version %d is newer than the chaincode one %d",
+			snapshot.SchemaVersion, latestSchemaVersion()))
+	}
+	progress, err := getImportProgress(APIstub, snapshot)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// entries are all validated before any is written
+	keys := make([]string, len(snapshot.Entries))
+	for i, entry := range snapshot.Entries {
+		keys[i], err = entry.validate(APIstub)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Invalid snapshot entry %d - %s", i, err))
+		}
+	}
+	imported := 0
+	for i, entry := range snapshot.Entries {
+		if keys[i] == configKey || keys[i] == schemaKey || keys[i] == importKey {
+			continue
+		}
+		value := []byte(entry.Value)
+		if value == nil {
+			value = []byte{0x00}
+		}
+		if err = APIstub.PutState(keys[i], value); err != nil {
+			return shim.Error(err.Error())
+		}
+		imported++
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	progress.Next, progress.UpdatedAt = snapshot.Bookmark, txTime.Format(time.RFC3339)
+	progress.Pages++
+	progress.Entries += imported
+	if snapshot.Bookmark == "" {
+		progress.Status = "done"
+	}
+	progressAsBytes, err := marshalRecord(&progress)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = APIstub.PutState(importKey, progressAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end importing snapshot, %d entries imported \n", imported)
+	return shim.Success(nil)
+}
+
+// getImportProgress returns the progress of the import a snapshot page continues. The first page starts
+// an import in a ledger without problems, and the other ones must follow the last imported page.
+func getImportProgress(APIstub shim.ChaincodeStubInterface, snapshot Snapshot) (progress ImportProgress, err error) {
+	value, err := APIstub.GetState(importKey)
+	if err != nil {
+		return progress, err
+	}
+	if value != nil {
+		err = unmarshalRecord(APIstub, value, &progress)
+		if err != nil {
+			return progress, fmt.Errorf("Problem Unmarshal import - %s", err)
+		}
+	}
+	if snapshot.From != "" {
+		if progress.Status != "importing" || progress.Next != snapshot.From {
+			return progress, fmt.Errorf("snapshot page from %q does not follow the imported pages", snapshot.From)
+		}
+		if progress.SchemaVersion != snapshot.SchemaVersion {
+			return progress, fmt.Errorf("snapshot page of schema version %d does not follow the imported pages",
+				snapshot.SchemaVersion)
+		}
+		return progress, nil
+	}
+	if value != nil {
+		return progress, fmt.Errorf("a snapshot was already imported, the channel is not fresh")
+	}
+	problemIterator, err := APIstub.GetStateByRange("problem_", "problem_z")
+	if err != nil {
+		return progress, err
+	}
+	defer problemIterator.Close()
+	if problemIterator.HasNext() {
+		return progress, fmt.Errorf("ledger has problems, the channel is not fresh")
+	}
+	return ImportProgress{ObjectType: "import", Status: "importing", SchemaVersion: snapshot.SchemaVersion}, nil
+}
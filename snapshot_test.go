@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+func TestExportImportState(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	source := newTestStub("source", smartContract)
+	source.MockInit("mockTxID_init", [][]byte{[]byte("init"), []byte("fresh"), []byte("OrgA")})
+	source.MockTransactionStart("mockTxID")
+	problemKey := registerTestProblem(t, smartContract, source, "1")
+	registerTestItems(t, smartContract, source, problemKey, 3, "algo1", "algo2")
+	source.MockTransactionEnd("mockTxID")
+	target := newTestStub("target", smartContract)
+	target.MockInit("mockTxID_init", [][]byte{[]byte("init"), []byte("fresh"), []byte("OrgB")})
+	tx := 0
+	invoke := func(mockStub *testStub, org string, function func(shim.ChaincodeStubInterface, []string) sc.Response,
+		args ...string) (payload []byte, status int32) {
+
+		tx++
+		txId := fmt.Sprintf("mockTxID_%d", tx)
+		mockStub.MockTransactionStart(txId)
+		defer mockStub.MockTransactionEnd(txId)
+		setCreator(t, mockStub, org)
+		response := function(mockStub, args)
+		return response.Payload, response.Status
+	}
+
+	// ACT
+	var pages [][]byte
+	page, status := invoke(source, "OrgA", smartContract.exportState, "3")
+	for ; status == shim.OK && len(pages) < 50; page, status = invoke(source, "OrgA", smartContract.exportState, "3", snapshotBookmark(page)) {
+		pages = append(pages, page)
+		if snapshotBookmark(page) == "" {
+			break
+		}
+	}
+	_, notAdmin := invoke(target, "OrgA", smartContract.importState, string(pages[0]))
+	_, outOfOrder := invoke(target, "OrgB", smartContract.importState, string(pages[1]))
+	var importStatuses []int32
+	for _, page := range pages {
+		_, status := invoke(target, "OrgB", smartContract.importState, string(page))
+		importStatuses = append(importStatuses, status)
+	}
+	_, again := invoke(target, "OrgB", smartContract.importState, string(pages[0]))
+	_, notFresh := invoke(source, "OrgA", smartContract.importState, string(pages[0]))
+
+	// ASSERT
+	if status != shim.OK || len(pages) < 2 || snapshotBookmark(pages[len(pages)-1]) != "" {
+		t.Fatalf("Export did not complete in several pages: %d pages - %d", len(pages), status)
+	}
+	for i, page := range pages {
+		snapshot := Snapshot{}
+		json.Unmarshal(page, &snapshot)
+		if snapshot.Format != snapshotFormat || snapshot.SchemaVersion != latestSchemaVersion() || len(snapshot.Entries) > 3 {
+			t.Errorf("Unexpected snapshot page %d: %s", i, page)
+		}
+	}
+	if notAdmin == shim.OK || outOfOrder == shim.OK {
+		t.Errorf("Snapshots should only be imported by admins, page after page")
+	}
+	for i, status := range importStatuses {
+		if status != shim.OK {
+			t.Errorf("Import of page %d failed: %d", i, status)
+		}
+	}
+	if again == shim.OK || notFresh == shim.OK {
+		t.Errorf("Snapshots should only be imported into fresh channels")
+	}
+	for key, value := range source.State {
+		if key != configKey && string(target.State[key]) != string(value) {
+			t.Errorf("%q not imported: %s instead of %s", key, target.State[key], value)
+		}
+	}
+	for key := range target.State {
+		if _, ok := source.State[key]; !ok && key != importKey {
+			t.Errorf("%q imported but not exported", key)
+		}
+	}
+	if config, _ := getConfig(target); !config.isAdmin("OrgB") || config.isAdmin("OrgA") {
+		t.Errorf("Configuration of the channel replaced by the imported one: %+v", config)
+	}
+	progress := ImportProgress{}
+	json.Unmarshal(target.State[importKey], &progress)
+	if progress.Status != "done" || progress.Pages != len(pages) || progress.Next != "" {
+		t.Errorf("Unexpected import progress: %+v", progress)
+	}
+}
+
+func TestImportStateInvalid(t *testing.T) {
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	mockStub.MockInit("mockTxID_init", [][]byte{[]byte("init"), []byte("fresh"), []byte("OrgA")})
+	setCreator(t, mockStub, "OrgA")
+	snapshots := map[string]string{
+		"format":                  `{"format": 2, "entries": []}`,
+		"schema version":          fmt.Sprintf(`{"format": 1, "schemaVersion": %d, "entries": []}`, latestSchemaVersion()+1),
+		"negative schema version": `{"format": 1, "schemaVersion": -1, "entries": []}`,
+		"record schema version": fmt.Sprintf(`{"format": 1, "entries": [{"key": "problem_0", "value": {"schemaVersion": %d}}]}`,
+			latestSchemaVersion()+1),
+		"negative record schema version": `{"format": 1, "entries": [{"key": "problem_0", "value": {"schemaVersion": -1}}]}`,
+		"unknown field":                  `{"format": 1, "entries": [], "extra": 1}`,
+		"missing value":                  `{"format": 1, "entries": [{"key": "problem_0"}]}`,
+		"composite key":                  `{"format": 1, "entries": [{"key": "\u0000tag~type~key\u0000", "value": {}}]}`,
+		"unknown index":                  `{"format": 1, "entries": [{"index": "unknown", "attributes": ["a"]}]}`,
+		"marker value":                   `{"format": 1, "entries": [{"index": "tag~type~key", "attributes": ["a", "b", "c"], "value": {}}]}`,
+		"not a record":                   `{"format": 1, "entries": [{"key": "problem_0", "value": [1]}]}`,
+		"not continued":                  `{"format": 1, "from": "problem_0", "entries": []}`,
+	}
+	tx := 0
+	for name, snapshot := range snapshots {
+		tx++
+		txId := fmt.Sprintf("mockTxID_%d", tx)
+		mockStub.MockTransactionStart(txId)
+		response := smartContract.importState(mockStub, []string{snapshot})
+		mockStub.MockTransactionEnd(txId)
+		if response.Status == shim.OK {
+			t.Errorf("Invalid snapshot (%s) imported", name)
+		}
+	}
+	for key := range mockStub.State {
+		if strings.HasPrefix(key, "problem_") || key == importKey {
+			t.Errorf("%q written by an invalid import", key)
+		}
+	}
+}
+
+// snapshotBookmark returns the bookmark of the next page of a snapshot page
+func snapshotBookmark(page []byte) string {
+	snapshot := Snapshot{}
+	json.Unmarshal(page, &snapshot)
+	return snapshot.Bookmark
+}
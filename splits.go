@@ -0,0 +1,250 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Split strategies, defining on which data learnuplets are trained and evaluated: the fixed test
+// data of the problem, k folds of the other data for cross-validation, or the test data and data
+// randomly held out at registration.
+const (
+	fixedSplit   = "fixed"
+	kfoldSplit   = "kfold"
+	holdoutSplit = "holdout"
+)
+
+// maxFolds is the maximum number of folds of a cross-validated problem
+const maxFolds = 20
+
+// Split is the split strategy of a problem.
+// Folds is the number of folds of a kfold split: learnuplets of fold f train on the data of the
+// other folds and are evaluated on the data of fold f.
+// HoldoutRatio is the probability with which a new data of a holdout split is added to the test data.
+// Seed, set by the orchestrator to the ID of the transaction registering the problem, determines the
+// fold of each data and whether it is held out, so that any participant can recompute the split.
+type Split struct {
+	Strategy     string  `json:"strategy"`
+	Folds        int     `json:"folds"`
+	HoldoutRatio float64 `json:"holdoutRatio"`
+	Seed         string  `json:"seed"`
+}
+
+// validateSplit checks the split strategy of problem settings, and sets the default one
+func validateSplit(settings *ProblemSettings) error {
+	split := &settings.Split
+	if split.Seed != "" {
+		return fmt.Errorf("the seed of the split is set by the orchestrator")
+	}
+	switch split.Strategy {
+	case "", fixedSplit:
+		split.Strategy = fixedSplit
+		if split.Folds > 1 || split.HoldoutRatio != 0 {
+			return fmt.Errorf("a fixed split has no folds and no hold-out ratio")
+		}
+		split.Folds = 1
+	case kfoldSplit:
+		if split.Folds < 2 || split.Folds > maxFolds {
+			return fmt.Errorf("folds of a kfold split must be between 2 and %d", maxFolds)
+		}
+		if split.HoldoutRatio != 0 {
+			return fmt.Errorf("a kfold split has no hold-out ratio")
+		}
+		if settings.Branches > 1 || settings.Federated {
+			return fmt.Errorf("a problem trained in parallel branches or federated cannot be cross-validated")
+		}
+	case holdoutSplit:
+		if split.HoldoutRatio <= 0 || split.HoldoutRatio >= 1 {
+			return fmt.Errorf("hold-out ratio must be strictly between 0 and 1")
+		}
+		if split.Folds > 1 {
+			return fmt.Errorf("a holdout split has no folds")
+		}
+		split.Folds = 1
+	default:
+		return fmt.Errorf("split strategy must be %s, %s or %s", fixedSplit, kfoldSplit, holdoutSplit)
+	}
+	return nil
+}
+
+// isCrossValidated returns true if the problem is trained and evaluated on k folds
+func (problem Problem) isCrossValidated() bool {
+	return problem.Split.Strategy == kfoldSplit
+}
+
+// hash returns a pseudo-random number, derived from the seed of the split and a data key
+func (split Split) hash(dataKey string) uint64 {
+	return seededHash(split.Seed, dataKey)
+}
+
+// fold returns the fold of a data of a kfold split, 0 for other strategies
+func (split Split) fold(dataKey string) int {
+	if split.Strategy != kfoldSplit {
+		return 0
+	}
+	return int(split.hash(dataKey) % uint64(split.Folds))
+}
+
+// isHoldout returns true if a new data is held out as test data by a holdout split
+func (split Split) isHoldout(dataKey string) bool {
+	if split.Strategy != holdoutSplit {
+		return false
+	}
+	// the 53 most significant bits give a uniform float in [0, 1)
+	return float64(split.hash(dataKey)>>11)/float64(uint64(1)<<53) < split.HoldoutRatio
+}
+
+// getFoldData returns the keys of the data of each fold of a cross-validated problem, on which
+// learnuplets of the fold are evaluated: all data of the problem but its test data, including
+// the given data registered in the transaction, which cannot be read back from the ledger yet.
+func getFoldData(APIstub shim.ChaincodeStubInterface, problemKey string, problem Problem,
+	added []string) (foldData [][]string, err error) {
+
+	dataKeys, err := getProblemItems(APIstub, problemKey, "data")
+	if err != nil {
+		return nil, err
+	}
+	excluded := make(map[string]bool)
+	for _, dataKey := range problem.TestData {
+		excluded[dataKey] = true
+	}
+	for _, dataKey := range added {
+		if !excluded[dataKey] {
+			dataKeys = append(dataKeys, dataKey)
+		}
+	}
+	sort.Strings(dataKeys)
+	foldData = make([][]string, problem.Split.Folds)
+	for _, dataKey := range dataKeys {
+		if excluded[dataKey] {
+			continue
+		}
+		excluded[dataKey] = true
+		fold := problem.Split.fold(dataKey)
+		foldData[fold] = append(foldData[fold], dataKey)
+	}
+	return foldData, nil
+}
+
+// addHoldoutData adds a new data to the test data of a problem, so that it is used for evaluation only.
+// Learnuplets not started yet are evaluated on it too.
+func addHoldoutData(APIstub shim.ChaincodeStubInterface, problemKey string, problem Problem, dataKey string) error {
+	problem.TestData = append(problem.TestData, dataKey)
+	err := storeProblem(APIstub, problemKey, problem)
+	if err != nil {
+		return err
+	}
+	_, err = refreshLearnupletTestData(APIstub, problemKey, problem.TestData)
+	return err
+}
+
+// refreshFoldTestData evaluates the learnuplets of an algo of a cross-validated problem which have not been
+// started yet on the new data of their fold. foldData are the data of each fold, including the new data.
+func refreshFoldTestData(APIstub shim.ChaincodeStubInterface, problem Problem, algoKey string,
+	newData []string, foldData [][]string) error {
+
+	chain, err := getModelChain(APIstub, problem, algoKey)
+	if err != nil {
+		return err
+	}
+	for fold := 0; fold < problem.Split.Folds; fold++ {
+		newTestData := false
+		for _, dataKey := range newData {
+			if problem.Split.fold(dataKey) == fold {
+				newTestData = true
+			}
+		}
+		if !newTestData {
+			continue
+		}
+		testData, err := getDataAddress(APIstub, foldData[fold])
+		if err != nil {
+			return err
+		}
+		for _, learnuplet := range chain.forFold(fold).learnuplets {
+			if learnuplet.Status != "todo" {
+				continue
+			}
+			learnuplet.TestData = testData
+			err = storeLearnuplet(APIstub, learnuplet.Key, learnuplet.Learnuplet)
+			if err != nil {
+				return fmt.Errorf("Problem storing learnuplet %s - %s", learnuplet.Key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// createFoldLearnuplets creates the learnuplets of an algo of a cross-validated problem for new train data,
+// following the existing learnuplets of each fold: each fold is a chain of learnuplets, training on the new
+// data of the other folds. foldData are the data of each fold, on which its learnuplets are evaluated.
+func createFoldLearnuplets(APIstub shim.ChaincodeStubInterface, problemKey string, problem Problem,
+	algoKey string, algoAddress string, trainData []string, foldData [][]string) error {
+
+	chain, err := getModelChain(APIstub, problem, algoKey)
+	if err != nil {
+		return err
+	}
+	chain.initialModel = algoAddress
+	for fold := 0; fold < problem.Split.Folds; fold++ {
+		foldChain := chain.forFold(fold)
+		var batchData []string
+		for _, dataKey := range trainData {
+			if problem.Split.fold(dataKey) != fold {
+				batchData = append(batchData, dataKey)
+			}
+		}
+		if len(batchData) == 0 {
+			continue
+		}
+		rank := foldChain.lastRank() + 1
+		modelStartAddress := ""
+		if foldChain.isSettled(rank) {
+			modelStartAddress = foldChain.startModel(rank)
+		}
+		err = createLearnuplet(
+			APIstub, batchData, problem.SizeTrainDataset, problem.Batching, foldData[fold], problemKey, problem.StorageAddress,
+			algoKey, algoAddress, modelStartAddress, rank, fold, problem.isIndependent())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("-- creation of learnuplets of fold %d of %s ok \n", fold, algoKey)
+	}
+	return nil
+}
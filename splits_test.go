@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidateSplit(t *testing.T) {
+	tests := []struct {
+		settings ProblemSettings
+		valid    bool
+		folds    int
+	}{
+		{ProblemSettings{}, true, 1},
+		{ProblemSettings{Split: Split{Strategy: kfoldSplit, Folds: 5}}, true, 5},
+		{ProblemSettings{Split: Split{Strategy: holdoutSplit, HoldoutRatio: 0.2}}, true, 1},
+		{ProblemSettings{Split: Split{Strategy: fixedSplit, Folds: 3}}, false, 0},
+		{ProblemSettings{Split: Split{Strategy: kfoldSplit, Folds: 1}}, false, 0},
+		{ProblemSettings{Split: Split{Strategy: kfoldSplit, Folds: maxFolds + 1}}, false, 0},
+		{ProblemSettings{Split: Split{Strategy: kfoldSplit, Folds: 3}, Branches: 2}, false, 0},
+		{ProblemSettings{Split: Split{Strategy: kfoldSplit, Folds: 3}, Federated: true}, false, 0},
+		{ProblemSettings{Split: Split{Strategy: holdoutSplit, HoldoutRatio: 1}}, false, 0},
+		{ProblemSettings{Split: Split{Strategy: holdoutSplit}}, false, 0},
+		{ProblemSettings{Split: Split{Strategy: "random"}}, false, 0},
+		{ProblemSettings{Split: Split{Seed: "chosen"}}, false, 0},
+	}
+	for _, test := range tests {
+		settings := test.settings
+		err := validateSplit(&settings)
+		if (err == nil) != test.valid {
+			t.Errorf("Validation of %+v should be %t - %v", test.settings, test.valid, err)
+		}
+		if err == nil && settings.Split.Folds != test.folds {
+			t.Errorf("%+v has %d folds instead of %d", test.settings, settings.Split.Folds, test.folds)
+		}
+	}
+}
+
+func TestSplitAssignment(t *testing.T) {
+	kfold := Split{Strategy: kfoldSplit, Folds: 4, Seed: "tx1"}
+	holdout := Split{Strategy: holdoutSplit, HoldoutRatio: 0.3, Seed: "tx1"}
+	folds := make([]int, kfold.Folds)
+	nbHeldOut, nbData := 0, 1000
+	for i := 0; i < nbData; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		fold := kfold.fold(dataKey)
+		if fold != kfold.fold(dataKey) || fold < 0 || fold >= kfold.Folds {
+			t.Fatalf("Fold %d of %s is not deterministic or out of range", fold, dataKey)
+		}
+		folds[fold]++
+		if holdout.isHoldout(dataKey) {
+			nbHeldOut++
+		}
+		if (Split{Strategy: fixedSplit}).isHoldout(dataKey) || holdout.fold(dataKey) != 0 {
+			t.Errorf("Only kfold splits have folds and only holdout splits hold data out")
+		}
+	}
+	for fold, nb := range folds {
+		if nb < 200 || nb > 300 {
+			t.Errorf("%d data in fold %d out of %d", nb, fold, nbData)
+		}
+	}
+	if nbHeldOut < 250 || nbHeldOut > 350 {
+		t.Errorf("%d data held out instead of about 300", nbHeldOut)
+	}
+	// another seed gives another split
+	other := Split{Strategy: kfoldSplit, Folds: 4, Seed: "tx2"}
+	nbSame := 0
+	for i := 0; i < nbData; i++ {
+		if dataKey := fmt.Sprintf("data_%d", i); other.fold(dataKey) == kfold.fold(dataKey) {
+			nbSame++
+		}
+	}
+	if nbSame > 350 {
+		t.Errorf("Splits with different seeds are too similar: %d data in the same fold", nbSame)
+	}
+}
+
+func TestCrossValidatedLearnuplets(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	learnuplets := func() []keyedLearnuplet {
+		var learnuplets []keyedLearnuplet
+		for _, key := range getKeys(t, mockStub, "learnuplet") {
+			learnuplet := keyedLearnuplet{Key: key}
+			json.Unmarshal(mockStub.State[key], &learnuplet.Learnuplet)
+			learnuplets = append(learnuplets, learnuplet)
+		}
+		return learnuplets
+	}
+
+	// ACT
+	mockStub.MockTransactionStart("mockTxID")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "10", "", `{"split": {"strategy": "kfold", "folds": 3}}`)
+	registerTestItems(t, smartContract, mockStub, problemKey, 9, "algo1")
+	mockStub.MockTransactionEnd("mockTxID")
+	problem, _ := getProblem(mockStub, problemKey)
+	created := learnuplets()
+	registeredData := getKeys(t, mockStub, "data")
+	mockStub.MockTransactionStart("mockTxID_1")
+	response := smartContract.registerItem(mockStub, []string{"data", "8fa81bfc-b5f4-4ba2-b81a-b46424800099", problemKey, ""})
+	mockStub.MockTransactionEnd("mockTxID_1")
+	afterNewData := learnuplets()
+
+	// ASSERT
+	if problem.Split.Seed != "mockTxID" || !problem.isCrossValidated() {
+		t.Fatalf("Split not recorded on the problem: %+v", problem.Split)
+	}
+	testData := make(map[string]bool)
+	for _, dataKey := range problem.TestData {
+		testData[dataKey] = true
+	}
+	foldData := make(map[int]int)
+	for _, dataKey := range registeredData {
+		if !testData[dataKey] {
+			foldData[problem.Split.fold(dataKey)]++
+		}
+	}
+	// a fold without data yet is trained too, and will be evaluated on its future data
+	if len(created) != problem.Split.Folds {
+		t.Fatalf("%d learnuplets created instead of one per fold: %v", len(created), created)
+	}
+	for _, learnuplet := range created {
+		for dataKey := range learnuplet.TrainData {
+			if problem.Split.fold(dataKey) == learnuplet.Fold || testData[dataKey] {
+				t.Errorf("Learnuplet of fold %d trains on %s", learnuplet.Fold, dataKey)
+			}
+		}
+		for dataKey := range learnuplet.TestData {
+			if problem.Split.fold(dataKey) != learnuplet.Fold {
+				t.Errorf("Learnuplet of fold %d is evaluated on %s", learnuplet.Fold, dataKey)
+			}
+		}
+		if learnuplet.Rank != 0 || learnuplet.ModelStartAddress != "8fa81bfc-b5f4-4ba2-b81a-algo1" {
+			t.Errorf("Learnuplet of fold %d should start its own chain: %+v", learnuplet.Fold, learnuplet)
+		}
+	}
+	if response.GetStatus() != 200 {
+		t.Fatalf("Registration of new data fails - %s", response.Message)
+	}
+	// the new data is evaluated by its fold and trained on by the other ones
+	newFold := -1
+	for _, dataKey := range getKeys(t, mockStub, "data") {
+		item := Item{}
+		json.Unmarshal(mockStub.State[dataKey], &item)
+		if item.StorageAddress == "8fa81bfc-b5f4-4ba2-b81a-b46424800099" {
+			newFold = problem.Split.fold(dataKey)
+		}
+	}
+	nbNew := 0
+	for _, learnuplet := range afterNewData {
+		if learnuplet.Fold == newFold && learnuplet.Rank == 0 && len(learnuplet.TestData) != foldData[newFold]+1 {
+			t.Errorf("Learnuplet of fold %d not evaluated on the new data", newFold)
+		}
+		if learnuplet.Rank == 1 {
+			nbNew++
+			if learnuplet.Fold == newFold || learnuplet.ModelStartAddress != "" {
+				t.Errorf("Learnuplet of the new data not created as expected: %+v", learnuplet)
+			}
+		}
+	}
+	if nbNew != problem.Split.Folds-1 {
+		t.Errorf("%d learnuplets created for the new data instead of %d", nbNew, problem.Split.Folds-1)
+	}
+}
+
+func TestHoldoutData(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+
+	// ACT
+	mockStub.MockTransactionStart("mockTxID")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "100", "", `{"split": {"strategy": "holdout", "holdoutRatio": 0.5}}`)
+	registerTestItems(t, smartContract, mockStub, problemKey, 10, "algo1")
+	registerTestItems(t, smartContract, mockStub, problemKey, 0, "algo2")
+	mockStub.MockTransactionEnd("mockTxID")
+	problem, _ := getProblem(mockStub, problemKey)
+
+	// ASSERT
+	testData := make(map[string]bool)
+	for _, dataKey := range problem.TestData {
+		testData[dataKey] = true
+	}
+	nbHeldOut := 0
+	for _, dataKey := range getKeys(t, mockStub, "data") {
+		item := Item{}
+		json.Unmarshal(mockStub.State[dataKey], &item)
+		// test data registered with the problem are not split
+		if problem.Split.isHoldout(dataKey) && strings.HasPrefix(item.StorageAddress, "8fa81bfc-") {
+			nbHeldOut++
+			if !testData[dataKey] {
+				t.Errorf("%s is held out but not part of the test data", dataKey)
+			}
+		}
+	}
+	// 2 test data registered with the problem
+	if nbHeldOut == 0 || len(problem.TestData) != nbHeldOut+2 {
+		t.Fatalf("%d data held out, %d test data", nbHeldOut, len(problem.TestData))
+	}
+	for _, key := range getKeys(t, mockStub, "learnuplet") {
+		learnuplet := Learnuplet{}
+		json.Unmarshal(mockStub.State[key], &learnuplet)
+		if len(learnuplet.TrainData)+nbHeldOut != 10 || len(learnuplet.TestData) != len(problem.TestData) {
+			t.Errorf("Learnuplet of %v trains on %d data and is evaluated on %d", learnuplet.Algo,
+				len(learnuplet.TrainData), len(learnuplet.TestData))
+		}
+		for dataKey := range learnuplet.TrainData {
+			if testData[dataKey] {
+				t.Errorf("Learnuplet trains on held out data %s", dataKey)
+			}
+		}
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// DataValue is the running contribution score of a train data of a problem.
+// Each done learnuplet training on the data gives a delta: the difference between the performance of its
+// model on the data and its mean performance on the train data of the learnuplet, for the primary metric
+// of the problem, positive when the performance on the data is better. Count is the number of deltas,
+// Score their mean and Variance their population variance (computed with Welford's algorithm, M2 being
+// the sum of squared differences to the mean). Data with a low score are poorly fitted by the models
+// trained on them, and might be useless or harmful (mislabelled, corrupted, out of distribution, ...).
+type DataValue struct {
+	Record
+	ObjectType string  `json:"docType"`
+	Data       string  `json:"data"`
+	Problem    string  `json:"problem"`
+	Count      int     `json:"count"`
+	Score      float64 `json:"score"`
+	Variance   float64 `json:"variance"`
+	M2         float64 `json:"m2"`
+	LastDelta  float64 `json:"lastDelta"`
+}
+
+// add updates the running score of a data with a new delta
+func (value *DataValue) add(delta float64) {
+	value.Count++
+	diff := delta - value.Score
+	value.Score += diff / float64(value.Count)
+	value.M2 += diff * (delta - value.Score)
+	value.Variance = value.M2 / float64(value.Count)
+	value.LastDelta = delta
+}
+
+// dataDeltas returns the delta of each train data of a done learnuplet, on the primary metric of its problem.
+// Data without a finite performance are ignored.
+func dataDeltas(problem Problem, trainPerf map[string]float64) map[string]float64 {
+	metric := problem.getPrimaryMetric()
+	var dataKeys []string
+	for dataKey, perf := range trainPerf {
+		if !math.IsNaN(perf) && !math.IsInf(perf, 0) {
+			dataKeys = append(dataKeys, dataKey)
+		}
+	}
+	if len(dataKeys) == 0 {
+		return nil
+	}
+	sort.Strings(dataKeys)
+	mean := 0.
+	for _, dataKey := range dataKeys {
+		mean += trainPerf[dataKey]
+	}
+	mean /= float64(len(dataKeys))
+	deltas := make(map[string]float64)
+	for _, dataKey := range dataKeys {
+		delta := trainPerf[dataKey] - mean
+		if metric.Direction == "lower" {
+			delta = -delta
+		}
+		deltas[dataKey] = delta
+	}
+	return deltas
+}
+
+// getDataValueKey returns the key of the value of a data for a problem
+func getDataValueKey(APIstub shim.ChaincodeStubInterface, problemKey string, dataKey string) (string, error) {
+	return APIstub.CreateCompositeKey("datavalue~problem~data", []string{"datavalue", problemKey, dataKey})
+}
+
+// getDataValue retrieves the value of a data for a problem, empty if it has not been trained on yet.
+// Values of data of private problems are stored in the private data collection of the problem.
+func getDataValue(APIstub shim.ChaincodeStubInterface, problemKey string, problem Problem,
+	dataKey string) (value DataValue, err error) {
+
+	value = DataValue{ObjectType: "dataValue", Data: dataKey, Problem: problemKey}
+	valueKey, err := getDataValueKey(APIstub, problemKey, dataKey)
+	if err != nil {
+		return value, err
+	}
+	var valueAsBytes []byte
+	if problem.isPrivate() {
+		valueAsBytes, err = APIstub.GetPrivateData(problem.Privacy.Collection, valueKey)
+	} else {
+		valueAsBytes, err = APIstub.GetState(valueKey)
+	}
+	if err != nil || valueAsBytes == nil {
+		return value, err
+	}
+	err = unmarshalRecord(APIstub, valueAsBytes, &value)
+	return value, err
+}
+
+// storeDataValue stores the value of a data for a problem
+func storeDataValue(APIstub shim.ChaincodeStubInterface, problem Problem, value DataValue) error {
+	valueKey, err := getDataValueKey(APIstub, value.Problem, value.Data)
+	if err != nil {
+		return err
+	}
+	valueAsBytes, err := marshalRecord(&value)
+	if err != nil {
+		return err
+	}
+	if problem.isPrivate() {
+		return APIstub.PutPrivateData(problem.Privacy.Collection, valueKey, valueAsBytes)
+	}
+	return APIstub.PutState(valueKey, valueAsBytes)
+}
+
+// updateDataValues updates the values of the train data of a done learnuplet with its train performances
+func updateDataValues(APIstub shim.ChaincodeStubInterface, problem Problem, learnuplet Learnuplet) error {
+	problemKey := getProblemKey(learnuplet)
+	deltas := dataDeltas(problem, learnuplet.TrainPerf)
+	var dataKeys []string
+	for dataKey := range deltas {
+		dataKeys = append(dataKeys, dataKey)
+	}
+	sort.Strings(dataKeys)
+	for _, dataKey := range dataKeys {
+		value, err := getDataValue(APIstub, problemKey, problem, dataKey)
+		if err != nil {
+			return err
+		}
+		value.add(deltas[dataKey])
+		err = storeDataValue(APIstub, problem, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getProblemDataValues returns the values of the data of a problem which have been trained on
+func getProblemDataValues(APIstub shim.ChaincodeStubInterface, problemKey string, problem Problem) ([]DataValue, error) {
+	var values []DataValue
+	// the values of private problems are looked for among the data of the problem
+	if problem.isPrivate() {
+		dataKeys, err := getProblemItems(APIstub, problemKey, "data")
+		if err != nil {
+			return nil, err
+		}
+		for _, dataKey := range dataKeys {
+			value, err := getDataValue(APIstub, problemKey, problem, dataKey)
+			if err != nil {
+				return nil, err
+			}
+			if value.Count > 0 {
+				values = append(values, value)
+			}
+		}
+		return values, nil
+	}
+	valueIterator, err := APIstub.GetStateByPartialCompositeKey("datavalue~problem~data", []string{"datavalue", problemKey})
+	if err != nil {
+		return nil, err
+	}
+	defer valueIterator.Close()
+	for valueIterator.HasNext() {
+		responseRange, err := valueIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		value := DataValue{}
+		err = unmarshalRecord(APIstub, responseRange.GetValue(), &value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// queryDataValue is the smart contract to get the values of the data of a problem, from the lowest score
+// to the highest, so that useless or harmful data come first.
+// Values of data of private problems are only returned to the organisations allowed to read them.
+// Args (1 or 2 strings): problemKey, optionally a data key to only get its value
+func (s *SmartContract) queryDataValue(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2: problemKey, optionally dataKey")
+	}
+	problemKey := args[0]
+	fmt.Printf("- start looking for data values of %s \n", problemKey)
+
+	problem, err := getProblem(APIstub, problemKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if problem.isPrivate() {
+		reader, err := newPrivateReader(APIstub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		allowed, err := reader.isAllowed(problemKey, "")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !allowed {
+			return shim.Error("Data values of " + problemKey + " are private")
+		}
+	}
+	var values []DataValue
+	if len(args) == 2 {
+		value, err := getDataValue(APIstub, problemKey, problem, args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		values = append(values, value)
+	} else {
+		values, err = getProblemDataValues(APIstub, problemKey, problem)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		if values[i].Score != values[j].Score {
+			return values[i].Score < values[j].Score
+		}
+		return values[i].Data < values[j].Data
+	})
+	payload, err := json.Marshal(values)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end looking for data values of %s \n", problemKey)
+	return shim.Success(payload)
+}
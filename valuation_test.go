@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestDataValueAdd(t *testing.T) {
+	value := DataValue{}
+	for _, delta := range []float64{0.1, -0.3, 0.2} {
+		value.add(delta)
+	}
+	if value.Count != 3 || math.Abs(value.Score-0) > 1e-9 || math.Abs(value.Variance-0.14/3) > 1e-9 || value.LastDelta != 0.2 {
+		t.Errorf("Running score not computed as expected: %+v", value)
+	}
+	deltas := dataDeltas(Problem{}, map[string]float64{"data_a": 0.9, "data_b": 0.8, "data_c": 0.4, "data_d": math.NaN()})
+	if len(deltas) != 3 || math.Abs(deltas["data_a"]-0.2) > 1e-9 || math.Abs(deltas["data_c"]+0.3) > 1e-9 {
+		t.Errorf("Deltas not computed as expected: %v", deltas)
+	}
+	lower := Problem{ProblemSettings: ProblemSettings{Metrics: []Metric{{Name: "logloss", Direction: "lower"}}, PrimaryMetric: "logloss"}}
+	deltas = dataDeltas(lower, map[string]float64{"data_a": 0.9, "data_b": 0.5})
+	if math.Abs(deltas["data_a"]+0.2) > 1e-9 || math.Abs(deltas["data_b"]-0.2) > 1e-9 {
+		t.Errorf("Deltas of a lower is better metric not computed as expected: %v", deltas)
+	}
+}
+
+func TestQueryDataValue(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	setCreator(t, mockStub, "OrgA")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "3")
+	registerTestItems(t, smartContract, mockStub, problemKey, 3, "algo")
+	registerTestWorkers(t, smartContract, mockStub, "Arbeiter_12")
+	mockStub.MockTransactionEnd(txId)
+	learnupletKey := getKeys(t, mockStub, "learnuplet")[0]
+	learnuplet := Learnuplet{}
+	json.Unmarshal(mockStub.State[learnupletKey], &learnuplet)
+	var trainKeys, testKeys []string
+	for dataKey := range learnuplet.TrainData {
+		trainKeys = append(trainKeys, dataKey)
+	}
+	for dataKey := range learnuplet.TestData {
+		testKeys = append(testKeys, dataKey)
+	}
+	if len(trainKeys) != 3 || len(testKeys) != 2 {
+		t.Fatalf("Learnuplet not created as expected: %+v", learnuplet)
+	}
+	mockStub.MockTransactionStart(txId)
+	assignTestWorker(t, smartContract, mockStub, learnupletKey, "Arbeiter_12")
+	reported := smartContract.reportLearn(mockStub, []string{learnupletKey, "done", "0.7",
+		fmt.Sprintf(`{"%s": 0.9, "%s": 0.8, "%s": 0.4}`, trainKeys[0], trainKeys[1], trainKeys[2]),
+		fmt.Sprintf(`{"%s": 0.7, "%s": 0.7}`, testKeys[0], testKeys[1])})
+	mockStub.MockTransactionEnd(txId)
+	var values, dataValues []DataValue
+	json.Unmarshal(smartContract.queryDataValue(mockStub, []string{problemKey}).Payload, &values)
+	json.Unmarshal(smartContract.queryDataValue(mockStub, []string{problemKey, trainKeys[0]}).Payload, &dataValues)
+
+	// ASSERT
+	if reported.Status != 200 {
+		t.Fatalf("Report of learnuplet fails - %s", reported.Message)
+	}
+	// the data the model fits worst comes first
+	if len(values) != 3 || values[0].Data != trainKeys[2] || values[2].Data != trainKeys[0] ||
+		math.Abs(values[0].Score+0.3) > 1e-9 || values[0].Count != 1 {
+		t.Errorf("Data values not computed as expected: %+v", values)
+	}
+	if len(dataValues) != 1 || dataValues[0].Data != trainKeys[0] || math.Abs(dataValues[0].Score-0.2) > 1e-9 {
+		t.Errorf("Value of a data not returned as expected: %+v", dataValues)
+	}
+}
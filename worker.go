@@ -0,0 +1,395 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Worker structure, stored with key worker_<workerID>.
+// ObjectType is worker (necessary when switching to couchDB).
+// Owner is the organisation (MSP ID) which registered the worker. Only this organisation
+// can update the worker and assign it learnuplets.
+// Capabilities are the resources of the worker.
+// Problems are the keys of the problems the worker accepts to work on, all problems if empty.
+// Status belongs to [active, inactive, banned]. Only active workers can be assigned
+// learnuplets, and a banned worker cannot be reactivated.
+// LastHeartbeat is the time of the last registration or update of the worker (RFC 3339).
+type Worker struct {
+	Record
+	ObjectType    string       `json:"docType"`
+	Owner         string       `json:"owner"`
+	Capabilities  Capabilities `json:"capabilities"`
+	Problems      []string     `json:"problems"`
+	Status        string       `json:"status"`
+	LastHeartbeat string       `json:"lastHeartbeat"`
+}
+
+// Capabilities are the resources of a worker, or the resources required to train
+// the learnuplets of a problem.
+// CPU and GPU are numbers of cores and devices, Memory is in MB.
+type Capabilities struct {
+	CPU    int `json:"cpu"`
+	GPU    int `json:"gpu"`
+	Memory int `json:"memory"`
+}
+
+// workerUpdate holds the fields of a worker to change, nil fields being kept
+type workerUpdate struct {
+	Capabilities *Capabilities `json:"capabilities"`
+	Problems     *[]string     `json:"problems"`
+	Status       *string       `json:"status"`
+}
+
+var workerIDRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// getWorkerKey returns the key of a worker on the ledger
+func getWorkerKey(workerID string) string {
+	return "worker_" + workerID
+}
+
+// validate checks that no capability is negative
+func (capabilities Capabilities) validate() error {
+	if capabilities.CPU < 0 || capabilities.GPU < 0 || capabilities.Memory < 0 {
+		return fmt.Errorf("capabilities cannot be negative")
+	}
+	return nil
+}
+
+// satisfies returns true if the capabilities meet the requirements
+func (capabilities Capabilities) satisfies(requirements Capabilities) bool {
+	return capabilities.CPU >= requirements.CPU &&
+		capabilities.GPU >= requirements.GPU &&
+		capabilities.Memory >= requirements.Memory
+}
+
+// parseCapabilities decodes and validates capabilities given as a JSON string
+func parseCapabilities(capabilitiesAsString string) (capabilities Capabilities, err error) {
+	decoder := json.NewDecoder(strings.NewReader(capabilitiesAsString))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&capabilities)
+	if err != nil {
+		return capabilities, fmt.Errorf("invalid capabilities - %s", err)
+	}
+	err = capabilities.validate()
+	if err != nil {
+		return capabilities, fmt.Errorf("invalid capabilities - %s", err)
+	}
+	return capabilities, nil
+}
+
+// checkWorkerProblems checks that the problems supported by a worker exist
+func checkWorkerProblems(APIstub shim.ChaincodeStubInterface, problems []string) error {
+	for _, problemKey := range problems {
+		_, err := getProblem(APIstub, problemKey)
+		if err != nil {
+			return fmt.Errorf("invalid supported problem - %s", err)
+		}
+	}
+	return nil
+}
+
+// getWorker returns the worker of a given identifier
+func getWorker(APIstub shim.ChaincodeStubInterface, workerID string) (worker Worker, err error) {
+	value, err := APIstub.GetState(getWorkerKey(workerID))
+	if err != nil {
+		return worker, err
+	}
+	if value == nil {
+		return worker, fmt.Errorf("no registered worker %s", workerID)
+	}
+	err = unmarshalRecord(APIstub, value, &worker)
+	return worker, err
+}
+
+// storeWorker stores a worker, setting its last heartbeat to the time of the transaction
+func storeWorker(APIstub shim.ChaincodeStubInterface, workerID string, worker Worker) error {
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	worker.LastHeartbeat = txTime.Format(time.RFC3339)
+	workerAsBytes, err := marshalRecord(&worker)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(getWorkerKey(workerID), workerAsBytes)
+}
+
+// supportsProblem returns true if a worker accepts to work on a problem
+func (worker Worker) supportsProblem(problemKey string) bool {
+	if len(worker.Problems) == 0 {
+		return true
+	}
+	for _, supported := range worker.Problems {
+		if supported == problemKey {
+			return true
+		}
+	}
+	return false
+}
+
+// canTrain checks that a worker can be assigned learnuplets of a problem:
+// the worker is active, supports the problem and meets its requirements
+func (worker Worker) canTrain(problemKey string, problem Problem) error {
+	if worker.Status != "active" {
+		return fmt.Errorf("worker is %s", worker.Status)
+	}
+	if !worker.supportsProblem(problemKey) {
+		return fmt.Errorf("worker does not support problem %s", problemKey)
+	}
+	if !worker.Capabilities.satisfies(problem.Requirements) {
+		return fmt.Errorf("worker capabilities %+v do not meet requirements %+v of problem %s",
+			worker.Capabilities, problem.Requirements, problemKey)
+	}
+	return nil
+}
+
+// canTrainLearnuplet returns true if the learnuplet is not restricted to another organisation
+// than the one of the worker, as learnuplets of federated problems are
+func (worker Worker) canTrainLearnuplet(learnuplet Learnuplet) bool {
+	return learnuplet.Organisation == "" || learnuplet.Organisation == worker.Owner
+}
+
+// checkAssignment checks that the caller can assign a learnuplet to a worker: the worker is
+// registered by the organisation of the caller and can train the learnuplet and its problem,
+// and the usage policies of its train data allow the organisation of the worker
+func checkAssignment(APIstub shim.ChaincodeStubInterface, workerID string, learnuplet Learnuplet) error {
+	worker, err := getWorker(APIstub, workerID)
+	if err != nil {
+		return err
+	}
+	callerOrg, err := getCallerOrg(APIstub)
+	if err != nil {
+		return err
+	}
+	if callerOrg != worker.Owner {
+		return fmt.Errorf("worker %s belongs to %s, not to %s", workerID, worker.Owner, callerOrg)
+	}
+	if !worker.canTrainLearnuplet(learnuplet) {
+		return fmt.Errorf("learnuplet is trained by %s, not by %s", learnuplet.Organisation, worker.Owner)
+	}
+	problemKey := getProblemKey(learnuplet)
+	problem, err := getProblem(APIstub, problemKey)
+	if err != nil {
+		return err
+	}
+	err = worker.canTrain(problemKey, problem)
+	if err != nil {
+		return fmt.Errorf("worker %s cannot train learnuplets of problem %s - %s", workerID, problemKey, err)
+	}
+	return checkDataPolicies(APIstub, learnuplet, worker.Owner, make(map[string]Item))
+}
+
+// registerWorker is the smart contract to register a Compute worker for the organisation of the caller
+// Args (2 or 3 strings): workerID, capabilities ("{\"cpu\": 8, \"gpu\": 1, \"memory\": 16000}"),
+// optionally supported problems ("[\"problem_xxx\", ...]", all problems if not given)
+func (s *SmartContract) registerWorker(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3: workerID, capabilities, optionally problems")
+	}
+	workerID := args[0]
+	fmt.Printf("- start register worker %s \n", workerID)
+
+	if !workerIDRegexp.MatchString(workerID) {
+		return shim.Error(fmt.Sprintf("worker identifier must match %s", workerIDRegexp.String()))
+	}
+	value, err := APIstub.GetState(getWorkerKey(workerID))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if value != nil {
+		return shim.Error("Worker already registered - " + workerID)
+	}
+	capabilities, err := parseCapabilities(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var problems []string
+	if len(args) == 3 && strings.TrimSpace(args[2]) != "" {
+		err = json.Unmarshal([]byte(args[2]), &problems)
+		if err != nil {
+			return shim.Error("invalid supported problems - " + err.Error())
+		}
+	}
+	err = checkWorkerProblems(APIstub, problems)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	owner, err := getCallerOrg(APIstub)
+	if err != nil {
+		return shim.Error("Problem getting organisation of the caller - " + err.Error())
+	}
+
+	worker := Worker{ObjectType: "worker", Owner: owner, Capabilities: capabilities,
+		Problems: problems, Status: "active"}
+	err = storeWorker(APIstub, workerID, worker)
+	if err != nil {
+		return shim.Error("Problem storing worker - " + err.Error())
+	}
+	fmt.Printf("- end register worker %s \n", workerID)
+	return shim.Success(nil)
+}
+
+// updateWorker is the smart contract to update a worker. It also serves as heartbeat of the worker,
+// since its last heartbeat is set to the time of the update.
+// Only the organisation which registered the worker can update it.
+// Args (1 or 2 strings): workerID, optionally the fields to change
+// ("{\"capabilities\": {...}, \"problems\": [...], \"status\": \"inactive\"}")
+func (s *SmartContract) updateWorker(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2: workerID, optionally update")
+	}
+	workerID := args[0]
+	fmt.Printf("- start update worker %s \n", workerID)
+
+	worker, err := getWorker(APIstub, workerID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	callerOrg, err := getCallerOrg(APIstub)
+	if err != nil {
+		return shim.Error("Problem getting organisation of the caller - " + err.Error())
+	}
+	if callerOrg != worker.Owner {
+		return shim.Error(fmt.Sprintf("Worker %s belongs to %s, not to %s", workerID, worker.Owner, callerOrg))
+	}
+	if worker.Status == "banned" {
+		return shim.Error("Worker is banned - " + workerID)
+	}
+
+	update := workerUpdate{}
+	if len(args) == 2 && strings.TrimSpace(args[1]) != "" {
+		decoder := json.NewDecoder(strings.NewReader(args[1]))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&update)
+		if err != nil {
+			return shim.Error("invalid worker update - " + err.Error())
+		}
+	}
+	if update.Capabilities != nil {
+		err = update.Capabilities.validate()
+		if err != nil {
+			return shim.Error("invalid capabilities - " + err.Error())
+		}
+		worker.Capabilities = *update.Capabilities
+	}
+	if update.Problems != nil {
+		err = checkWorkerProblems(APIstub, *update.Problems)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		worker.Problems = *update.Problems
+	}
+	if update.Status != nil {
+		status := *update.Status
+		if status != "active" && status != "inactive" && status != "banned" {
+			return shim.Error("Worker status must be active, inactive or banned")
+		}
+		worker.Status = status
+	}
+
+	err = storeWorker(APIstub, workerID, worker)
+	if err != nil {
+		return shim.Error("Problem storing worker - " + err.Error())
+	}
+	fmt.Printf("- end update worker %s \n", workerID)
+	return shim.Success(nil)
+}
+
+// queryWorkers is the smart contract to get registered workers, optionally restricted
+// to the ones supporting a problem and to the ones with a given status
+// Args (0 to 2 strings): optionally problemKey ("" for all problems), optionally status
+func (s *SmartContract) queryWorkers(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 0 to 2: optionally problemKey and status")
+	}
+	problemKey := ""
+	if len(args) >= 1 {
+		problemKey = args[0]
+	}
+	status := ""
+	if len(args) == 2 {
+		status = args[1]
+	}
+	fmt.Printf("- start looking for workers of problem %s \n", problemKey)
+
+	// '`' is the character following '_': the range covers all keys starting with worker_
+	resultsIterator, err := APIstub.GetStateByRange("worker_", "worker`")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var workers []map[string]interface{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		worker := Worker{}
+		err = unmarshalRecord(APIstub, queryResponse.GetValue(), &worker)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if (problemKey != "" && !worker.supportsProblem(problemKey)) || (status != "" && worker.Status != status) {
+			continue
+		}
+		var object map[string]interface{}
+		err = unmarshalRecord(APIstub, queryResponse.GetValue(), &object)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		object["key"] = queryResponse.GetKey()
+		workers = append(workers, object)
+	}
+
+	payload, err := json.Marshal(workers)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end looking for workers of problem %s \n", problemKey)
+	return shim.Success(payload)
+}
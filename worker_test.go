@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// registerTestWorkers registers active workers supporting all problems
+func registerTestWorkers(t *testing.T, smartContract *SmartContract, mockStub *testStub, workerIDs ...string) {
+	for _, workerID := range workerIDs {
+		response := smartContract.registerWorker(mockStub, []string{workerID, `{"cpu": 4, "memory": 8000}`})
+		if response.GetStatus() != 200 {
+			t.Fatalf("Registration of worker %s fails - %s", workerID, response.Message)
+		}
+	}
+}
+
+// assignTestWorker assigns a learnuplet to a registered worker, so that it can be reported
+func assignTestWorker(t *testing.T, smartContract *SmartContract, mockStub *testStub, learnupletKey string, workerID string) {
+	response := smartContract.setUpletWorker(mockStub, []string{learnupletKey, workerID})
+	if response.GetStatus() != 200 {
+		t.Fatalf("Assignment of %s to worker %s fails - %s", learnupletKey, workerID, response.Message)
+	}
+}
+
+func TestRegisterWorker(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+	wrongArgs := map[string][]string{
+		"invalid identifier":   {"worker 1", `{"cpu": 4}`},
+		"negative capability":  {"Arbeiter_12", `{"cpu": -1}`},
+		"unknown capability":   {"Arbeiter_12", `{"tpu": 1}`},
+		"unknown problem":      {"Arbeiter_12", `{"cpu": 4}`, `["problem_unknown"]`},
+		"wrong number of args": {"Arbeiter_12"},
+	}
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	setCreator(t, mockStub, "ComputeOrg")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1")
+	response := smartContract.registerWorker(mockStub, []string{"Arbeiter_12", `{"cpu": 4, "gpu": 1, "memory": 8000}`, `["` + problemKey + `"]`})
+	duplicate := smartContract.registerWorker(mockStub, []string{"Arbeiter_12", `{"cpu": 4}`})
+	wrongResponses := make(map[string]int32)
+	for name, args := range wrongArgs {
+		wrongResponses[name] = smartContract.registerWorker(mockStub, args).Status
+	}
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	if response.GetStatus() != 200 {
+		t.Fatalf("registerWorker fails - %s", response.Message)
+	}
+	worker := Worker{}
+	json.Unmarshal(mockStub.State["worker_Arbeiter_12"], &worker)
+	if worker.Owner != "ComputeOrg" || worker.Status != "active" || worker.Capabilities.GPU != 1 ||
+		len(worker.Problems) != 1 || worker.Problems[0] != problemKey || worker.LastHeartbeat == "" {
+		t.Errorf("Worker not stored as expected: %+v", worker)
+	}
+	if duplicate.GetStatus() == 200 {
+		t.Errorf("A worker should not be registered twice")
+	}
+	for name, status := range wrongResponses {
+		if status == 200 {
+			t.Errorf("registerWorker should fail with %s", name)
+		}
+	}
+}
+
+func TestUpdateWorker(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+
+	// ACT
+	mockStub.MockTransactionStart("mockTxID_0")
+	setCreator(t, mockStub, "ComputeOrg")
+	registerTestWorkers(t, smartContract, mockStub, "Arbeiter_12")
+	mockStub.MockTransactionEnd("mockTxID_0")
+	mockStub.MockTransactionStart("mockTxID_1")
+	heartbeat := smartContract.updateWorker(mockStub, []string{"Arbeiter_12"})
+	update := smartContract.updateWorker(mockStub, []string{"Arbeiter_12", `{"capabilities": {"cpu": 16, "gpu": 2, "memory": 64000}, "status": "inactive"}`})
+	wrongStatus := smartContract.updateWorker(mockStub, []string{"Arbeiter_12", `{"status": "sleeping"}`})
+	unknownField := smartContract.updateWorker(mockStub, []string{"Arbeiter_12", `{"owner": "OtherOrg"}`})
+	setCreator(t, mockStub, "OtherOrg")
+	otherOrg := smartContract.updateWorker(mockStub, []string{"Arbeiter_12", `{"status": "active"}`})
+	setCreator(t, mockStub, "ComputeOrg")
+	ban := smartContract.updateWorker(mockStub, []string{"Arbeiter_12", `{"status": "banned"}`})
+	mockStub.MockTransactionEnd("mockTxID_1")
+	mockStub.MockTransactionStart("mockTxID_2")
+	reactivate := smartContract.updateWorker(mockStub, []string{"Arbeiter_12", `{"status": "active"}`})
+	unknownWorker := smartContract.updateWorker(mockStub, []string{"Arbeiter_13"})
+	mockStub.MockTransactionEnd("mockTxID_2")
+
+	// ASSERT
+	for _, response := range []string{heartbeat.Message, update.Message, ban.Message} {
+		if response != "" {
+			t.Fatalf("updateWorker fails - %s", response)
+		}
+	}
+	worker := Worker{}
+	json.Unmarshal(mockStub.State["worker_Arbeiter_12"], &worker)
+	if worker.Status != "banned" || worker.Capabilities.CPU != 16 || worker.Capabilities.Memory != 64000 || worker.Owner != "ComputeOrg" {
+		t.Errorf("Worker not updated as expected: %+v", worker)
+	}
+	if wrongStatus.GetStatus() == 200 || unknownField.GetStatus() == 200 {
+		t.Errorf("updateWorker should fail with an invalid update")
+	}
+	if otherOrg.GetStatus() == 200 {
+		t.Errorf("updateWorker should fail for another organisation")
+	}
+	if reactivate.GetStatus() == 200 {
+		t.Errorf("A banned worker should not be reactivated")
+	}
+	if unknownWorker.GetStatus() == 200 {
+		t.Errorf("updateWorker should fail for an unknown worker")
+	}
+}
+
+func TestQueryWorkers(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1")
+	registerTestWorkers(t, smartContract, mockStub, "Arbeiter_12", "Arbeiter_13")
+	smartContract.registerWorker(mockStub, []string{"Arbeiter_14", `{"cpu": 4}`, `["problem_other"]`})
+	smartContract.registerWorker(mockStub, []string{"Arbeiter_15", `{"cpu": 4}`, `["` + problemKey + `"]`})
+	smartContract.updateWorker(mockStub, []string{"Arbeiter_13", `{"status": "inactive"}`})
+	all := smartContract.queryWorkers(mockStub, []string{})
+	problemActive := smartContract.queryWorkers(mockStub, []string{problemKey, "active"})
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	var allWorkers, problemWorkers []map[string]interface{}
+	json.Unmarshal(all.GetPayload(), &allWorkers)
+	json.Unmarshal(problemActive.GetPayload(), &problemWorkers)
+	// Arbeiter_14 is not registered since problem_other does not exist
+	if len(allWorkers) != 3 {
+		t.Errorf("%d workers returned instead of 3", len(allWorkers))
+	}
+	if len(problemWorkers) != 2 || problemWorkers[0]["key"] != "worker_Arbeiter_12" || problemWorkers[1]["key"] != "worker_Arbeiter_15" {
+		t.Errorf("Unexpected active workers of the problem: %v", problemWorkers)
+	}
+}
+
+func TestAssignWorkerChecks(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	txId := "mockTxID"
+
+	// ACT
+	mockStub.MockTransactionStart(txId)
+	setCreator(t, mockStub, "ComputeOrg")
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1", "", `{"requirements": {"gpu": 1}}`)
+	registerTestItems(t, smartContract, mockStub, problemKey, 2, "algo1")
+	learnupletKey := ""
+	for _, key := range getKeys(t, mockStub, "learnuplet") {
+		learnuplet := Learnuplet{}
+		json.Unmarshal(mockStub.State[key], &learnuplet)
+		if learnuplet.Rank == 0 {
+			learnupletKey = key
+		}
+	}
+	registerTestWorkers(t, smartContract, mockStub, "cpu_worker")
+	smartContract.registerWorker(mockStub, []string{"gpu_worker", `{"cpu": 4, "gpu": 2}`})
+	smartContract.registerWorker(mockStub, []string{"idle_worker", `{"gpu": 2}`})
+	smartContract.updateWorker(mockStub, []string{"idle_worker", `{"status": "inactive"}`})
+	responses := make(map[string]int32)
+	for _, worker := range []string{"unknown_worker", "cpu_worker", "idle_worker"} {
+		responses[worker] = smartContract.setUpletWorker(mockStub, []string{learnupletKey, worker}).Status
+	}
+	setCreator(t, mockStub, "OtherOrg")
+	otherOrg := smartContract.setUpletWorker(mockStub, []string{learnupletKey, "gpu_worker"})
+	claimOtherOrg := smartContract.claimNextLearnuplet(mockStub, []string{"gpu_worker"})
+	setCreator(t, mockStub, "ComputeOrg")
+	cpuClaim := smartContract.claimNextLearnuplet(mockStub, []string{"cpu_worker"})
+	gpuClaim := smartContract.claimNextLearnuplet(mockStub, []string{"gpu_worker"})
+	mockStub.MockTransactionEnd(txId)
+
+	// ASSERT
+	for worker, status := range responses {
+		if status == 200 {
+			t.Errorf("setUpletWorker should fail for %s", worker)
+		}
+	}
+	if otherOrg.GetStatus() == 200 || claimOtherOrg.GetStatus() == 200 {
+		t.Errorf("A worker should only be assigned learnuplets by its organisation")
+	}
+	if cpuClaim.GetStatus() == 200 {
+		t.Errorf("A worker not meeting the requirements of the problem should not claim its learnuplets")
+	}
+	if gpuClaim.GetStatus() != 200 {
+		t.Fatalf("claimNextLearnuplet fails - %s", gpuClaim.Message)
+	}
+	learnuplet := Learnuplet{}
+	json.Unmarshal(mockStub.State[learnupletKey], &learnuplet)
+	if learnuplet.Worker != "gpu_worker" || learnuplet.Status != "pending" {
+		t.Errorf("Learnuplet not assigned to gpu_worker: %s, %s", learnuplet.Worker, learnuplet.Status)
+	}
+}
@@ -0,0 +1,255 @@
+/*
+Copyright Morpheo Org. 2017
+
+ contact@morpheo.co
+
+ This software is part of the Morpheo project, an open-source machine
+ learning platform.
+ This software is governed by the CeCILL license, compatible with the
+ GNU GPL, under French law and abiding by the rules of distribution of
+ free software. You can  use, modify and/ or redistribute the software
+ under the terms of the CeCILL license as circulated by CEA, CNRS and
+ INRIA at the following URL "http://www.cecill.info".
+
+ As a counterpart to the access to the source code and  rights to copy,
+ modify and redistribute granted by the license, users are provided only
+ with a limited warranty  and the software's author,  the holder of the
+ economic rights,  and the successive licensors  have only  limited
+ liability.
+
+ In this respect, the user's attention is drawn to the risks associated
+ with loading,  using,  modifying and/or developing or reproducing the
+ software by the user in light of its specific status of free software,
+ that may mean  that it is complicated to manipulate,  and  that  also
+ therefore means  that it is reserved for developers  and  experienced
+ professionals having in-depth computer knowledge. Users are therefore
+ encouraged to load and test the software's suitability as regards their
+ requirements in conditions enabling the security of their systems and/or
+ data to be ensured and,  more generally, to use and operate it in the
+ same conditions as regards security.
+
+ The fact that you are presently reading this means that you have had
+ knowledge of the CeCILL license and that you accept its terms.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// WorkerStats structure, stored with key workerstats_<workerID>.
+// ObjectType is workerStats (necessary when switching to couchDB).
+// Claimed is the number of learnuplets assigned to the worker, and Done, Failed and TimedOut
+// the numbers of these learnuplets reported done, reported failed, or timed out.
+// MeanDuration is the mean time in seconds between assignment and report of done learnuplets.
+type WorkerStats struct {
+	Record
+	ObjectType   string  `json:"docType"`
+	Claimed      int     `json:"claimed"`
+	Done         int     `json:"done"`
+	Failed       int     `json:"failed"`
+	TimedOut     int     `json:"timedOut"`
+	MeanDuration float64 `json:"meanDuration"`
+}
+
+// keyedWorkerStats are the statistics of a worker with its identifier and reputation
+type keyedWorkerStats struct {
+	Worker string `json:"worker"`
+	WorkerStats
+	Reputation float64 `json:"reputation"`
+}
+
+// getWorkerStatsKey returns the key of the statistics of a worker on the ledger
+func getWorkerStatsKey(workerID string) string {
+	return "workerstats_" + workerID
+}
+
+// reputation is the share of the finished learnuplets of the worker which were done,
+// smoothed so that a worker without history has a reputation of 0.5
+func (stats WorkerStats) reputation() float64 {
+	return float64(stats.Done+1) / float64(stats.Done+stats.Failed+stats.TimedOut+2)
+}
+
+// isReliable returns false if the worker failed or timed out on too many learnuplets: it has finished
+// at least minFinishedForReputation learnuplets and its reputation is lower than minReputation (see Config)
+func (stats WorkerStats) isReliable(config Config) bool {
+	return stats.Done+stats.Failed+stats.TimedOut < config.MinFinishedForReputation ||
+		stats.reputation() >= config.MinReputation
+}
+
+// getWorkerStats returns the statistics of a worker, empty if it has never been assigned a learnuplet
+func getWorkerStats(APIstub shim.ChaincodeStubInterface, workerID string) (stats WorkerStats, err error) {
+	value, err := APIstub.GetState(getWorkerStatsKey(workerID))
+	if err != nil {
+		return stats, err
+	}
+	if value == nil {
+		return WorkerStats{ObjectType: "workerStats"}, nil
+	}
+	err = unmarshalRecord(APIstub, value, &stats)
+	return stats, err
+}
+
+// updateWorkerStats applies a change to the statistics of a worker and stores them
+func updateWorkerStats(APIstub shim.ChaincodeStubInterface, workerID string, change func(*WorkerStats)) error {
+	stats, err := getWorkerStats(APIstub, workerID)
+	if err != nil {
+		return err
+	}
+	change(&stats)
+	statsAsBytes, err := marshalRecord(&stats)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(getWorkerStatsKey(workerID), statsAsBytes)
+}
+
+// recordReport updates the statistics of the worker of a learnuplet reported done or failed.
+// Learnuplets reported without having been assigned to a worker are ignored.
+func recordReport(APIstub shim.ChaincodeStubInterface, learnuplet Learnuplet, status string) error {
+	if learnuplet.Worker == "" {
+		return nil
+	}
+	if status == "failed" {
+		return updateWorkerStats(APIstub, learnuplet.Worker, func(stats *WorkerStats) {
+			stats.Failed++
+		})
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	assignedAt, err := time.Parse(time.RFC3339, learnuplet.AssignedAt)
+	if err != nil {
+		return fmt.Errorf("invalid assignment time of learnuplet - %s", err)
+	}
+	duration := txTime.Sub(assignedAt).Seconds()
+	return updateWorkerStats(APIstub, learnuplet.Worker, func(stats *WorkerStats) {
+		stats.Done++
+		stats.MeanDuration += (duration - stats.MeanDuration) / float64(stats.Done)
+	})
+}
+
+// timeoutLearnuplet is the smart contract to give back to the scheduler a learnuplet whose worker
+// did not report it in time. The learnuplet is set todo again and counted as timed out for the worker,
+// unless it was already retried MaxRetries times (see Config): it is then failed.
+// Args (1 string): learnupletKey
+func (s *SmartContract) timeoutLearnuplet(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1: learnupletKey")
+	}
+	upletKey := args[0]
+	fmt.Printf("- start timeout of %s \n", upletKey)
+
+	value, err := APIstub.GetState(upletKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if value == nil {
+		return shim.Error("No learnuplet with key - " + upletKey)
+	}
+	learnuplet := Learnuplet{}
+	err = unmarshalRecord(APIstub, value, &learnuplet)
+	if err != nil {
+		return shim.Error("Problem Unmarshal uplet - " + err.Error())
+	}
+	if learnuplet.Status != "pending" {
+		return shim.Error("Uplet is not pending but " + learnuplet.Status)
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config, err := getConfig(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// learnuplets assigned without recording the time can be timed out at once
+	assignedAt, err := time.Parse(time.RFC3339, learnuplet.AssignedAt)
+	if err == nil && txTime.Sub(assignedAt) < config.learnupletTimeout() {
+		return shim.Error(fmt.Sprintf("Uplet assigned at %s has not timed out yet", learnuplet.AssignedAt))
+	}
+
+	worker := learnuplet.Worker
+	if learnuplet.Attempts > config.MaxRetries {
+		problem, err := getProblem(APIstub, getProblemKey(learnuplet))
+		if err != nil {
+			return shim.Error("Problem getting problem of uplet - " + err.Error())
+		}
+		err = failLearnuplet(APIstub, problem, upletKey, learnuplet)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		learnuplet.Worker = ""
+		learnuplet.AssignedAt = ""
+		err = setLearnupletStatus(APIstub, upletKey, learnuplet, "todo")
+		if err != nil {
+			return shim.Error("Problem storing learnuplet - " + err.Error())
+		}
+	}
+	err = updateWorkerStats(APIstub, worker, func(stats *WorkerStats) {
+		stats.TimedOut++
+	})
+	if err != nil {
+		return shim.Error("Problem updating worker statistics - " + err.Error())
+	}
+	fmt.Printf("- end timeout of %s \n", upletKey)
+	return shim.Success(nil)
+}
+
+// queryWorkerStats is the smart contract to get the statistics and reputation of workers
+// Args (0 or 1 string): optionally workerID (all registered workers otherwise)
+func (s *SmartContract) queryWorkerStats(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) > 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 0 or 1: optionally workerID")
+	}
+	fmt.Println("- start looking for worker statistics")
+
+	var workerIDs []string
+	if len(args) == 1 {
+		_, err := getWorker(APIstub, args[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		workerIDs = append(workerIDs, args[0])
+	} else {
+		// '`' is the character following '_': the range covers all keys starting with worker_
+		resultsIterator, err := APIstub.GetStateByRange("worker_", "worker`")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		defer resultsIterator.Close()
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			workerIDs = append(workerIDs, queryResponse.GetKey()[len("worker_"):])
+		}
+	}
+
+	var allStats []keyedWorkerStats
+	for _, workerID := range workerIDs {
+		stats, err := getWorkerStats(APIstub, workerID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		allStats = append(allStats, keyedWorkerStats{workerID, stats, stats.reputation()})
+	}
+
+	payload, err := json.Marshal(allStats)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Println("- end looking for worker statistics")
+	return shim.Success(payload)
+}
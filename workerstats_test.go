@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+func TestWorkerStats(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	start := int64(1500000000)
+	// startTx starts a transaction a given number of seconds after start
+	startTx := func(txId string, seconds int64) {
+		mockStub.MockTransactionStart(txId)
+		mockStub.TxTimestamp = &timestamp.Timestamp{Seconds: start + seconds}
+	}
+	claim := func() keyedLearnuplet {
+		response := smartContract.claimNextLearnuplet(mockStub, []string{"Arbeiter_12"})
+		if response.GetStatus() != 200 {
+			t.Fatalf("claimNextLearnuplet fails - %s", response.Message)
+		}
+		claimed := keyedLearnuplet{}
+		json.Unmarshal(response.GetPayload(), &claimed)
+		return claimed
+	}
+
+	// ACT
+	startTx("mockTxID_0", 0)
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1")
+	registerTestItems(t, smartContract, mockStub, problemKey, 3, "algo1")
+	registerTestWorkers(t, smartContract, mockStub, "Arbeiter_12")
+	first := claim()
+	mockStub.MockTransactionEnd("mockTxID_0")
+	// the first learnuplet is done in 100 seconds
+	startTx("mockTxID_1", 100)
+	done := smartContract.reportLearn(mockStub, testReport(t, mockStub, first.Key, "done"))
+	mockStub.MockTransactionEnd("mockTxID_1")
+	// the second one times out, and fails once claimed again
+	startTx("mockTxID_2", 200)
+	second := claim()
+	earlyTimeout := smartContract.timeoutLearnuplet(mockStub, []string{second.Key})
+	mockStub.MockTransactionEnd("mockTxID_2")
+	startTx("mockTxID_3", 200+int64(defaultConfig.LearnupletTimeout))
+	timeout := smartContract.timeoutLearnuplet(mockStub, []string{second.Key})
+	mockStub.MockTransactionEnd("mockTxID_3")
+	timedOut := Learnuplet{}
+	json.Unmarshal(mockStub.State[second.Key], &timedOut)
+	startTx("mockTxID_4", 300+int64(defaultConfig.LearnupletTimeout))
+	again := claim()
+	failed := smartContract.reportLearn(mockStub, []string{again.Key, "failed", "", "", ""})
+	response := smartContract.queryWorkerStats(mockStub, []string{"Arbeiter_12"})
+	unknownWorker := smartContract.queryWorkerStats(mockStub, []string{"Arbeiter_13"})
+	mockStub.MockTransactionEnd("mockTxID_4")
+
+	// ASSERT
+	if done.GetStatus() != 200 || failed.GetStatus() != 200 {
+		t.Fatalf("reportLearn fails - %s%s", done.Message, failed.Message)
+	}
+	if earlyTimeout.GetStatus() == 200 {
+		t.Errorf("timeoutLearnuplet should fail before the timeout")
+	}
+	if timeout.GetStatus() != 200 {
+		t.Fatalf("timeoutLearnuplet fails - %s", timeout.Message)
+	}
+	if timedOut.Status != "todo" || timedOut.Worker != "" || again.Key != second.Key {
+		t.Errorf("Timed out learnuplet not given back to the scheduler")
+	}
+	if unknownWorker.GetStatus() == 200 {
+		t.Errorf("queryWorkerStats should fail for an unknown worker")
+	}
+	var stats []keyedWorkerStats
+	json.Unmarshal(response.GetPayload(), &stats)
+	if len(stats) != 1 {
+		t.Fatalf("%d worker statistics returned instead of 1", len(stats))
+	}
+	expected := keyedWorkerStats{"Arbeiter_12", WorkerStats{Record{latestSchemaVersion()}, "workerStats", 3, 1, 1, 1, 100}, 0.4}
+	if stats[0] != expected {
+		t.Errorf("Worker statistics are %+v instead of %+v", stats[0], expected)
+	}
+}
+
+func TestWorkerReliability(t *testing.T) {
+	tests := []struct {
+		stats    WorkerStats
+		reliable bool
+	}{
+		{WorkerStats{}, true},
+		// too few finished learnuplets to judge the worker
+		{WorkerStats{Failed: 4}, true},
+		{WorkerStats{Failed: 3, TimedOut: 2}, false},
+		{WorkerStats{Done: 4, Failed: 4}, true},
+		{WorkerStats{Done: 2, Failed: 4, TimedOut: 2}, false},
+	}
+	for _, test := range tests {
+		if test.stats.isReliable(defaultConfig) != test.reliable {
+			t.Errorf("Reliability of %+v should be %t", test.stats, test.reliable)
+		}
+	}
+}
+
+func TestTimeoutRetries(t *testing.T) {
+	// ARRANGE
+	smartContract := new(SmartContract)
+	mockStub := newTestStub("mockstub", smartContract)
+	config := defaultConfig
+	config.Admins = []string{"OrgA"}
+	config.Quorum = 1
+	config.MaxRetries = 1
+	start := int64(1500000000)
+	var key string
+	// claimAndTimeout claims the learnuplet, and times it out in the next transaction
+	claimAndTimeout := func(attempt int64) sc.Response {
+		seconds := start + 2*attempt*int64(config.LearnupletTimeout)
+		mockStub.MockTransactionStart(fmt.Sprintf("mockTxID_claim_%d", attempt))
+		mockStub.TxTimestamp = &timestamp.Timestamp{Seconds: seconds}
+		smartContract.claimNextLearnuplet(mockStub, []string{"Arbeiter_12"})
+		mockStub.MockTransactionEnd(fmt.Sprintf("mockTxID_claim_%d", attempt))
+		mockStub.MockTransactionStart(fmt.Sprintf("mockTxID_timeout_%d", attempt))
+		defer mockStub.MockTransactionEnd(fmt.Sprintf("mockTxID_timeout_%d", attempt))
+		mockStub.TxTimestamp = &timestamp.Timestamp{Seconds: seconds + int64(config.LearnupletTimeout)}
+		return smartContract.timeoutLearnuplet(mockStub, []string{key})
+	}
+
+	// ACT
+	mockStub.MockTransactionStart("mockTxID")
+	storeConfig(mockStub, config)
+	problemKey := registerTestProblem(t, smartContract, mockStub, "1")
+	registerTestItems(t, smartContract, mockStub, problemKey, 1, "algo1")
+	registerTestWorkers(t, smartContract, mockStub, "Arbeiter_12")
+	mockStub.MockTransactionEnd("mockTxID")
+	key = getKeys(t, mockStub, "learnuplet")[0]
+	first := claimAndTimeout(0)
+	retried := Learnuplet{}
+	json.Unmarshal(mockStub.State[key], &retried)
+	second := claimAndTimeout(1)
+	failed := Learnuplet{}
+	json.Unmarshal(mockStub.State[key], &failed)
+	stats, _ := getWorkerStats(mockStub, "Arbeiter_12")
+
+	// ASSERT
+	if first.GetStatus() != 200 || second.GetStatus() != 200 {
+		t.Fatalf("timeoutLearnuplet fails - %s%s", first.Message, second.Message)
+	}
+	if retried.Status != "todo" || retried.Attempts != 1 {
+		t.Errorf("Learnuplet not retried after its first timeout: %+v", retried)
+	}
+	if failed.Status != "failed" || failed.Attempts != 2 {
+		t.Errorf("Learnuplet not failed after its retries: %+v", failed)
+	}
+	if stats.TimedOut != 2 || stats.Failed != 0 {
+		t.Errorf("Timeouts not counted in worker statistics: %+v", stats)
+	}
+}